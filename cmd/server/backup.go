@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/pretty-andrechal/defirates/internal/database"
+)
+
+// runBackup implements the "backup" subcommand, safe while the server runs
+func runBackup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	dbPath := fs.String("db", defaultDB(), dbUsage)
+	out := fs.String("out", "", "File to write the backup to")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *out == "" {
+		return fmt.Errorf("-out is required")
+	}
+	// The live database is copied as is: neither created nor migrated
+	db, err := openExisting(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := db.Backup(context.Background(), *out); err != nil {
+		return err
+	}
+	fmt.Printf("Backed up %s to %s\n", *dbPath, *out)
+	return nil
+}
+
+// runRestore implements the "restore" subcommand. The server must be stopped
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	dbPath := fs.String("db", defaultDB(), dbUsage)
+	in := fs.String("in", "", "Backup file to restore")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *in == "" {
+		return fmt.Errorf("-in is required")
+	}
+
+	db, err := database.New(*dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	if err := db.Restore(context.Background(), *in); err != nil {
+		db.Close()
+		return err
+	}
+	if err := db.Close(); err != nil {
+		return err
+	}
+
+	// Reopening migrates backups taken by older versions
+	db, err = database.New(*dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to migrate restored database: %w", err)
+	}
+	db.Close()
+	fmt.Printf("Restored %s from %s\n", *dbPath, *in)
+	return nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// filterFlags are the models.FilterParams of the commands reading rates,
+// named after the query parameters of the dashboard
+type filterFlags struct {
+	asset     *string
+	chain     *string
+	protocol  *string
+	category  *string
+	minAPY    *float64
+	maxAPY    *float64
+	minTVL    *float64
+	minDays   *int
+	maxDays   *int
+	after     *string
+	before    *string
+	term      *string
+	sortBy    *string
+	sortOrder *string
+	tradeSize *float64
+}
+
+func addFilterFlags(fs *flag.FlagSet) *filterFlags {
+	return &filterFlags{
+		asset:     fs.String("asset", "", "Only rates of this asset, e.g. USDC"),
+		chain:     fs.String("chain", "", "Only rates on this chain, e.g. Arbitrum"),
+		protocol:  fs.String("protocol", "", "Only rates of this protocol, e.g. Pendle"),
+		category:  fs.String("category", "", "Only rates in this category, e.g. stables, eth or points"),
+		minAPY:    fs.Float64("min-apy", 0, "Minimum APY in percent"),
+		maxAPY:    fs.Float64("max-apy", 0, "Maximum APY in percent (0 for none)"),
+		minTVL:    fs.Float64("min-tvl", 0, "Minimum TVL in USD"),
+		minDays:   fs.Int("min-days", 0, "Only rates maturing in at least this many days"),
+		maxDays:   fs.Int("max-days", 0, "Only rates maturing in at most this many days (0 for none)"),
+		after:     fs.String("maturity-after", "", "Only rates maturing after this date (YYYY-MM-DD)"),
+		before:    fs.String("maturity-before", "", "Only rates maturing before this date (YYYY-MM-DD)"),
+		term:      fs.String("term", "", "Only fixed rates (with a maturity) or variable ones (without)"),
+		sortBy:    fs.String("sort-by", "apy", "Sort by apy, tvl, updated_at, apy_change_24h, maturity or apy_at_size"),
+		sortOrder: fs.String("sort-order", "desc", "Sort order: asc or desc"),
+		tradeSize: fs.Float64("trade-size", models.DefaultTradeSize, "Trade size in USD of -sort-by apy_at_size, the APY after Pendle price impact"),
+	}
+}
+
+// params returns the flagged filters
+func (f *filterFlags) params() (models.FilterParams, error) {
+	filters := models.FilterParams{
+		Asset:             *f.asset,
+		Chain:             *f.chain,
+		ProtocolName:      *f.protocol,
+		Category:          *f.category,
+		MinAPY:            *f.minAPY,
+		MaxAPY:            *f.maxAPY,
+		MinTVL:            *f.minTVL,
+		MinDaysToMaturity: *f.minDays,
+		MaxDaysToMaturity: *f.maxDays,
+		Term:              *f.term,
+		SortBy:            *f.sortBy,
+		SortOrder:         *f.sortOrder,
+		TradeSize:         *f.tradeSize,
+	}
+	if filters.TradeSize <= 0 {
+		return filters, fmt.Errorf("-trade-size must be positive")
+	}
+	switch filters.Term {
+	case "", models.TermFixed, models.TermVariable:
+	default:
+		return filters, fmt.Errorf("unknown term %q (want %s or %s)", filters.Term, models.TermFixed, models.TermVariable)
+	}
+
+	for _, date := range []struct {
+		flag  string
+		value string
+		to    *time.Time
+	}{
+		{"-maturity-after", *f.after, &filters.MaturityAfter},
+		{"-maturity-before", *f.before, &filters.MaturityBefore},
+	} {
+		if date.value == "" {
+			continue
+		}
+		t, err := time.Parse(time.DateOnly, date.value)
+		if err != nil {
+			return filters, fmt.Errorf("invalid %s %q (want YYYY-MM-DD)", date.flag, date.value)
+		}
+		*date.to = t
+	}
+	return filters, nil
+}
+
+// values returns the flagged filters as the query parameters of /api/rates
+func (f *filterFlags) values() url.Values {
+	values := url.Values{}
+	for key, value := range map[string]string{
+		"asset":           *f.asset,
+		"chain":           *f.chain,
+		"protocol":        *f.protocol,
+		"category":        *f.category,
+		"maturity_after":  *f.after,
+		"maturity_before": *f.before,
+		"term":            *f.term,
+		"sort_by":         *f.sortBy,
+		"sort_order":      *f.sortOrder,
+	} {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+	for key, value := range map[string]float64{
+		"min_apy": *f.minAPY,
+		"max_apy": *f.maxAPY,
+		"min_tvl": *f.minTVL,
+	} {
+		if value != 0 {
+			values.Set(key, strconv.FormatFloat(value, 'f', -1, 64))
+		}
+	}
+	for key, value := range map[string]int{
+		"min_days_to_maturity": *f.minDays,
+		"max_days_to_maturity": *f.maxDays,
+	} {
+		if value != 0 {
+			values.Set(key, strconv.Itoa(value))
+		}
+	}
+	if *f.tradeSize != models.DefaultTradeSize {
+		values.Set("trade_size", strconv.FormatFloat(*f.tradeSize, 'f', -1, 64))
+	}
+	return values
+}
+
+// runExport implements the "export" command
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	dbPath := fs.String("db", defaultDB(), dbUsage)
+	format := fs.String("format", "csv", "Output format: csv or json")
+	out := fs.String("out", "", "File to write to (default stdout)")
+	filters := addFilterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	params, err := filters.params()
+	if err != nil {
+		return err
+	}
+
+	var write func(io.Writer, []models.YieldRate) error
+	switch *format {
+	case "csv":
+		write = writeRatesCSV
+	case "json":
+		write = writeRatesJSON
+	default:
+		return fmt.Errorf("unknown format %q (want csv or json)", *format)
+	}
+
+	db, err := openExisting(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rates, err := db.GetYieldRates(params)
+	if err != nil {
+		return fmt.Errorf("failed to fetch yield rates: %w", err)
+	}
+	if rates == nil {
+		rates = []models.YieldRate{}
+	}
+
+	if *out == "" {
+		return write(os.Stdout, rates)
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if err := write(f, rates); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeRatesJSON writes rates as /api/rates returns them
+func writeRatesJSON(w io.Writer, rates []models.YieldRate) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(rates)
+}
+
+// writeRatesCSV writes rates with a header row. Changes without enough
+// history and open-ended maturities are left empty; categories are separated
+// by semicolons
+func writeRatesCSV(w io.Writer, rates []models.YieldRate) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"id", "protocol", "pool", "asset", "chain", "apy", "base_apy", "reward_apy", "tvl",
+		"maturity_date", "days_to_maturity", "apy_change_24h", "apy_change_7d", "categories", "source", "updated_at", "url",
+	})
+
+	formatFloat := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	formatChange := func(change *float64) string {
+		if change == nil {
+			return ""
+		}
+		return formatFloat(*change)
+	}
+	now := time.Now()
+	for _, rate := range rates {
+		maturity, daysLeft := "", ""
+		if days, ok := rate.DaysToMaturity(now); ok {
+			maturity = rate.MaturityDate.Format(time.DateOnly)
+			daysLeft = strconv.Itoa(days)
+		}
+		cw.Write([]string{
+			strconv.FormatInt(rate.ID, 10),
+			rate.ProtocolName,
+			rate.PoolName,
+			rate.Asset,
+			rate.Chain,
+			formatFloat(rate.APY),
+			formatFloat(rate.BaseAPY),
+			formatFloat(rate.RewardAPY),
+			formatFloat(rate.TVL),
+			maturity,
+			daysLeft,
+			formatChange(rate.APYChange24h),
+			formatChange(rate.APYChange7d),
+			strings.Join(rate.Categories, ";"),
+			rate.Source,
+			rate.UpdatedAt.UTC().Format(time.RFC3339),
+			rate.ExternalURL,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
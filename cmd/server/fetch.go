@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/api"
+	"github.com/pretty-andrechal/defirates/internal/database"
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// runFetch implements the "fetch" command, which fetches without the web
+// server: once with -once, for cron, or on schedule until interrupted
+func runFetch(args []string) error {
+	fs := flag.NewFlagSet("fetch", flag.ContinueOnError)
+	dbPath := fs.String("db", defaultDB(), dbUsage)
+	once := fs.Bool("once", false, "Fetch once, print a summary and exit non-zero if a source failed")
+	source := fs.String("source", "", "Only fetch this source, e.g. pendle (-once)")
+	chain := fs.String("chain", "", "Only fetch this chain, by name or chain ID, e.g. Arbitrum or 42161 (-once)")
+	schedule := addScheduleFlags(fs)
+	sources := addSourceFlags(fs)
+	logs := addLogFlags(fs, "warn")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := logs.setup(); err != nil {
+		return err
+	}
+	if !*once && (*source != "" || *chain != "") {
+		return fmt.Errorf("-source and -chain need -once")
+	}
+	if !*once {
+		if err := schedule.validate(); err != nil {
+			return err
+		}
+	}
+
+	db, err := database.New(*dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+	fetcher, err := sources.newFetcher(db)
+	if err != nil {
+		return err
+	}
+
+	if !*once {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		db.StartMaintenance(ctx)
+		if err := schedule.start(ctx, fetcher); err != nil {
+			return err
+		}
+		<-ctx.Done()
+		return nil
+	}
+
+	targets, chainName, err := fetchTargets(fetcher, *source, *chain)
+	if err != nil {
+		return err
+	}
+	var runs []*models.FetchRun
+	for _, target := range targets {
+		var run *models.FetchRun
+		if chainName != "" {
+			run, err = fetcher.FetchAndStoreChain(target.(api.ChainSource), chainName)
+		} else {
+			run, err = fetcher.FetchAndStore(target)
+		}
+		if run == nil {
+			return err
+		}
+		runs = append(runs, run)
+	}
+	printFetchRuns(runs)
+
+	failed := 0
+	for _, run := range runs {
+		if run.Status == models.FetchRunFailed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d fetch runs failed", failed, len(runs))
+	}
+	return nil
+}
+
+// fetchTargets returns the sources of fetcher named source, or all of them,
+// that cover chain. A numeric chain is a chain ID, returned by name
+func fetchTargets(fetcher *api.Fetcher, source, chain string) ([]api.Source, string, error) {
+	chain = strings.TrimSpace(chain)
+	if chainID, err := strconv.Atoi(chain); err == nil {
+		chain = api.GetChainName(chainID)
+	}
+
+	var targets []api.Source
+	for _, s := range fetcher.Sources() {
+		if source != "" && !strings.EqualFold(s.Name(), source) {
+			continue
+		}
+		if chain != "" {
+			if chainSource, ok := s.(api.ChainSource); !ok || !chainSource.HasChain(chain) {
+				continue
+			}
+		}
+		targets = append(targets, s)
+	}
+
+	switch {
+	case len(targets) > 0:
+		return targets, chain, nil
+	case source != "" && chain != "":
+		return nil, "", fmt.Errorf("no source %q fetching chain %s", source, chain)
+	case source != "":
+		return nil, "", fmt.Errorf("unknown source %q", source)
+	default:
+		return nil, "", fmt.Errorf("%w %s", api.ErrUnknownChain, chain)
+	}
+}
+
+// printFetchRuns prints the outcome of fetch runs as a table, followed by
+// their errors
+func printFetchRuns(runs []*models.FetchRun) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SOURCE\tSTATUS\tDURATION\tRECEIVED\tINSERTED\tUPDATED\tUNCHANGED\tFAILED")
+	for _, run := range runs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%d\t%d\n",
+			run.Source,
+			run.Status,
+			run.Duration().Round(time.Millisecond),
+			run.Received,
+			run.Inserted,
+			run.Updated,
+			run.Unchanged,
+			run.Failed,
+		)
+	}
+	w.Flush()
+
+	for _, run := range runs {
+		for _, err := range run.Errors {
+			fmt.Printf("%s: %s\n", run.Source, err)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// runList implements the "list" command: the rates of the dashboard as a
+// table, read from the database or from a running server
+func runList(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	dbPath := fs.String("db", defaultDB(), dbUsage)
+	server := fs.String("server", "", "URL of a running server to read from instead of the database, e.g. http://localhost:8080")
+	apiKey := fs.String("api-key", os.Getenv("DEFIRATES_API_KEY"), "API key with the read scope for -server (default $DEFIRATES_API_KEY)")
+	asJSON := fs.Bool("json", false, "Print the rates as JSON instead of a table")
+	watch := fs.Duration("watch", 0, "Refresh on this interval until interrupted, e.g. 30s (0 prints once)")
+	filters := addFilterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *watch < 0 {
+		return fmt.Errorf("-watch must not be negative")
+	}
+	params, err := filters.params()
+	if err != nil {
+		return err
+	}
+
+	var list func() ([]models.YieldRate, error)
+	if *server != "" {
+		endpoint := strings.TrimRight(*server, "/") + "/api/rates"
+		if query := filters.values().Encode(); query != "" {
+			endpoint += "?" + query
+		}
+		list = func() ([]models.YieldRate, error) {
+			return fetchServerRates(endpoint, *apiKey)
+		}
+	} else {
+		db, err := openExisting(*dbPath)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		list = func() ([]models.YieldRate, error) {
+			return db.GetYieldRates(params)
+		}
+	}
+
+	show := func() error {
+		rates, err := list()
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			if rates == nil {
+				rates = []models.YieldRate{}
+			}
+			return writeRatesJSON(os.Stdout, rates)
+		}
+		printRates(os.Stdout, rates, time.Now())
+		return nil
+	}
+	if *watch == 0 {
+		return show()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ticker := time.NewTicker(*watch)
+	defer ticker.Stop()
+	for {
+		if !*asJSON {
+			// Clear the screen, as watch(1) does
+			fmt.Print("\033[H\033[2J")
+			fmt.Printf("Every %s, updated %s\n\n", *watch, time.Now().Format("15:04:05"))
+		}
+		// A failed refresh is reported and retried on the next tick
+		if err := show(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// listClient reads rates from running servers
+var listClient = &http.Client{Timeout: 15 * time.Second}
+
+// fetchServerRates gets the rates of endpoint, a server's /api/rates with
+// its query, authenticating with apiKey if set
+func fetchServerRates(endpoint, apiKey string) ([]models.YieldRate, error) {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := listClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var body struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&body) == nil && body.Error != "" {
+			return nil, fmt.Errorf("server returned %s: %s", resp.Status, body.Error)
+		}
+		return nil, fmt.Errorf("server returned %s", resp.Status)
+	}
+
+	var rates []models.YieldRate
+	if err := json.NewDecoder(resp.Body).Decode(&rates); err != nil {
+		return nil, fmt.Errorf("failed to decode rates: %w", err)
+	}
+	return rates, nil
+}
+
+// printRates writes rates as an aligned table with the columns of the
+// dashboard. Changes without enough history are shown as "-"
+func printRates(out io.Writer, rates []models.YieldRate, now time.Time) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROTOCOL\tASSET\tCHAIN\tAPY\t24H\t7D\tTVL\tMATURITY\tPOOL\tUPDATED")
+	for _, rate := range rates {
+		maturity := "variable"
+		if days, ok := rate.DaysToMaturity(now); ok && days > 0 {
+			maturity = fmt.Sprintf("%s (%dd)", rate.MaturityDate.Format("Jan 02, 2006"), days)
+		} else if ok {
+			maturity = rate.MaturityDate.Format("Jan 02, 2006") + " (matured)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%.2f%%\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			rate.ProtocolName,
+			rate.Asset,
+			rate.Chain,
+			rate.APY,
+			formatDelta(rate.APYChange24h),
+			formatDelta(rate.APYChange7d),
+			formatTVL(rate.TVL),
+			maturity,
+			rate.PoolName,
+			formatAge(now.Sub(rate.UpdatedAt)),
+		)
+	}
+	w.Flush()
+	if len(rates) == 0 {
+		fmt.Fprintln(out, "No yield rates match the filters.")
+	}
+}
+
+// formatDelta formats an APY change in percentage points
+func formatDelta(delta *float64) string {
+	if delta == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%+.2f", *delta)
+}
+
+// formatTVL formats a TVL as the dashboard does, e.g. $1.25M
+func formatTVL(tvl float64) string {
+	switch {
+	case tvl >= 1e6:
+		return fmt.Sprintf("$%.2fM", tvl/1e6)
+	case tvl >= 1e3:
+		return fmt.Sprintf("$%.2fK", tvl/1e3)
+	default:
+		return fmt.Sprintf("$%.2f", tvl)
+	}
+}
+
+// formatAge formats how long ago a rate was updated, e.g. 5m ago
+func formatAge(age time.Duration) string {
+	switch {
+	case age < time.Minute:
+		return "just now"
+	case age < time.Hour:
+		return fmt.Sprintf("%dm ago", int(age.Minutes()))
+	case age < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(age.Hours()))
+	default:
+		return fmt.Sprintf("%dd ago", int(age.Hours()/24))
+	}
+}
@@ -1,71 +1,232 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
-	"log"
-	"net/http"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/pretty-andrechal/defirates/internal/api"
 	"github.com/pretty-andrechal/defirates/internal/database"
-	"github.com/pretty-andrechal/defirates/internal/handlers"
+	"github.com/pretty-andrechal/defirates/internal/logging"
+	"github.com/pretty-andrechal/defirates/internal/models"
 )
 
+const usage = `Usage: defirates <command> [flags]
+
+Commands:
+  serve     Run the web server, fetching on schedule (the default)
+  fetch     Fetch rates into the database, once with -once or on schedule
+  list      Print the rates as a table, from the database or a server
+  export    Write the stored rates as CSV or JSON
+  migrate   Migrate the database schema (up) or show its state (status)
+  sample    Load sample data (load)
+  manual    Manage manual rates
+  users     Manage user accounts and API keys
+  backup    Back the SQLite database up to a file
+  restore   Replace the SQLite database with a backup
+
+Run "defirates <command> -h" for the flags of a command. Without a command,
+the flags are those of serve.`
+
 func main() {
-	// Parse command-line flags
-	port := flag.String("port", "8080", "Port to run the server on")
-	dbPath := flag.String("db", "defirates.db", "Path to SQLite database")
-	fetchInterval := flag.Duration("fetch-interval", 5*time.Minute, "Interval for fetching yield data")
-	loadSample := flag.Bool("load-sample", false, "Load sample data for demonstration")
-	flag.Parse()
+	commands := map[string]func([]string) error{
+		"serve":   runServe,
+		"fetch":   runFetch,
+		"list":    runList,
+		"export":  runExport,
+		"migrate": runMigrate,
+		"sample":  runSample,
+		"manual":  runManual,
+		"users":   runUsers,
+		"backup":  runBackup,
+		"restore": runRestore,
+	}
+
+	// Flags without a command are those of serve, as before there were commands
+	run, args := runServe, os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		if args[0] == "help" {
+			fmt.Println(usage)
+			return
+		}
+		var ok bool
+		if run, ok = commands[args[0]]; !ok {
+			fmt.Fprintf(os.Stderr, "Error: unknown command %q\n\n%s\n", args[0], usage)
+			os.Exit(2)
+		}
+		args = args[1:]
+	}
 
-	log.Println("Starting DeFi Rates server...")
+	if err := run(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
 
-	// Initialize database
-	db, err := database.New(*dbPath)
+// logFlags are the logging flags of the long-running commands
+type logFlags struct {
+	level  *string
+	format *string
+}
+
+func addLogFlags(fs *flag.FlagSet, level string) *logFlags {
+	return &logFlags{
+		level:  fs.String("log-level", level, "Minimum log level: debug, info, warn or error"),
+		format: fs.String("log-format", "text", "Log output format: text or json"),
+	}
+}
+
+// setup sets up structured logging; the log package is routed through it too
+func (l *logFlags) setup() error {
+	level, err := logging.ParseLevel(*l.level)
+	if err != nil {
+		return err
+	}
+	logger, err := logging.New(os.Stderr, *l.format, level)
 	if err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+		return err
 	}
-	defer db.Close()
-	log.Printf("Database initialized at %s", *dbPath)
+	slog.SetDefault(logger)
+	return nil
+}
 
-	// Load sample data if requested
-	if *loadSample {
-		if err := api.LoadSampleData(db); err != nil {
-			log.Printf("Warning: Failed to load sample data: %v", err)
-		}
+// sourceFlags pick the sources fetched besides Pendle
+type sourceFlags struct {
+	curve         *bool
+	llamaSource   *string
+	llamaProjects *string
+	manualFile    *string
+}
+
+func addSourceFlags(fs *flag.FlagSet) *sourceFlags {
+	return &sourceFlags{
+		curve:         fs.Bool("curve", true, "Fetch Curve and Convex pool APYs"),
+		llamaSource:   fs.String("llama-source", api.DefiLlamaYieldsURL, "DefiLlama yields pools URL or local snapshot file"),
+		llamaProjects: fs.String("llama-projects", "", "Comma-separated DefiLlama projects to ingest, optionally slug=Name (empty disables)"),
+		manualFile:    fs.String("manual-file", "", "JSON or CSV file of manual rates, re-read every fetch cycle"),
 	}
+}
 
-	// Initialize data fetcher and start periodic updates
+// newFetcher returns a fetcher into db of Pendle and the flagged sources
+func (s *sourceFlags) newFetcher(db database.Store) (*api.Fetcher, error) {
 	fetcher := api.NewFetcher(db)
-	fetcher.StartPeriodicFetch(*fetchInterval)
-	log.Printf("Data fetcher started (interval: %v)", *fetchInterval)
+	if *s.curve {
+		fetcher.AddSource(api.NewCurveSource())
+	}
+	if *s.llamaProjects != "" {
+		// DefiLlama projects can't take over the protocols of other sources
+		reserved := []models.Protocol{models.ManualProtocol}
+		for _, source := range fetcher.Sources() {
+			reserved = append(reserved, source.Protocols()...)
+		}
+		projects, err := api.ParseLlamaProjects(*s.llamaProjects, reserved...)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -llama-projects: %w", err)
+		}
+		fetcher.AddSource(api.NewLlamaSource(*s.llamaSource, projects))
+	}
+	if *s.manualFile != "" {
+		fetcher.AddSource(api.NewManualFileSource(*s.manualFile))
+	}
+	return fetcher, nil
+}
+
+// scheduleFlags are the fetch schedule of the long-running commands
+type scheduleFlags struct {
+	interval        *time.Duration
+	jitter          *time.Duration
+	sourceIntervals *string
+	maxBackoff      *time.Duration
+}
+
+func addScheduleFlags(fs *flag.FlagSet) *scheduleFlags {
+	return &scheduleFlags{
+		interval:        fs.Duration("fetch-interval", 5*time.Minute, "Interval for fetching yield data"),
+		jitter:          fs.Duration("fetch-jitter", 30*time.Second, "Random extra delay of up to this much before each fetch"),
+		sourceIntervals: fs.String("source-intervals", "", "Per-source fetch intervals, e.g. Pendle=10m,DefiLlama=1h"),
+		maxBackoff:      fs.Duration("max-backoff", api.DefaultMaxBackoff, "Longest wait between fetches of a failing source"),
+	}
+}
 
-	// Initialize HTTP handlers
-	handler, err := handlers.New(db)
+// validate rejects schedules that would fetch in a tight loop, so bad flags
+// fail at startup rather than hammer the sources
+func (s *scheduleFlags) validate() error {
+	if *s.interval <= 0 {
+		return fmt.Errorf("-fetch-interval must be positive, got %s", *s.interval)
+	}
+	if *s.jitter < 0 {
+		return fmt.Errorf("-fetch-jitter must not be negative, got %s", *s.jitter)
+	}
+	if _, err := api.ParseSourceIntervals(*s.sourceIntervals); err != nil {
+		return fmt.Errorf("invalid -source-intervals: %w", err)
+	}
+	return nil
+}
+
+// start fetches from the sources of fetcher on schedule until ctx is done,
+// with -source-intervals overriding the schedule per source
+func (s *scheduleFlags) start(ctx context.Context, fetcher *api.Fetcher) error {
+	intervals, err := api.ParseSourceIntervals(*s.sourceIntervals)
 	if err != nil {
-		log.Fatalf("Failed to initialize handlers: %v", err)
+		return fmt.Errorf("invalid -source-intervals: %w", err)
 	}
 
-	// Setup routes
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", handler.HandleIndex)
-	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
+	scheduler := api.NewScheduler(fetcher, api.Schedule{
+		Interval:   *s.interval,
+		Jitter:     *s.jitter,
+		MaxBackoff: *s.maxBackoff,
+	})
+	for name, interval := range intervals {
+		if !slices.ContainsFunc(fetcher.Sources(), func(source api.Source) bool {
+			return strings.EqualFold(source.Name(), name)
+		}) {
+			slog.Warn("ignoring interval of unknown source", "source", name)
+			continue
+		}
+		scheduler.SetSchedule(name, api.Schedule{Interval: interval})
+	}
+	scheduler.Start(ctx)
+	slog.Info("data fetcher started", "interval", *s.interval)
+	return nil
+}
 
-	// Start server
-	addr := ":" + *port
-	log.Printf("Server starting on http://localhost%s", addr)
-	log.Printf("Open your browser and navigate to http://localhost%s", addr)
+// dbUsage describes the -db flag of every command
+const dbUsage = "SQLite database path or postgres:// URL (default $DEFIRATES_DB, else defirates.db)"
 
-	server := &http.Server{
-		Addr:         addr,
-		Handler:      mux,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+// defaultDB returns the database of the DEFIRATES_DB environment variable,
+// so replicas and the admin commands can share a PostgreSQL URL without
+// repeating it on every command line
+func defaultDB() string {
+	if dsn := os.Getenv("DEFIRATES_DB"); dsn != "" {
+		return dsn
 	}
+	return "defirates.db"
+}
 
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatalf("Server failed to start: %v", err)
+// openExisting opens the database of a read-only command, which neither
+// creates nor migrates it
+func openExisting(dsn string) (*database.DB, error) {
+	db, err := database.OpenExisting(dsn)
+	switch {
+	case errors.Is(err, database.ErrSchemaOutdated):
+		return nil, fmt.Errorf("failed to open database: %w (run \"defirates migrate up\" first)", err)
+	case err != nil:
+		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
+	return db, nil
+}
+
+// fatal logs err and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/pretty-andrechal/defirates/internal/database"
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+const manualUsage = `Usage: defirates manual <command> [flags]
+
+Commands:
+  list     List manual rates
+  add      Create a manual rate (replaces one with the same pool and chain)
+  edit     Change fields of the manual rate with -id
+  delete   Delete the manual rate with -id
+
+Run "defirates manual <command> -h" for the flags of a command.`
+
+// runManual implements the "manual" subcommand for off-chain and private deals
+func runManual(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing command\n\n%s", manualUsage)
+	}
+
+	command := args[0]
+	fs := flag.NewFlagSet("manual "+command, flag.ContinueOnError)
+	dbPath := fs.String("db", defaultDB(), dbUsage)
+	id := fs.Int64("id", 0, "ID of the manual rate (edit, delete)")
+	asset := fs.String("asset", "", "Asset symbol, e.g. USDC")
+	chain := fs.String("chain", "", "Chain name, e.g. Ethereum (use Off-chain for CeFi)")
+	apy := fs.Float64("apy", 0, "Total APY in percent")
+	baseAPY := fs.Float64("base-apy", 0, "Organic part of the APY in percent")
+	rewardAPY := fs.Float64("reward-apy", 0, "Incentive part of the APY in percent")
+	tvl := fs.Float64("tvl", 0, "Deal size or TVL in USD")
+	maturity := fs.String("maturity", "", "Maturity date (YYYY-MM-DD), empty for open-ended")
+	pool := fs.String("pool", "", "Pool or deal name (defaults to the asset)")
+	url := fs.String("url", "", "Link to the deal")
+
+	switch command {
+	case "list", "add", "edit", "delete":
+	case "-h", "--help", "help":
+		fmt.Println(manualUsage)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", command, manualUsage)
+	}
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	db, err := database.New(*dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	switch command {
+	case "list":
+		rates, err := db.GetManualRates()
+		if err != nil {
+			return err
+		}
+		printManualRates(rates)
+		return nil
+
+	case "add":
+		entry := models.ManualEntry{
+			Asset:        *asset,
+			Chain:        *chain,
+			APY:          *apy,
+			BaseAPY:      *baseAPY,
+			RewardAPY:    *rewardAPY,
+			TVL:          *tvl,
+			MaturityDate: *maturity,
+			PoolName:     *pool,
+			ExternalURL:  *url,
+		}
+		rate, err := entry.YieldRate()
+		if err != nil {
+			return err
+		}
+		if err := db.CreateManualRate(&rate); err != nil {
+			return err
+		}
+		fmt.Printf("Saved manual rate %d (%s on %s, %.2f%%)\n", rate.ID, rate.Asset, rate.Chain, rate.APY)
+		return nil
+
+	case "edit":
+		if *id == 0 {
+			return fmt.Errorf("-id is required")
+		}
+		rate, err := db.GetYieldRate(*id)
+		if err != nil {
+			return fmt.Errorf("manual rate %d: %w", *id, err)
+		}
+
+		// Only overwrite the fields given on the command line
+		var parseErr error
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "asset":
+				rate.Asset = *asset
+			case "chain":
+				rate.Chain = *chain
+			case "apy":
+				rate.APY = *apy
+			case "base-apy":
+				rate.BaseAPY = *baseAPY
+			case "reward-apy":
+				rate.RewardAPY = *rewardAPY
+			case "tvl":
+				rate.TVL = *tvl
+			case "maturity":
+				rate.MaturityDate, parseErr = models.ParseMaturityDate(*maturity)
+			case "pool":
+				rate.PoolName = *pool
+			case "url":
+				rate.ExternalURL = *url
+			}
+		})
+		if parseErr != nil {
+			return parseErr
+		}
+
+		if err := db.UpdateManualRate(rate); err != nil {
+			return err
+		}
+		fmt.Printf("Updated manual rate %d\n", rate.ID)
+		return nil
+
+	case "delete":
+		if *id == 0 {
+			return fmt.Errorf("-id is required")
+		}
+		if err := db.DeleteManualRate(*id); err != nil {
+			return fmt.Errorf("manual rate %d: %w", *id, err)
+		}
+		fmt.Printf("Deleted manual rate %d\n", *id)
+		return nil
+	}
+
+	return nil
+}
+
+// printManualRates prints manual rates as an aligned table
+func printManualRates(rates []models.YieldRate) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tASSET\tCHAIN\tAPY\tTVL\tMATURITY\tPOOL\tSOURCE")
+	for _, rate := range rates {
+		maturity := "-"
+		if rate.MaturityDate != nil {
+			maturity = rate.MaturityDate.Format("2006-01-02")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%.2f%%\t%.0f\t%s\t%s\t%s\n",
+			rate.ID, rate.Asset, rate.Chain, rate.APY, rate.TVL, maturity, rate.PoolName, rate.Source)
+	}
+	w.Flush()
+}
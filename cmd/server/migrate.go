@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/pretty-andrechal/defirates/internal/database"
+)
+
+const migrateUsage = `Usage: defirates migrate <command> [flags]
+
+Commands:
+  up       Apply the migrations the database doesn't have yet
+  status   List the migrations and whether the database has had them`
+
+// runMigrate implements the "migrate" command. The server migrates on
+// startup too; this lets deploys do it as a separate step
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing command\n\n%s", migrateUsage)
+	}
+
+	command := args[0]
+	switch command {
+	case "up", "status":
+	case "-h", "--help", "help":
+		fmt.Println(migrateUsage)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", command, migrateUsage)
+	}
+
+	fs := flag.NewFlagSet("migrate "+command, flag.ContinueOnError)
+	dbPath := fs.String("db", defaultDB(), dbUsage)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	var (
+		migrations []database.Migration
+		err        error
+	)
+	if command == "up" {
+		db, openErr := database.New(*dbPath)
+		if openErr != nil {
+			return fmt.Errorf("failed to migrate database: %w", openErr)
+		}
+		defer db.Close()
+		migrations, err = db.Migrations()
+	} else {
+		if !database.IsPostgresDSN(*dbPath) {
+			// Connecting to a missing database would create an empty one
+			if _, statErr := os.Stat(*dbPath); statErr != nil {
+				return fmt.Errorf("no database: %w", statErr)
+			}
+		}
+		migrations, err = database.MigrationStatus(*dbPath)
+	}
+	if err != nil {
+		return err
+	}
+
+	printMigrations(migrations)
+	return nil
+}
+
+// printMigrations prints migrations as a table
+func printMigrations(migrations []database.Migration) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MIGRATION\tSTATUS")
+	for _, m := range migrations {
+		status := "pending"
+		if m.Applied {
+			status = "applied"
+		}
+		fmt.Fprintf(w, "%s\t%s\n", m.Name, status)
+	}
+	w.Flush()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/pretty-andrechal/defirates/internal/api"
+	"github.com/pretty-andrechal/defirates/internal/database"
+)
+
+const sampleUsage = `Usage: defirates sample load [flags]
+
+Commands:
+  load   Load sample rates for demonstration`
+
+// runSample implements the "sample" command
+func runSample(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing command\n\n%s", sampleUsage)
+	}
+	switch args[0] {
+	case "load":
+	case "-h", "--help", "help":
+		fmt.Println(sampleUsage)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", args[0], sampleUsage)
+	}
+
+	fs := flag.NewFlagSet("sample load", flag.ContinueOnError)
+	dbPath := fs.String("db", defaultDB(), dbUsage)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	db, err := database.New(*dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	if err := api.LoadSampleData(db); err != nil {
+		return fmt.Errorf("failed to load sample data: %w", err)
+	}
+	fmt.Printf("Loaded sample data into %s\n", *dbPath)
+	return nil
+}
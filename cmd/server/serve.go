@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/api"
+	"github.com/pretty-andrechal/defirates/internal/database"
+	"github.com/pretty-andrechal/defirates/internal/events"
+	"github.com/pretty-andrechal/defirates/internal/handlers"
+	"github.com/pretty-andrechal/defirates/internal/models"
+	"github.com/pretty-andrechal/defirates/internal/ratelimit"
+)
+
+// runServe implements the "serve" command: the web server, fetching on
+// schedule unless -fetch=false
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	port := fs.String("port", "8080", "Port to run the server on")
+	dbPath := fs.String("db", defaultDB(), dbUsage)
+	dbDefaults := database.DefaultOptions()
+	dbJournalMode := fs.String("db-journal-mode", dbDefaults.JournalMode, "SQLite journal mode: wal, delete, truncate, persist, memory or off")
+	dbBusyTimeout := fs.Duration("db-busy-timeout", dbDefaults.BusyTimeout, "How long database statements wait for a lock before failing")
+	dbForeignKeys := fs.Bool("db-foreign-keys", dbDefaults.ForeignKeys, "Enforce the foreign keys of the database schema")
+	dbMaxReaders := fs.Int("db-max-readers", dbDefaults.MaxReaders, "Size of the read-only database connection pool (PostgreSQL: of the whole pool, less one)")
+	dbMaintenance := fs.Duration("db-maintenance-interval", dbDefaults.MaintenanceInterval, "How often to compact history, optimize the database and checkpoint its WAL (0 disables)")
+	dbVacuum := fs.Duration("db-vacuum-interval", dbDefaults.VacuumInterval, "How often to VACUUM the database (0 disables)")
+	backupDir := fs.String("backup-dir", "", "Directory to back the SQLite database up to on schedule (empty disables)")
+	backupInterval := fs.Duration("backup-interval", 24*time.Hour, "How often to back up to -backup-dir")
+	backupKeep := fs.Int("backup-keep", 7, "How many backups to keep in -backup-dir (0 keeps all)")
+	historyRaw := fs.Duration("history-raw", dbDefaults.Retention.Raw, "How long to keep raw APY/TVL observations before rolling them up hourly (0 keeps them forever)")
+	historyHourly := fs.Duration("history-hourly", dbDefaults.Retention.Hourly, "How long to keep hourly history before rolling it up daily")
+	historyDaily := fs.Duration("history-daily", dbDefaults.Retention.Daily, "How long to keep daily history (0 keeps it forever)")
+	fetch := fs.Bool("fetch", true, "Fetch yield data; false runs a web-only replica of a shared PostgreSQL database")
+	watchInterval := fs.Duration("watch-interval", 10*time.Second, "How often a web-only replica checks the database for new rates")
+	loadSample := fs.Bool("load-sample", false, "Load sample data for demonstration")
+	adminToken := fs.String("admin-token", os.Getenv("DEFIRATES_ADMIN_TOKEN"), "Bearer token with the admin scope (empty leaves admin to user accounts)")
+	rateLimits := fs.String("rate-limits", "", "Per-client rate limits by route group, e.g. api=120/1m:30,login=off (groups: dashboard, api, login, admin)")
+	trustProxy := fs.Bool("trust-proxy", false, "Rate limit anonymous clients by the last X-Forwarded-For address")
+	maxTVLShare := fs.Float64("max-tvl-share", handlers.DefaultMaxTVLShare, "Share of a pool's TVL above which the calculator warns a deposit would move its rate, e.g. 0.05")
+	requireLogin := fs.Bool("require-login", false, "Require a user account or API key with the read scope for the dashboard")
+	schedule := addScheduleFlags(fs)
+	sources := addSourceFlags(fs)
+	logs := addLogFlags(fs, "info")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := logs.setup(); err != nil {
+		return err
+	}
+	if err := schedule.validate(); err != nil {
+		return err
+	}
+	if *maxTVLShare <= 0 {
+		return fmt.Errorf("-max-tvl-share must be positive")
+	}
+
+	slog.Info("starting DeFi Rates server")
+
+	// Initialize database
+	db, err := database.Open(*dbPath, database.Options{
+		JournalMode:         *dbJournalMode,
+		BusyTimeout:         *dbBusyTimeout,
+		ForeignKeys:         *dbForeignKeys,
+		MaxReaders:          *dbMaxReaders,
+		MaintenanceInterval: *dbMaintenance,
+		VacuumInterval:      *dbVacuum,
+		Retention: database.Retention{
+			Raw:    *historyRaw,
+			Hourly: *historyHourly,
+			Daily:  *historyDaily,
+		},
+	})
+	if err != nil {
+		fatal("failed to initialize database", err)
+	}
+	defer db.Close()
+	db.StartMaintenance(context.Background())
+	if db.Backend() == "sqlite" {
+		slog.Info("database initialized", "backend", db.Backend(), "path", *dbPath, "journal_mode", *dbJournalMode)
+	} else {
+		slog.Info("database initialized", "backend", db.Backend())
+	}
+
+	if *backupDir != "" {
+		if err := db.StartBackups(context.Background(), *backupDir, *backupInterval, *backupKeep); err != nil {
+			fatal("failed to schedule backups", err)
+		}
+		slog.Info("scheduled backups", "dir", *backupDir, "interval", *backupInterval, "keep", *backupKeep)
+	}
+
+	// Load sample data if requested
+	if *loadSample {
+		if err := api.LoadSampleData(db); err != nil {
+			slog.Warn("failed to load sample data", "error", err)
+		}
+	}
+
+	// Initialize data fetcher and start periodic updates
+	fetcher, err := sources.newFetcher(db)
+	if err != nil {
+		return err
+	}
+	broker := events.NewBroker()
+
+	// Initialize HTTP handlers
+	handler, err := handlers.New(db)
+	if err != nil {
+		fatal("failed to initialize handlers", err)
+	}
+	handler.SetAdminToken(*adminToken)
+	handler.SetEvents(broker)
+	handler.SetTrustProxy(*trustProxy)
+	handler.SetMaxTVLShare(*maxTVLShare)
+
+	// The handler drops its query cache before live clients hear of new
+	// rates and reload them
+	publisher := api.Publishers{handler, broker}
+
+	if !*fetch {
+		// Another replica fetches into the shared database; this one only
+		// notices the rates it stores
+		go api.WatchStore(context.Background(), db, *watchInterval, publisher)
+		slog.Info("data fetcher disabled, watching the database", "interval", *watchInterval)
+	} else {
+		handler.SetRefresher(fetcher)
+		fetcher.SetPublisher(publisher)
+		if err := schedule.start(context.Background(), fetcher); err != nil {
+			fatal("failed to start data fetcher", err)
+		}
+	}
+
+	// Rate limits per route group, with -rate-limits overriding the defaults
+	limits := map[string]ratelimit.Limit{
+		"dashboard": {Requests: 60, Per: time.Minute, Burst: 30},
+		"api":       {Requests: 120, Per: time.Minute, Burst: 60},
+		"login":     {Requests: 10, Per: time.Minute, Burst: 5},
+		"admin":     {Requests: 30, Per: time.Minute, Burst: 10},
+	}
+	overrides, err := ratelimit.ParseLimits(*rateLimits)
+	if err != nil {
+		fatal("invalid -rate-limits", err)
+	}
+	for group, limit := range overrides {
+		if _, ok := limits[group]; !ok {
+			fatal("invalid -rate-limits", fmt.Errorf("unknown route group %q", group))
+		}
+		limits[group] = limit
+	}
+	limiters := make(map[string]*ratelimit.Limiter)
+	for group, limit := range limits {
+		limiters[group] = ratelimit.New(limit, nil)
+		slog.Info("rate limit", "group", group, "limit", limit)
+	}
+	limit := func(group string, next http.HandlerFunc) http.HandlerFunc {
+		return handler.RateLimit(limiters[group], next)
+	}
+
+	// Setup routes. The dashboard is public unless -require-login is set
+	dashboard := func(next http.HandlerFunc) http.HandlerFunc {
+		if *requireLogin {
+			next = handler.RequireScope(models.ScopeRead, next)
+		}
+		return limit("dashboard", next)
+	}
+	apiRoute := func(scope string, next http.HandlerFunc) http.HandlerFunc {
+		return limit("api", handler.RequireScope(scope, next))
+	}
+	adminRoute := func(scope string, next http.HandlerFunc) http.HandlerFunc {
+		if scope == models.ScopeAdmin {
+			return limit("admin", handler.RequireAdmin(next))
+		}
+		return limit("admin", handler.RequireScope(scope, next))
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", dashboard(handler.HandleIndex))
+	mux.HandleFunc("GET /events", dashboard(handler.HandleEvents))
+	mux.HandleFunc("GET /filters", dashboard(handler.HandleListFilters))
+	mux.HandleFunc("POST /filters", dashboard(handler.HandleSaveFilter))
+	mux.HandleFunc("DELETE /filters/{id}", dashboard(handler.HandleDeleteFilter))
+	mux.HandleFunc("GET /f/{slug}", dashboard(handler.HandleSharedFilter))
+	mux.HandleFunc("GET /curves", dashboard(handler.HandleCurves))
+	mux.HandleFunc("GET /calculator", dashboard(handler.HandleCalculator))
+	mux.HandleFunc("POST /watchlist/{id}", dashboard(handler.HandleWatch))
+	mux.HandleFunc("DELETE /watchlist/{id}", dashboard(handler.HandleUnwatch))
+	mux.HandleFunc("/login", limit("login", handler.HandleLogin))
+	mux.HandleFunc("POST /logout", handler.HandleLogout)
+	mux.HandleFunc("GET /api/rates", apiRoute(models.ScopeRead, handler.HandleAPIRates))
+	mux.HandleFunc("GET /api/rates/{id}", apiRoute(models.ScopeRead, handler.HandleAPIRate))
+	mux.HandleFunc("GET /api/rates/{id}/history", apiRoute(models.ScopeRead, handler.HandleAPIRateHistory))
+	mux.HandleFunc("GET /api/curves", apiRoute(models.ScopeRead, handler.HandleAPICurves))
+	mux.HandleFunc("GET /api/calculator", apiRoute(models.ScopeRead, handler.HandleAPICalculator))
+	mux.HandleFunc("GET /api/keys", apiRoute(models.ScopeRead, handler.HandleListAPIKeys))
+	mux.HandleFunc("POST /api/keys", apiRoute(models.ScopeRead, handler.HandleCreateAPIKey))
+	mux.HandleFunc("DELETE /api/keys/{id}", apiRoute(models.ScopeRead, handler.HandleDeleteAPIKey))
+	mux.HandleFunc("/admin/login", limit("login", handler.HandleAdminLogin))
+	mux.HandleFunc("POST /admin/logout", handler.HandleAdminLogout)
+	mux.HandleFunc("GET /admin/fetch-runs", adminRoute(models.ScopeAdmin, handler.HandleFetchRuns))
+	mux.HandleFunc("POST /admin/refresh", adminRoute(models.ScopeAdmin, handler.HandleRefresh))
+	mux.HandleFunc("GET /admin/manual-rates", adminRoute(models.ScopeWrite, handler.HandleListManualRates))
+	mux.HandleFunc("POST /admin/manual-rates", adminRoute(models.ScopeWrite, handler.HandleCreateManualRate))
+	mux.HandleFunc("PUT /admin/manual-rates/{id}", adminRoute(models.ScopeWrite, handler.HandleUpdateManualRate))
+	mux.HandleFunc("DELETE /admin/manual-rates/{id}", adminRoute(models.ScopeWrite, handler.HandleDeleteManualRate))
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
+
+	// Start server
+	addr := ":" + *port
+	slog.Info("server starting", "url", "http://localhost"+addr)
+
+	server := &http.Server{
+		Addr:         addr,
+		Handler:      handlers.LogRequests(mux),
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 15 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+
+	return server.ListenAndServe()
+}
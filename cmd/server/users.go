@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pretty-andrechal/defirates/internal/database"
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+const usersUsage = `Usage: defirates users <command> [flags]
+
+Commands:
+  list         List users
+  add          Create a user with -username, -scopes and a password
+  passwd       Change the password of -username and log out its sessions
+  delete       Delete -username with its sessions and API keys
+  keys         List the API keys of -username
+  add-key      Create an API key for -username with -name and -scopes
+  revoke-key   Revoke the API key -id of -username
+
+Passwords are read from the first line of standard input unless -password is given.
+Scopes are read, write and admin; each includes the ones before it.
+
+Run "defirates users <command> -h" for the flags of a command.`
+
+// runUsers implements the "users" subcommand for accounts and API keys
+func runUsers(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing command\n\n%s", usersUsage)
+	}
+
+	command := args[0]
+	fs := flag.NewFlagSet("users "+command, flag.ContinueOnError)
+	dbPath := fs.String("db", defaultDB(), dbUsage)
+	username := fs.String("username", "", "Username")
+	password := fs.String("password", "", "Password (default: read from standard input)")
+	scopes := fs.String("scopes", models.ScopeRead, "Comma-separated scopes: read, write, admin")
+	name := fs.String("name", "", "Name of the API key, e.g. the partner using it (add-key)")
+	id := fs.Int64("id", 0, "ID of the API key (revoke-key)")
+
+	switch command {
+	case "list", "add", "passwd", "delete", "keys", "add-key", "revoke-key":
+	case "-h", "--help", "help":
+		fmt.Println(usersUsage)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", command, usersUsage)
+	}
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	db, err := database.New(*dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	if command == "list" {
+		users, err := db.GetUsers()
+		if err != nil {
+			return err
+		}
+		printUsers(users)
+		return nil
+	}
+
+	if *username == "" {
+		return fmt.Errorf("-username is required")
+	}
+
+	if command == "add" {
+		parsed, err := models.ParseScopes(*scopes)
+		if err != nil {
+			return err
+		}
+		pw, err := readPassword(*password)
+		if err != nil {
+			return err
+		}
+		user := models.User{Username: *username, Scopes: parsed}
+		if err := db.CreateUser(&user, pw); err != nil {
+			return err
+		}
+		fmt.Printf("Created user %s (%s)\n", user.Username, strings.Join(user.Scopes, ","))
+		return nil
+	}
+
+	user, err := db.GetUserByUsername(*username)
+	if err != nil {
+		return fmt.Errorf("user %s: %w", *username, err)
+	}
+
+	switch command {
+	case "passwd":
+		pw, err := readPassword(*password)
+		if err != nil {
+			return err
+		}
+		if err := db.SetPassword(user.ID, pw); err != nil {
+			return err
+		}
+		fmt.Printf("Changed the password of %s\n", user.Username)
+
+	case "delete":
+		if err := db.DeleteUser(user.ID); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted user %s\n", user.Username)
+
+	case "keys":
+		keys, err := db.GetAPIKeys(user.ID)
+		if err != nil {
+			return err
+		}
+		printAPIKeys(keys)
+
+	case "add-key":
+		parsed, err := models.ParseScopes(*scopes)
+		if err != nil {
+			return err
+		}
+		key := models.APIKey{UserID: user.ID, Name: *name, Scopes: parsed}
+		secret, err := db.CreateAPIKey(&key)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created API key %d for %s (%s). Store it now, it can't be shown again:\n%s\n",
+			key.ID, user.Username, strings.Join(key.Scopes, ","), secret)
+
+	case "revoke-key":
+		if *id == 0 {
+			return fmt.Errorf("-id is required")
+		}
+		if err := db.DeleteAPIKey(user.ID, *id); err != nil {
+			return fmt.Errorf("API key %d: %w", *id, err)
+		}
+		fmt.Printf("Revoked API key %d\n", *id)
+	}
+
+	return nil
+}
+
+// readPassword returns flagValue, or the first line of standard input
+func readPassword(flagValue string) (string, error) {
+	if flagValue != "" {
+		return flagValue, nil
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read password from standard input: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// printUsers prints users as an aligned table
+func printUsers(users []models.User) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tUSERNAME\tSCOPES\tCREATED")
+	for _, user := range users {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n",
+			user.ID, user.Username, strings.Join(user.Scopes, ","), user.CreatedAt.Format("2006-01-02"))
+	}
+	w.Flush()
+}
+
+// printAPIKeys prints API keys as an aligned table
+func printAPIKeys(keys []models.APIKey) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tPREFIX\tSCOPES\tCREATED\tLAST USED")
+	for _, key := range keys {
+		lastUsed := "never"
+		if key.LastUsedAt != nil {
+			lastUsed = key.LastUsedAt.Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s…\t%s\t%s\t%s\n",
+			key.ID, key.Name, key.Prefix, strings.Join(key.Scopes, ","), key.CreatedAt.Format("2006-01-02"), lastUsed)
+	}
+	w.Flush()
+}
@@ -2,4 +2,8 @@ module github.com/pretty-andrechal/defirates
 
 go 1.24.7
 
-require github.com/mattn/go-sqlite3 v1.14.32 // indirect
+require (
+	github.com/lib/pq v1.12.3
+	github.com/mattn/go-sqlite3 v1.14.32
+	golang.org/x/crypto v0.48.0
+)
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+const (
+	ConvexBaseURL = "https://www.convexfinance.com/api"
+)
+
+// ConvexProtocol describes Convex for the protocols table
+var ConvexProtocol = models.Protocol{
+	Name:        "Convex",
+	URL:         "https://www.convexfinance.com",
+	Description: "Convex boosts Curve liquidity provider rewards by pooling veCRV voting power",
+}
+
+// ConvexClient handles communication with the Convex API
+type ConvexClient struct {
+	httpClient *http.Client
+	baseURL    string
+}
+
+// NewConvexClient creates a new Convex API client
+func NewConvexClient() *ConvexClient {
+	return &ConvexClient{
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		baseURL: ConvexBaseURL,
+	}
+}
+
+// ConvexPoolAPY is the boosted yield Convex pays on a Curve pool, in percent
+type ConvexPoolAPY struct {
+	BaseAPY      float64             `json:"baseApy"`
+	CrvAPY       float64             `json:"crvApy"`
+	CvxAPY       float64             `json:"cvxApy"`
+	ExtraRewards []ConvexExtraReward `json:"extraRewards"`
+}
+
+// ConvexExtraReward is an additional incentive token paid by a Convex pool
+type ConvexExtraReward struct {
+	Symbol string  `json:"symbol"`
+	APY    float64 `json:"apy"`
+}
+
+// ConvexAPYsResponse is the response from the curve-apys endpoint
+type ConvexAPYsResponse struct {
+	APYs map[string]ConvexPoolAPY `json:"apys"`
+}
+
+// GetPoolAPYs fetches Convex APYs for Ethereum Curve pools, keyed by Curve pool id
+func (c *ConvexClient) GetPoolAPYs() (map[string]ConvexPoolAPY, error) {
+	var apysResp ConvexAPYsResponse
+	if err := getJSON(c.httpClient, c.baseURL+"/curve-apys", &apysResp); err != nil {
+		return nil, err
+	}
+	return apysResp.APYs, nil
+}
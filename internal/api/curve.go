@@ -0,0 +1,366 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+const (
+	CurveBaseURL = "https://api.curve.finance/v1"
+
+	// DefaultCurveMinTVL skips the long tail of empty factory pools
+	DefaultCurveMinTVL = 10_000
+)
+
+// CurveProtocol describes Curve for the protocols table
+var CurveProtocol = models.Protocol{
+	Name:        "Curve",
+	URL:         "https://curve.finance",
+	Description: "Curve is a decentralized exchange optimized for low-slippage swaps between similarly priced assets",
+}
+
+// CurveChainToName converts Curve API blockchain ids to readable names
+var CurveChainToName = map[string]string{
+	"ethereum": "Ethereum",
+	"arbitrum": "Arbitrum",
+	"optimism": "Optimism",
+	"base":     "Base",
+	"bsc":      "BSC",
+	"sonic":    "Sonic",
+	"polygon":  "Polygon",
+	"fraxtal":  "Fraxtal",
+	"xdai":     "Gnosis",
+}
+
+// CurveClient handles communication with the Curve API
+type CurveClient struct {
+	httpClient *http.Client
+	baseURL    string
+}
+
+// NewCurveClient creates a new Curve API client
+func NewCurveClient() *CurveClient {
+	return &CurveClient{
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		baseURL: CurveBaseURL,
+	}
+}
+
+// CurvePool represents a Curve pool (matching the getPools API response)
+type CurvePool struct {
+	ID           string             `json:"id"`
+	Address      string             `json:"address"`
+	Name         string             `json:"name"`
+	Coins        []CurveCoin        `json:"coins"`
+	USDTotal     float64            `json:"usdTotal"`
+	GaugeCrvAPY  []float64          `json:"gaugeCrvApy"` // [unboosted, max boosted], in percent
+	GaugeRewards []CurveGaugeReward `json:"gaugeRewards"`
+	PoolURLs     CurvePoolURLs      `json:"poolUrls"`
+	IsBroken     bool               `json:"isBroken"`
+	Chain        string             `json:"-"` // Not in API response, set manually
+}
+
+// CurveCoin is one of the tokens held by a Curve pool
+type CurveCoin struct {
+	Address string `json:"address"`
+	Symbol  string `json:"symbol"`
+}
+
+// CurveGaugeReward is a non-CRV incentive paid by a pool's gauge
+type CurveGaugeReward struct {
+	Symbol       string  `json:"symbol"`
+	TokenAddress string  `json:"tokenAddress"`
+	APY          float64 `json:"apy"` // In percent
+}
+
+// CurvePoolURLs holds links to the pool pages on the Curve app
+type CurvePoolURLs struct {
+	Deposit []string `json:"deposit"`
+	Swap    []string `json:"swap"`
+}
+
+// CurvePoolsResponse is the response from the getPools endpoint
+type CurvePoolsResponse struct {
+	Success bool `json:"success"`
+	Data    struct {
+		PoolData []CurvePool `json:"poolData"`
+	} `json:"data"`
+}
+
+// CurveBaseAPY is the trading fee APY of a single pool
+type CurveBaseAPY struct {
+	Address              string  `json:"address"`
+	LatestDailyAPYPcent  float64 `json:"latestDailyApyPcent"`
+	LatestWeeklyAPYPcent float64 `json:"latestWeeklyApyPcent"`
+}
+
+// CurveBaseAPYsResponse is the response from the getBaseApys endpoint
+type CurveBaseAPYsResponse struct {
+	Success bool `json:"success"`
+	Data    struct {
+		BaseAPYs []CurveBaseAPY `json:"baseApys"`
+	} `json:"data"`
+}
+
+// GetPools fetches all pools for a Curve blockchain id (e.g., "ethereum")
+func (c *CurveClient) GetPools(chain string) ([]CurvePool, error) {
+	var poolsResp CurvePoolsResponse
+	if err := getJSON(c.httpClient, fmt.Sprintf("%s/getPools/all/%s", c.baseURL, chain), &poolsResp); err != nil {
+		return nil, err
+	}
+
+	for i := range poolsResp.Data.PoolData {
+		poolsResp.Data.PoolData[i].Chain = chain
+	}
+
+	return poolsResp.Data.PoolData, nil
+}
+
+// GetBaseAPYs fetches trading fee APYs for a Curve blockchain id, keyed by
+// lowercased pool address
+func (c *CurveClient) GetBaseAPYs(chain string) (map[string]float64, error) {
+	var apysResp CurveBaseAPYsResponse
+	if err := getJSON(c.httpClient, fmt.Sprintf("%s/getBaseApys/%s", c.baseURL, chain), &apysResp); err != nil {
+		return nil, err
+	}
+
+	baseAPYs := make(map[string]float64, len(apysResp.Data.BaseAPYs))
+	for _, apy := range apysResp.Data.BaseAPYs {
+		// Weekly is less noisy than daily for fee income
+		baseAPYs[strings.ToLower(apy.Address)] = apy.LatestWeeklyAPYPcent
+	}
+
+	return baseAPYs, nil
+}
+
+// getJSON performs a GET request and decodes the JSON response body into v
+func getJSON(client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "DeFiRates/1.0 (+https://github.com/pretty-andrechal/defirates)")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	return nil
+}
+
+// CurveSource reports Curve LP yields and, for Ethereum pools, the boosted
+// Convex yield on the same LP token
+type CurveSource struct {
+	curve  *CurveClient
+	convex *ConvexClient
+	chains []string
+	minTVL float64
+}
+
+// NewCurveSource creates a Curve and Convex source for all supported chains
+func NewCurveSource() *CurveSource {
+	return &CurveSource{
+		curve:  NewCurveClient(),
+		convex: NewConvexClient(),
+		chains: []string{"ethereum", "arbitrum", "optimism", "base", "bsc", "sonic", "polygon", "fraxtal", "xdai"},
+		minTVL: DefaultCurveMinTVL,
+	}
+}
+
+// Name implements Source
+func (s *CurveSource) Name() string {
+	return CurveProtocol.Name
+}
+
+// Protocols implements Source
+func (s *CurveSource) Protocols() []models.Protocol {
+	return []models.Protocol{CurveProtocol, ConvexProtocol}
+}
+
+// FetchRates implements Source
+func (s *CurveSource) FetchRates(run *models.FetchRun) ([]models.YieldRate, error) {
+	return s.fetchRates(run, s.chains)
+}
+
+// HasChain implements ChainSource
+func (s *CurveSource) HasChain(chain string) bool {
+	return len(s.chainsFor(chain)) > 0
+}
+
+// FetchChainRates implements ChainSource
+func (s *CurveSource) FetchChainRates(run *models.FetchRun, chain string) ([]models.YieldRate, error) {
+	return s.fetchRates(run, s.chainsFor(chain))
+}
+
+// chainsFor returns the Curve blockchain ids named chain
+func (s *CurveSource) chainsFor(chain string) []string {
+	var chains []string
+	for _, c := range s.chains {
+		if strings.EqualFold(GetCurveChainName(c), chain) {
+			chains = append(chains, c)
+		}
+	}
+	return chains
+}
+
+// fetchRates fetches the Curve and Convex rates of the given Curve chains
+func (s *CurveSource) fetchRates(run *models.FetchRun, chains []string) ([]models.YieldRate, error) {
+	logger := runLogger(run)
+
+	// Convex is an optional layer on Ethereum; Curve rates are still useful without it
+	var convexAPYs map[string]ConvexPoolAPY
+	if slices.Contains(chains, "ethereum") {
+		var err error
+		convexAPYs, err = s.convex.GetPoolAPYs()
+		if err != nil {
+			logger.Warn("failed to fetch Convex APYs", "error", err)
+			run.AddError("convex: %v", err)
+		}
+	}
+
+	var rates []models.YieldRate
+	fetchedChains := 0
+	for _, chain := range chains {
+		run.ChainsAttempted = append(run.ChainsAttempted, GetCurveChainName(chain))
+		pools, err := s.curve.GetPools(chain)
+		if err != nil {
+			// Log error but continue with other chains
+			logger.Warn("failed to fetch Curve pools", "chain", GetCurveChainName(chain), "error", err)
+			run.AddError("chain %s: %v", chain, err)
+			continue
+		}
+		fetchedChains++
+		run.Received += len(pools)
+
+		baseAPYs, err := s.curve.GetBaseAPYs(chain)
+		if err != nil {
+			logger.Warn("failed to fetch Curve base APYs", "chain", GetCurveChainName(chain), "error", err)
+			run.AddError("base APYs %s: %v", chain, err)
+		}
+
+		for _, pool := range pools {
+			if pool.IsBroken || pool.USDTotal < s.minTVL {
+				continue
+			}
+			run.Active++
+
+			baseAPY := baseAPYs[strings.ToLower(pool.Address)]
+			rates = append(rates, convertCurvePoolToYieldRate(pool, baseAPY))
+
+			if chain != "ethereum" {
+				continue
+			}
+			if convexAPY, ok := convexAPYs[pool.ID]; ok {
+				rates = append(rates, convertConvexPoolToYieldRate(pool, convexAPY))
+			}
+		}
+	}
+
+	if fetchedChains == 0 {
+		return nil, fmt.Errorf("no pools fetched from any Curve chain")
+	}
+
+	return rates, nil
+}
+
+// convertCurvePoolToYieldRate converts a Curve pool to our internal YieldRate model,
+// using the unboosted CRV APY since that's what a plain LP earns
+func convertCurvePoolToYieldRate(pool CurvePool, baseAPY float64) models.YieldRate {
+	var rewards []models.RewardAPY
+	if len(pool.GaugeCrvAPY) > 0 && pool.GaugeCrvAPY[0] > 0 {
+		rewards = append(rewards, models.RewardAPY{Token: "CRV", APY: pool.GaugeCrvAPY[0]})
+	}
+	for _, reward := range pool.GaugeRewards {
+		if reward.APY > 0 {
+			rewards = append(rewards, models.RewardAPY{Token: reward.Symbol, APY: reward.APY})
+		}
+	}
+
+	externalURL := fmt.Sprintf("https://curve.finance/dex/%s/pools/%s/deposit", pool.Chain, pool.ID)
+	if len(pool.PoolURLs.Deposit) > 0 {
+		externalURL = pool.PoolURLs.Deposit[0]
+	}
+
+	return newRewardedYieldRate(CurveProtocol.Name, pool, baseAPY, rewards, externalURL)
+}
+
+// convertConvexPoolToYieldRate converts Convex's boosted APY on a Curve pool
+// to our internal YieldRate model
+func convertConvexPoolToYieldRate(pool CurvePool, apy ConvexPoolAPY) models.YieldRate {
+	var rewards []models.RewardAPY
+	if apy.CrvAPY > 0 {
+		rewards = append(rewards, models.RewardAPY{Token: "CRV", APY: apy.CrvAPY})
+	}
+	if apy.CvxAPY > 0 {
+		rewards = append(rewards, models.RewardAPY{Token: "CVX", APY: apy.CvxAPY})
+	}
+	for _, reward := range apy.ExtraRewards {
+		if reward.APY > 0 {
+			rewards = append(rewards, models.RewardAPY{Token: reward.Symbol, APY: reward.APY})
+		}
+	}
+
+	externalURL := "https://curve.convexfinance.com/stake/ethereum"
+	return newRewardedYieldRate(ConvexProtocol.Name, pool, apy.BaseAPY, rewards, externalURL)
+}
+
+// newRewardedYieldRate builds a yield rate whose APY is base plus all rewards
+func newRewardedYieldRate(protocolName string, pool CurvePool, baseAPY float64, rewards []models.RewardAPY, externalURL string) models.YieldRate {
+	rewardAPY := 0.0
+	for _, reward := range rewards {
+		rewardAPY += reward.APY
+	}
+
+	symbols := make([]string, 0, len(pool.Coins))
+	for _, coin := range pool.Coins {
+		symbols = append(symbols, coin.Symbol)
+	}
+
+	return models.YieldRate{
+		ProtocolName: protocolName,
+		Asset:        strings.Join(symbols, "/"),
+		Chain:        GetCurveChainName(pool.Chain),
+		APY:          baseAPY + rewardAPY,
+		BaseAPY:      baseAPY,
+		RewardAPY:    rewardAPY,
+		Rewards:      rewards,
+		TVL:          pool.USDTotal,
+		PoolName:     pool.ID,
+		ExternalURL:  externalURL,
+	}
+}
+
+// GetCurveChainName returns the human-readable chain name for a Curve blockchain id
+func GetCurveChainName(chain string) string {
+	if name, ok := CurveChainToName[chain]; ok {
+		return name
+	}
+	return chain
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// newMockCurveSource creates a Curve source backed by a mock server for Ethereum only
+func newMockCurveSource(t *testing.T, handler http.HandlerFunc) *CurveSource {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	httpClient := &http.Client{Timeout: 5 * time.Second}
+	return &CurveSource{
+		curve:  &CurveClient{httpClient: httpClient, baseURL: server.URL},
+		convex: &ConvexClient{httpClient: httpClient, baseURL: server.URL},
+		chains: []string{"ethereum"},
+		minTVL: DefaultCurveMinTVL,
+	}
+}
+
+// TestCurveSource_FetchRates tests Curve and Convex rates with reward breakdown
+func TestCurveSource_FetchRates(t *testing.T) {
+	source := newMockCurveSource(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/getPools/all/ethereum":
+			w.Write([]byte(`{
+				"success": true,
+				"data": {"poolData": [
+					{
+						"id": "3pool",
+						"address": "0xBEBC44782C7dB0a1A60Cb6fe97d0b483032FF1C7",
+						"name": "Curve.fi DAI/USDC/USDT",
+						"coins": [{"symbol": "DAI"}, {"symbol": "USDC"}, {"symbol": "USDT"}],
+						"usdTotal": 170000000,
+						"gaugeCrvApy": [1.5, 3.75],
+						"gaugeRewards": [{"symbol": "LDO", "apy": 0.5}],
+						"poolUrls": {"deposit": ["https://curve.finance/dex/ethereum/pools/3pool/deposit"]}
+					},
+					{
+						"id": "factory-v2-999",
+						"address": "0x0000000000000000000000000000000000000001",
+						"coins": [{"symbol": "DUST"}, {"symbol": "USDC"}],
+						"usdTotal": 12
+					},
+					{
+						"id": "factory-v2-1000",
+						"address": "0x0000000000000000000000000000000000000002",
+						"coins": [{"symbol": "BRK"}, {"symbol": "USDC"}],
+						"usdTotal": 5000000,
+						"isBroken": true
+					}
+				]}
+			}`))
+		case "/getBaseApys/ethereum":
+			w.Write([]byte(`{
+				"success": true,
+				"data": {"baseApys": [
+					{"address": "0xbebc44782c7db0a1a60cb6fe97d0b483032ff1c7", "latestDailyApyPcent": 0.4, "latestWeeklyApyPcent": 0.25}
+				]}
+			}`))
+		case "/curve-apys":
+			w.Write([]byte(`{
+				"apys": {
+					"3pool": {"baseApy": 0.25, "crvApy": 2.5, "cvxApy": 0.75, "extraRewards": []}
+				}
+			}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	rates, err := source.FetchRates(&models.FetchRun{})
+	if err != nil {
+		t.Fatalf("FetchRates() error = %v", err)
+	}
+
+	// Dust and broken pools are skipped; 3pool yields a Curve and a Convex rate
+	if len(rates) != 2 {
+		t.Fatalf("FetchRates() returned %d rates, want 2", len(rates))
+	}
+
+	curve, convex := rates[0], rates[1]
+
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"Curve protocol", curve.ProtocolName, "Curve"},
+		{"Curve asset", curve.Asset, "DAI/USDC/USDT"},
+		{"Curve chain", curve.Chain, "Ethereum"},
+		{"Curve pool", curve.PoolName, "3pool"},
+		{"Curve base APY", curve.BaseAPY, 0.25},
+		{"Curve reward APY", curve.RewardAPY, 2.0},
+		{"Curve APY", curve.APY, 2.25},
+		{"Curve rewards", len(curve.Rewards), 2},
+		{"Curve CRV reward", curve.Rewards[0].Token, "CRV"},
+		{"Curve TVL", curve.TVL, 170000000.0},
+		{"Convex protocol", convex.ProtocolName, "Convex"},
+		{"Convex reward APY", convex.RewardAPY, 3.25},
+		{"Convex APY", convex.APY, 3.5},
+		{"Convex rewards", len(convex.Rewards), 2},
+		{"Convex CVX reward", convex.Rewards[1].Token, "CVX"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCurveSource_ConvexUnavailable tests that Curve rates survive a Convex outage
+func TestCurveSource_ConvexUnavailable(t *testing.T) {
+	source := newMockCurveSource(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/getPools/all/ethereum":
+			w.Write([]byte(`{"success": true, "data": {"poolData": [
+				{"id": "3pool", "address": "0x1", "coins": [{"symbol": "DAI"}], "usdTotal": 1000000}
+			]}}`))
+		case "/getBaseApys/ethereum":
+			w.Write([]byte(`{"success": true, "data": {"baseApys": []}}`))
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	})
+
+	rates, err := source.FetchRates(&models.FetchRun{})
+	if err != nil {
+		t.Fatalf("FetchRates() error = %v", err)
+	}
+
+	if len(rates) != 1 || rates[0].ProtocolName != "Curve" {
+		t.Errorf("FetchRates() = %+v, want a single Curve rate", rates)
+	}
+}
+
+// TestCurveSource_AllChainsFail tests that an error is returned when no chain responds
+func TestCurveSource_AllChainsFail(t *testing.T) {
+	source := newMockCurveSource(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	if _, err := source.FetchRates(&models.FetchRun{}); err == nil {
+		t.Error("FetchRates() should return an error when every chain fails")
+	}
+}
+
+// TestGetCurveChainName tests Curve blockchain id to name mapping
+func TestGetCurveChainName(t *testing.T) {
+	tests := []struct {
+		chain    string
+		wantName string
+	}{
+		{"ethereum", "Ethereum"},
+		{"arbitrum", "Arbitrum"},
+		{"xdai", "Gnosis"},
+		{"unknown", "unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.chain, func(t *testing.T) {
+			if got := GetCurveChainName(tt.chain); got != tt.wantName {
+				t.Errorf("GetCurveChainName(%s) = %s, want %s", tt.chain, got, tt.wantName)
+			}
+		})
+	}
+}
@@ -1,123 +1,350 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/pretty-andrechal/defirates/internal/database"
 	"github.com/pretty-andrechal/defirates/internal/models"
 )
 
+// Source is a protocol adapter that the Fetcher polls for yield rates
+type Source interface {
+	// Name identifies the source in logs
+	Name() string
+	// Protocols lists every protocol the source reports rates for
+	Protocols() []models.Protocol
+	// FetchRates returns the current rates; each rate's ProtocolName must
+	// match one of the protocols returned by Protocols. Sources record the
+	// chains they tried, market counts and non-fatal errors on run
+	FetchRates(run *models.FetchRun) ([]models.YieldRate, error)
+}
+
+// ChainSource is implemented by sources that can fetch a single chain, which
+// lets a refresh be scoped to that chain
+type ChainSource interface {
+	Source
+	// HasChain reports whether the source fetches rates on chain
+	HasChain(chain string) bool
+	// FetchChainRates is FetchRates limited to chain
+	FetchChainRates(run *models.FetchRun, chain string) ([]models.YieldRate, error)
+}
+
+var (
+	// ErrFetchInProgress is returned when a source is already being fetched
+	ErrFetchInProgress = errors.New("a fetch is already running")
+	// ErrUnknownChain is returned when a refresh names a chain no source fetches
+	ErrUnknownChain = errors.New("no source fetches chain")
+)
+
+// Publisher is told about the rates stored by every successful fetch run
+type Publisher interface {
+	Publish(update models.RatesUpdate)
+}
+
+// Publishers publishes every update to each of its publishers in turn
+type Publishers []Publisher
+
+// Publish implements Publisher
+func (p Publishers) Publish(update models.RatesUpdate) {
+	for _, publisher := range p {
+		publisher.Publish(update)
+	}
+}
+
 // Fetcher handles fetching and storing yield data
 type Fetcher struct {
-	db     *database.DB
-	pendle *PendleClient
+	db        database.Store
+	pendle    *PendleClient
+	sources   []Source
+	publisher Publisher
+
+	// running holds the names of the sources being fetched, so a source
+	// never has two runs at once, whether scheduled or manual
+	mu      sync.Mutex
+	running map[string]bool
 }
 
 // NewFetcher creates a new data fetcher
-func NewFetcher(db *database.DB) *Fetcher {
+func NewFetcher(db database.Store) *Fetcher {
 	return &Fetcher{
-		db:     db,
-		pendle: NewPendleClient(),
+		db:      db,
+		pendle:  NewPendleClient(),
+		running: make(map[string]bool),
+	}
+}
+
+// AddSource registers an additional source to be fetched alongside Pendle
+func (f *Fetcher) AddSource(source Source) {
+	f.sources = append(f.sources, source)
+}
+
+// SetPublisher sends a models.RatesUpdate to publisher after every successful fetch run
+func (f *Fetcher) SetPublisher(publisher Publisher) {
+	f.publisher = publisher
+}
+
+// Sources returns Pendle followed by every registered source
+func (f *Fetcher) Sources() []Source {
+	return append([]Source{f.pendle}, f.sources...)
+}
+
+// acquire marks the sources as running. If any of them already is, nothing
+// is marked and it returns false
+func (f *Fetcher) acquire(sources ...Source) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, source := range sources {
+		if f.running[source.Name()] {
+			return false
+		}
+	}
+	for _, source := range sources {
+		f.running[source.Name()] = true
+	}
+	return true
+}
+
+// release marks the sources as no longer running
+func (f *Fetcher) release(sources ...Source) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, source := range sources {
+		delete(f.running, source.Name())
 	}
 }
 
 // FetchAndStorePendleData fetches data from Pendle and stores it in the database
 func (f *Fetcher) FetchAndStorePendleData() error {
-	log.Println("Fetching Pendle markets...")
+	run, err := f.FetchAndStore(f.pendle)
+	if err != nil {
+		logFetchError(f.pendle, run, err)
+	}
+	return nil
+}
+
+// logFetchError logs a failed FetchAndStore of source
+func logFetchError(source Source, run *models.FetchRun, err error) {
+	switch {
+	case errors.Is(err, ErrFetchInProgress):
+		slog.Info("skipping fetch; the source is already being fetched", "source", source.Name())
+	case source.Name() == PendleProtocol.Name:
+		runLogger(run).Warn("failed to fetch Pendle markets; the API may be rate-limited or unavailable. "+
+			"Existing data is still served, run with -load-sample to see sample data", "error", err)
+	default:
+		runLogger(run).Error("fetch failed", "error", err)
+	}
+}
 
-	// Ensure Pendle protocol exists in database
-	protocol := &models.Protocol{
-		Name:        "Pendle",
-		URL:         "https://www.pendle.finance",
-		Description: "Pendle is a protocol that enables the tokenization and trading of future yield",
+// runLogger returns a logger tagged with the source and ID of a fetch run
+func runLogger(run *models.FetchRun) *slog.Logger {
+	return slog.With("source", run.Source, "run_id", run.ID)
+}
+
+// FetchAndStore fetches rates from a source, stores them in the database and
+// records the cycle in the fetch_runs table. If the source is already being
+// fetched it returns ErrFetchInProgress and no run
+func (f *Fetcher) FetchAndStore(source Source) (*models.FetchRun, error) {
+	if !f.acquire(source) {
+		return nil, fmt.Errorf("%w for %s", ErrFetchInProgress, source.Name())
 	}
+	defer f.release(source)
 
-	if err := f.db.CreateOrUpdateProtocol(protocol); err != nil {
-		return fmt.Errorf("failed to create/update protocol: %w", err)
+	return f.fetchAndStoreWith(source, source.FetchRates)
+}
+
+// FetchAndStoreChain is FetchAndStore limited to one chain of the source
+func (f *Fetcher) FetchAndStoreChain(source ChainSource, chain string) (*models.FetchRun, error) {
+	if !f.acquire(source) {
+		return nil, fmt.Errorf("%w for %s", ErrFetchInProgress, source.Name())
 	}
+	defer f.release(source)
 
-	// Fetch active markets
-	markets, err := f.pendle.GetActiveMarkets()
-	if err != nil {
-		log.Printf("Warning: failed to fetch Pendle markets: %v", err)
-		log.Println("The Pendle API may be rate-limited or unavailable.")
-		log.Println("You can still use the application - it will show any existing data.")
-		log.Println("To see sample data, run with the -load-sample flag.")
-		return nil
+	return f.fetchAndStoreWith(source, chainFetch(source, chain))
+}
+
+// chainFetch returns a fetch function limited to chain
+func chainFetch(source ChainSource, chain string) func(*models.FetchRun) ([]models.YieldRate, error) {
+	return func(run *models.FetchRun) ([]models.YieldRate, error) {
+		return source.FetchChainRates(run, chain)
 	}
+}
 
-	log.Printf("Found %d active Pendle markets", len(markets))
+// fetchAndStoreWith runs fetch as a recorded fetch run of source
+func (f *Fetcher) fetchAndStoreWith(source Source, fetch func(*models.FetchRun) ([]models.YieldRate, error)) (*models.FetchRun, error) {
+	run := &models.FetchRun{
+		Source:    source.Name(),
+		Status:    models.FetchRunRunning,
+		StartedAt: time.Now(),
+	}
+	if err := f.db.CreateFetchRun(run); err != nil {
+		runLogger(run).Error("failed to record fetch run", "error", err)
+	}
 
-	// Store each market as a yield rate
-	successCount := 0
-	for _, market := range markets {
-		yieldRate := f.convertMarketToYieldRate(market, protocol.ID)
+	logger := runLogger(run)
+	logger.Info("fetch started")
 
-		if err := f.db.UpsertYieldRate(&yieldRate); err != nil {
-			log.Printf("Failed to store yield rate for %s: %v", market.Name, err)
-			continue
+	changes, err := f.fetchAndStore(source, run, fetch)
+
+	finishedAt := time.Now()
+	run.FinishedAt = &finishedAt
+	switch {
+	case err != nil:
+		run.Status = models.FetchRunFailed
+		run.AddError("%v", err)
+	case len(run.Errors) > 0 || run.Failed > 0:
+		run.Status = models.FetchRunPartial
+	default:
+		run.Status = models.FetchRunSuccess
+	}
+
+	if run.ID != 0 {
+		if err := f.db.FinishFetchRun(run); err != nil {
+			logger.Error("failed to record fetch run", "error", err)
 		}
-		successCount++
 	}
 
-	log.Printf("Successfully stored %d yield rates", successCount)
-	return nil
+	if err == nil && f.publisher != nil {
+		// Clients read changes as a list, even when no APY moved
+		if changes == nil {
+			changes = make([]models.RateChange, 0)
+		}
+		f.publisher.Publish(models.RatesUpdate{
+			Source:  run.Source,
+			RunID:   run.ID,
+			At:      finishedAt,
+			Changes: changes,
+		})
+	}
+
+	logger.Info("fetch finished",
+		"status", run.Status,
+		"duration", run.Duration(),
+		"received", run.Received,
+		"upserted", run.Upserted,
+		"inserted", run.Inserted,
+		"updated", run.Updated,
+		"unchanged", run.Unchanged,
+		"failed", run.Failed,
+	)
+
+	return run, err
 }
 
-// convertMarketToYieldRate converts a Pendle market to our internal YieldRate model
-func (f *Fetcher) convertMarketToYieldRate(market Market, protocolID int64) models.YieldRate {
-	// Parse expiry date
-	var maturityDate *time.Time
-	if expiry, err := time.Parse("2006-01-02T15:04:05.000Z", market.Expiry); err == nil {
-		maturityDate = &expiry
-	} else if expiry, err := time.Parse(time.RFC3339, market.Expiry); err == nil {
-		maturityDate = &expiry
+// fetchAndStore does the work of FetchAndStore, counting stored rows on run
+// and returning the APY changes of the stored rates
+func (f *Fetcher) fetchAndStore(source Source, run *models.FetchRun, fetch func(*models.FetchRun) ([]models.YieldRate, error)) ([]models.RateChange, error) {
+	logger := runLogger(run)
+
+	// Ensure every protocol of the source exists in the database
+	protocolIDs := make(map[string]int64)
+	for _, protocol := range source.Protocols() {
+		// The Manual protocol is reserved for hand-maintained rates
+		if protocol.Name == models.ManualProtocol.Name && !models.IsManualSource(source.Name()) {
+			return nil, fmt.Errorf("source %s can't report rates under the %s protocol", source.Name(), protocol.Name)
+		}
+		if err := f.db.CreateOrUpdateProtocol(&protocol); err != nil {
+			return nil, fmt.Errorf("failed to create/update protocol %s: %w", protocol.Name, err)
+		}
+		protocolIDs[protocol.Name] = protocol.ID
 	}
 
-	// Use market name as asset (e.g., "wstETH", "sUSDe")
-	asset := market.Name
+	rates, err := fetch(run)
+	if err != nil {
+		return nil, err
+	}
 
-	// Get chain name
-	chain := GetChainName(market.ChainID)
+	logger.Debug("rates fetched", "count", len(rates))
 
-	// Convert implied APY from decimal to percentage
-	apy := market.Details.ImpliedAPY * 100
+	// Store the rates under their protocols in one transaction
+	valid := make([]models.YieldRate, 0, len(rates))
+	for _, rate := range rates {
+		protocolID, ok := protocolIDs[rate.ProtocolName]
+		if !ok {
+			logger.Warn("skipping rate with unknown protocol", "pool", rate.PoolName, "protocol", rate.ProtocolName)
+			run.Failed++
+			continue
+		}
+		rate.ProtocolID = protocolID
+		valid = append(valid, rate)
+	}
 
-	// TVL is the liquidity in USD
-	tvl := market.Details.Liquidity
+	result, err := f.db.ReplaceSourceRates(source.Name(), valid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store rates: %w", err)
+	}
+	for i, failure := range result.Failed {
+		logger.Warn("failed to store yield rate", "pool", failure.PoolName, "chain", failure.Chain, "error", failure.Err)
+		if i < 3 {
+			run.AddError("%v", failure)
+		}
+	}
+	run.Failed += len(result.Failed)
+	run.Upserted = result.Stored()
+	run.Inserted = result.Inserted
+	run.Updated = result.Updated
+	run.Unchanged = result.Unchanged
+	if result.Removed > 0 {
+		logger.Info("removed rates missing from the source", "count", result.Removed)
+	}
 
-	// Generate pool name and external URL
-	poolName := fmt.Sprintf("%s-%d", market.Name, market.ChainID)
-	externalURL := fmt.Sprintf("https://app.pendle.finance/trade/pools/%s/", market.Address)
+	return result.Changes, nil
+}
 
-	return models.YieldRate{
-		ProtocolID:   protocolID,
-		Asset:        asset,
-		Chain:        chain,
-		APY:          apy,
-		TVL:          tvl,
-		MaturityDate: maturityDate,
-		PoolName:     poolName,
-		ExternalURL:  externalURL,
+// FetchAll fetches and stores data from Pendle and every registered source,
+// skipping sources that are already being fetched
+func (f *Fetcher) FetchAll() {
+	for _, source := range f.Sources() {
+		if run, err := f.FetchAndStore(source); err != nil {
+			logFetchError(source, run, err)
+		}
 	}
 }
 
-// StartPeriodicFetch starts a background goroutine that fetches data periodically
-func (f *Fetcher) StartPeriodicFetch(interval time.Duration) {
-	// Fetch immediately on startup
-	if err := f.FetchAndStorePendleData(); err != nil {
-		log.Printf("Error fetching Pendle data on startup: %v", err)
-	}
-
-	// Then fetch periodically
-	ticker := time.NewTicker(interval)
-	go func() {
-		for range ticker.C {
-			if err := f.FetchAndStorePendleData(); err != nil {
-				log.Printf("Error fetching Pendle data: %v", err)
-			}
+// Refresh fetches every source right away and returns their runs. With a
+// chain, only sources covering that chain are fetched and only for that
+// chain. It returns ErrFetchInProgress instead of waiting if any of those
+// sources is already being fetched
+func (f *Fetcher) Refresh(chain string) ([]*models.FetchRun, error) {
+	chain = strings.TrimSpace(chain)
+
+	var targets []Source
+	for _, source := range f.Sources() {
+		if chain == "" {
+			targets = append(targets, source)
+		} else if chainSource, ok := source.(ChainSource); ok && chainSource.HasChain(chain) {
+			targets = append(targets, source)
 		}
-	}()
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("%w %s", ErrUnknownChain, chain)
+	}
+
+	if !f.acquire(targets...) {
+		return nil, ErrFetchInProgress
+	}
+	defer f.release(targets...)
+
+	runs := make([]*models.FetchRun, 0, len(targets))
+	for _, source := range targets {
+		fetch := source.FetchRates
+		if chain != "" {
+			fetch = chainFetch(source.(ChainSource), chain)
+		}
+		run, _ := f.fetchAndStoreWith(source, fetch)
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+// StartPeriodicFetch fetches every source now and then every interval, see Scheduler
+func (f *Fetcher) StartPeriodicFetch(interval time.Duration) {
+	NewScheduler(f, Schedule{Interval: interval}).Start(context.Background())
 }
@@ -0,0 +1,287 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/pretty-andrechal/defirates/internal/database"
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// fakeSource is a Source returning canned rates
+type fakeSource struct {
+	name      string
+	protocols []models.Protocol
+	rates     []models.YieldRate
+	err       error
+}
+
+func (s *fakeSource) Name() string                 { return s.name }
+func (s *fakeSource) Protocols() []models.Protocol { return s.protocols }
+
+func (s *fakeSource) FetchRates(run *models.FetchRun) ([]models.YieldRate, error) {
+	run.ChainsAttempted = []string{"Ethereum"}
+	run.Received = len(s.rates)
+	run.Active = len(s.rates)
+	return s.rates, s.err
+}
+
+// fakeChainSource is a fakeSource that can be scoped to its chains
+type fakeChainSource struct {
+	fakeSource
+	chains []string
+}
+
+func (s *fakeChainSource) HasChain(chain string) bool {
+	for _, c := range s.chains {
+		if c == chain {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *fakeChainSource) FetchChainRates(run *models.FetchRun, chain string) ([]models.YieldRate, error) {
+	var rates []models.YieldRate
+	for _, rate := range s.rates {
+		if rate.Chain == chain {
+			rates = append(rates, rate)
+		}
+	}
+	run.ChainsAttempted = []string{chain}
+	return rates, nil
+}
+
+// setupTestFetcher creates a fetcher with a temporary database and a Pendle
+// client pointed at an unavailable API
+func setupTestFetcher(t *testing.T) (*Fetcher, *database.DB) {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+
+	dbPath := "test_fetcher_" + t.Name() + ".db"
+	db, err := database.New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		os.Remove(dbPath)
+	})
+
+	fetcher := NewFetcher(db)
+	fetcher.pendle.baseURL = server.URL
+	return fetcher, db
+}
+
+// TestFetcher_FetchAndStore tests that rates are stored and the run is recorded
+func TestFetcher_FetchAndStore(t *testing.T) {
+	fetcher, db := setupTestFetcher(t)
+
+	source := &fakeSource{
+		name:      "Fake",
+		protocols: []models.Protocol{{Name: "FakeProtocol"}},
+		rates: []models.YieldRate{
+			{ProtocolName: "FakeProtocol", Asset: "ETH", Chain: "Ethereum", APY: 4, PoolName: "ETH-1"},
+			{ProtocolName: "FakeProtocol", Asset: "USDC", Chain: "Ethereum", APY: 6, PoolName: "USDC-1"},
+			{ProtocolName: "Unknown", Asset: "DAI", Chain: "Ethereum", APY: 5, PoolName: "DAI-1"},
+		},
+	}
+
+	run, err := fetcher.FetchAndStore(source)
+	if err != nil {
+		t.Fatalf("FetchAndStore() error = %v", err)
+	}
+
+	if run.Upserted != 2 || run.Failed != 1 || run.Status != models.FetchRunPartial {
+		t.Errorf("run = %+v, want 2 upserted, 1 failed, partial", run)
+	}
+
+	rates, _ := db.GetYieldRates(models.FilterParams{})
+	if len(rates) != 2 || rates[0].Source != "Fake" {
+		t.Errorf("stored rates = %+v, want 2 rates from Fake", rates)
+	}
+
+	runs, err := db.GetFetchRuns("Fake", 10)
+	if err != nil {
+		t.Fatalf("GetFetchRuns() error = %v", err)
+	}
+	if len(runs) != 1 || runs[0].Upserted != 2 || runs[0].Inserted != 2 || runs[0].FinishedAt == nil {
+		t.Errorf("recorded runs = %+v, want one finished run", runs)
+	}
+
+	// Fetching the same rates again stores them unchanged
+	run, err = fetcher.FetchAndStore(source)
+	if err != nil {
+		t.Fatalf("FetchAndStore() error = %v", err)
+	}
+	if run.Upserted != 2 || run.Unchanged != 2 || run.Inserted != 0 || run.Updated != 0 {
+		t.Errorf("second run = %+v, want 2 unchanged", run)
+	}
+}
+
+// TestFetcher_FetchAndStoreFailure tests that a failing source is recorded as failed
+func TestFetcher_FetchAndStoreFailure(t *testing.T) {
+	fetcher, db := setupTestFetcher(t)
+
+	source := &fakeSource{
+		name:      "Broken",
+		protocols: []models.Protocol{{Name: "Broken"}},
+		err:       errors.New("API returned status 503"),
+	}
+
+	run, err := fetcher.FetchAndStore(source)
+	if err == nil {
+		t.Fatal("FetchAndStore() should return the source error")
+	}
+
+	runs, _ := db.GetFetchRuns("Broken", 10)
+	if len(runs) != 1 || runs[0].Status != models.FetchRunFailed || len(runs[0].Errors) != 1 {
+		t.Errorf("recorded runs = %+v, want one failed run with the error", runs)
+	}
+	if run.Status != models.FetchRunFailed {
+		t.Errorf("run status = %s, want failed", run.Status)
+	}
+}
+
+// TestFetcher_ManualProtocolReserved tests that automated sources can't write Manual rates
+func TestFetcher_ManualProtocolReserved(t *testing.T) {
+	fetcher, db := setupTestFetcher(t)
+
+	source := &fakeSource{
+		name:      "Sneaky",
+		protocols: []models.Protocol{models.ManualProtocol},
+		rates:     []models.YieldRate{{ProtocolName: "Manual", Asset: "USDC", Chain: "Ethereum", APY: 99, PoolName: "OTC"}},
+	}
+
+	if _, err := fetcher.FetchAndStore(source); err == nil {
+		t.Error("FetchAndStore() should refuse rates under the Manual protocol")
+	}
+
+	rates, _ := db.GetManualRates()
+	if len(rates) != 0 {
+		t.Errorf("manual rates = %+v, want none", rates)
+	}
+}
+
+// TestFetcher_Refresh tests full and chain-scoped refreshes and overlap protection
+func TestFetcher_Refresh(t *testing.T) {
+	fetcher, db := setupTestFetcher(t)
+
+	source := &fakeChainSource{
+		fakeSource: fakeSource{
+			name:      "Fake",
+			protocols: []models.Protocol{{Name: "FakeProtocol"}},
+			rates: []models.YieldRate{
+				{ProtocolName: "FakeProtocol", Asset: "ETH", Chain: "Ethereum", APY: 4, PoolName: "ETH-1"},
+				{ProtocolName: "FakeProtocol", Asset: "USDC", Chain: "Base", APY: 6, PoolName: "USDC-1"},
+			},
+		},
+		chains: []string{"Ethereum", "Base"},
+	}
+	fetcher.AddSource(source)
+	fetcher.AddSource(&fakeSource{name: "Unscoped", protocols: []models.Protocol{{Name: "Unscoped"}}})
+
+	// A full refresh fetches Pendle and every source
+	runs, err := fetcher.Refresh("")
+	if err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if len(runs) != 3 || runs[0].Source != "Pendle" || runs[0].Status != models.FetchRunFailed {
+		t.Fatalf("Refresh() runs = %+v, want a failed Pendle run and two source runs", runs)
+	}
+	if runs[1].Upserted != 2 {
+		t.Errorf("Fake run upserted %d rates, want 2", runs[1].Upserted)
+	}
+
+	// A chain refresh only fetches sources covering the chain
+	runs, err = fetcher.Refresh("Base")
+	if err != nil {
+		t.Fatalf("Refresh(Base) error = %v", err)
+	}
+	if len(runs) != 2 || runs[1].Source != "Fake" || runs[1].Upserted != 1 {
+		t.Errorf("Refresh(Base) runs = %+v, want Pendle and one Fake rate", runs)
+	}
+
+	if _, err := fetcher.Refresh("Narnia"); !errors.Is(err, ErrUnknownChain) {
+		t.Errorf("Refresh(Narnia) error = %v, want ErrUnknownChain", err)
+	}
+
+	// Refreshes never overlap a running fetch of one of their sources
+	fetcher.acquire(source)
+	_, err = fetcher.Refresh("")
+	_, chainErr := fetcher.Refresh("Ethereum")
+	_, storeErr := fetcher.FetchAndStore(source)
+	fetcher.release(source)
+	if !errors.Is(err, ErrFetchInProgress) || !errors.Is(chainErr, ErrFetchInProgress) || !errors.Is(storeErr, ErrFetchInProgress) {
+		t.Errorf("fetches during a run errors = %v, %v, %v, want ErrFetchInProgress", err, chainErr, storeErr)
+	}
+
+	recorded, _ := db.GetFetchRuns("Fake", 10)
+	if len(recorded) != 2 {
+		t.Errorf("recorded %d Fake runs, want 2", len(recorded))
+	}
+}
+
+// recordingPublisher keeps every published update
+type recordingPublisher struct {
+	updates []models.RatesUpdate
+}
+
+func (p *recordingPublisher) Publish(update models.RatesUpdate) {
+	p.updates = append(p.updates, update)
+}
+
+// TestFetcher_PublishesChanges tests that successful runs publish their APY changes
+func TestFetcher_PublishesChanges(t *testing.T) {
+	fetcher, _ := setupTestFetcher(t)
+	publisher := &recordingPublisher{}
+	fetcher.SetPublisher(publisher)
+
+	source := &fakeSource{
+		name:      "Fake",
+		protocols: []models.Protocol{{Name: "FakeProtocol"}},
+		rates: []models.YieldRate{
+			{ProtocolName: "FakeProtocol", Asset: "ETH", Chain: "Ethereum", APY: 4, PoolName: "ETH-1"},
+			{ProtocolName: "FakeProtocol", Asset: "USDC", Chain: "Ethereum", APY: 6, PoolName: "USDC-1"},
+		},
+	}
+
+	fetcher.FetchAndStore(source)
+	source.rates[0].APY = 4.5
+	run, _ := fetcher.FetchAndStore(source)
+
+	if len(publisher.updates) != 2 {
+		t.Fatalf("published %d updates, want 2", len(publisher.updates))
+	}
+	if first := publisher.updates[0]; len(first.Changes) != 2 || first.Changes[0].Direction != models.DirectionNew {
+		t.Errorf("first update = %+v, want two new pools", first)
+	}
+
+	second := publisher.updates[1]
+	if second.RunID != run.ID || second.Source != "Fake" {
+		t.Errorf("second update = %+v, want run %d of Fake", second, run.ID)
+	}
+	if len(second.Changes) != 1 || second.Changes[0].PoolName != "ETH-1" || second.Changes[0].Direction != models.DirectionUp {
+		t.Errorf("second update changes = %+v, want ETH-1 up", second.Changes)
+	}
+
+	// Runs without changes publish an empty list, which clients can iterate
+	fetcher.FetchAndStore(source)
+	if third := publisher.updates[2]; third.Changes == nil || len(third.Changes) != 0 {
+		t.Errorf("unchanged run published changes %#v, want an empty list", third.Changes)
+	}
+
+	// Failed runs publish nothing
+	source.err = errors.New("API returned status 503")
+	fetcher.FetchAndStore(source)
+	if len(publisher.updates) != 3 {
+		t.Errorf("published %d updates after a failed run, want 3", len(publisher.updates))
+	}
+}
@@ -78,18 +78,6 @@ func TestIntegration_FetchAndStorePendleData(t *testing.T) {
 
 // TestIntegration_ConvertMarketToYieldRate tests market conversion logic
 func TestIntegration_ConvertMarketToYieldRate(t *testing.T) {
-	dbPath := "test_convert_" + t.Name() + ".db"
-	db, err := database.New(dbPath)
-	if err != nil {
-		t.Fatalf("Failed to create test database: %v", err)
-	}
-	defer func() {
-		db.Close()
-		os.Remove(dbPath)
-	}()
-
-	fetcher := NewFetcher(db)
-
 	market := Market{
 		Name:    "wstETH",
 		Address: "0xabc123",
@@ -101,7 +89,7 @@ func TestIntegration_ConvertMarketToYieldRate(t *testing.T) {
 		},
 	}
 
-	yieldRate := fetcher.convertMarketToYieldRate(market, 1)
+	yieldRate := convertMarketToYieldRate(market, 1)
 
 	// Verify conversion
 	tests := []struct {
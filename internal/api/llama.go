@@ -0,0 +1,268 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+const (
+	DefiLlamaYieldsURL = "https://yields.llama.fi/pools"
+)
+
+// LlamaProjectNames gives display names to well-known DefiLlama project slugs;
+// other slugs are title-cased
+var LlamaProjectNames = map[string]string{
+	"aave-v2":         "Aave V2",
+	"aave-v3":         "Aave V3",
+	"compound-v2":     "Compound V2",
+	"compound-v3":     "Compound V3",
+	"morpho-blue":     "Morpho",
+	"spark":           "Spark",
+	"sky-lending":     "Sky",
+	"lido":            "Lido",
+	"rocket-pool":     "Rocket Pool",
+	"ethena-usde":     "Ethena",
+	"fluid-lending":   "Fluid",
+	"euler-v2":        "Euler",
+	"yearn-finance":   "Yearn",
+	"beefy":           "Beefy",
+	"uniswap-v3":      "Uniswap V3",
+	"aerodrome-v1":    "Aerodrome",
+	"balancer-v2":     "Balancer",
+	"gearbox":         "Gearbox",
+	"venus-core-pool": "Venus",
+}
+
+// LlamaPool is a single entry of a DefiLlama yields pools document
+type LlamaPool struct {
+	Pool      string   `json:"pool"`
+	Project   string   `json:"project"`
+	Chain     string   `json:"chain"`
+	Symbol    string   `json:"symbol"`
+	TVLUsd    float64  `json:"tvlUsd"`
+	APY       *float64 `json:"apy"`
+	APYBase   *float64 `json:"apyBase"`
+	APYReward *float64 `json:"apyReward"`
+}
+
+// LlamaPoolsResponse is the DefiLlama yields pools document
+type LlamaPoolsResponse struct {
+	Status string      `json:"status"`
+	Data   []LlamaPool `json:"data"`
+}
+
+// LlamaSource ingests a DefiLlama-style yields pools document from a URL or
+// a local snapshot file, keeping only allow-listed projects
+type LlamaSource struct {
+	httpClient *http.Client
+	location   string
+	projects   map[string]models.Protocol
+
+	mu     sync.Mutex
+	chains map[string]bool // Chains of the last pools document, lowercased
+}
+
+// NewLlamaSource creates a DefiLlama source reading from location (an http(s)
+// URL or a file path) for the projects in the allow-list
+func NewLlamaSource(location string, projects map[string]models.Protocol) *LlamaSource {
+	return &LlamaSource{
+		httpClient: &http.Client{
+			Timeout: 60 * time.Second,
+		},
+		location: location,
+		projects: projects,
+	}
+}
+
+// ParseLlamaProjects parses a comma-separated project allow-list. Each entry is
+// a DefiLlama project slug, optionally followed by "=Protocol Name" to override
+// the display name (e.g., "aave-v3,morpho-blue=Morpho Blue"). Names of the
+// reserved protocols, which other sources own, are rejected
+func ParseLlamaProjects(spec string, reserved ...models.Protocol) (map[string]models.Protocol, error) {
+	projects := make(map[string]models.Protocol)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		slug, name, _ := strings.Cut(entry, "=")
+		slug = strings.ToLower(strings.TrimSpace(slug))
+		name = strings.TrimSpace(name)
+		if name == "" {
+			name = llamaProjectName(slug)
+		}
+		for _, protocol := range reserved {
+			if strings.EqualFold(name, protocol.Name) {
+				return nil, fmt.Errorf("project %s: protocol name %q belongs to another source", slug, name)
+			}
+		}
+
+		projects[slug] = models.Protocol{
+			Name:        name,
+			URL:         "https://defillama.com/protocol/" + slug,
+			Description: fmt.Sprintf("Imported from DefiLlama yields (%s)", slug),
+		}
+	}
+	return projects, nil
+}
+
+// llamaProjectName returns the display name for a DefiLlama project slug
+func llamaProjectName(slug string) string {
+	if name, ok := LlamaProjectNames[slug]; ok {
+		return name
+	}
+
+	words := strings.Split(slug, "-")
+	for i, word := range words {
+		if len(word) > 1 && word[0] == 'v' && strings.Trim(word[1:], "0123456789") == "" {
+			words[i] = strings.ToUpper(word)
+		} else if word != "" {
+			words[i] = strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// Name implements Source
+func (s *LlamaSource) Name() string {
+	return "DefiLlama"
+}
+
+// Protocols implements Source
+func (s *LlamaSource) Protocols() []models.Protocol {
+	protocols := make([]models.Protocol, 0, len(s.projects))
+	seen := make(map[string]bool)
+	for _, protocol := range s.projects {
+		if seen[protocol.Name] {
+			continue
+		}
+		seen[protocol.Name] = true
+		protocols = append(protocols, protocol)
+	}
+	sort.Slice(protocols, func(i, j int) bool { return protocols[i].Name < protocols[j].Name })
+	return protocols
+}
+
+// FetchRates implements Source
+func (s *LlamaSource) FetchRates(run *models.FetchRun) ([]models.YieldRate, error) {
+	return s.fetchRates(run, "")
+}
+
+// HasChain implements ChainSource, matching chain against the chains of the
+// last pools document. The document is read first if none was yet
+func (s *LlamaSource) HasChain(chain string) bool {
+	s.mu.Lock()
+	chains := s.chains
+	s.mu.Unlock()
+	if chains == nil {
+		if _, err := s.GetPools(); err != nil {
+			return false
+		}
+		s.mu.Lock()
+		chains = s.chains
+		s.mu.Unlock()
+	}
+	return chains[strings.ToLower(chain)]
+}
+
+// FetchChainRates implements ChainSource
+func (s *LlamaSource) FetchChainRates(run *models.FetchRun, chain string) ([]models.YieldRate, error) {
+	return s.fetchRates(run, chain)
+}
+
+// fetchRates converts the pools of the allowed projects to yield rates,
+// keeping only pools on chain unless it is empty
+func (s *LlamaSource) fetchRates(run *models.FetchRun, chain string) ([]models.YieldRate, error) {
+	pools, err := s.GetPools()
+	if err != nil {
+		return nil, err
+	}
+	if chain != "" {
+		run.ChainsAttempted = []string{chain}
+	}
+
+	var rates []models.YieldRate
+	for _, pool := range pools {
+		if chain != "" && !strings.EqualFold(pool.Chain, chain) {
+			continue
+		}
+		run.Received++
+
+		protocol, ok := s.projects[strings.ToLower(pool.Project)]
+		if !ok || pool.APY == nil {
+			continue
+		}
+		rates = append(rates, convertLlamaPoolToYieldRate(pool, protocol.Name))
+	}
+	run.Active = len(rates)
+
+	return rates, nil
+}
+
+// GetPools reads the pools document from the configured URL or file
+func (s *LlamaSource) GetPools() ([]LlamaPool, error) {
+	var poolsResp LlamaPoolsResponse
+
+	if strings.HasPrefix(s.location, "http://") || strings.HasPrefix(s.location, "https://") {
+		if err := getJSON(s.httpClient, s.location, &poolsResp); err != nil {
+			return nil, err
+		}
+		s.setChains(poolsResp.Data)
+		return poolsResp.Data, nil
+	}
+
+	body, err := os.ReadFile(strings.TrimPrefix(s.location, "file://"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pools snapshot: %w", err)
+	}
+
+	if err := json.Unmarshal(body, &poolsResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal pools snapshot: %w", err)
+	}
+
+	s.setChains(poolsResp.Data)
+	return poolsResp.Data, nil
+}
+
+// setChains records the chains of pools for HasChain
+func (s *LlamaSource) setChains(pools []LlamaPool) {
+	chains := make(map[string]bool)
+	for _, pool := range pools {
+		chains[strings.ToLower(pool.Chain)] = true
+	}
+	s.mu.Lock()
+	s.chains = chains
+	s.mu.Unlock()
+}
+
+// convertLlamaPoolToYieldRate converts a DefiLlama pool to our internal YieldRate model
+func convertLlamaPoolToYieldRate(pool LlamaPool, protocolName string) models.YieldRate {
+	return models.YieldRate{
+		ProtocolName: protocolName,
+		Asset:        pool.Symbol,
+		Chain:        pool.Chain,
+		APY:          derefFloat(pool.APY),
+		BaseAPY:      derefFloat(pool.APYBase),
+		RewardAPY:    derefFloat(pool.APYReward),
+		TVL:          pool.TVLUsd,
+		PoolName:     pool.Pool,
+		ExternalURL:  "https://defillama.com/yields/pool/" + pool.Pool,
+	}
+}
+
+// derefFloat returns the value of a nullable JSON number, or 0 if null
+func derefFloat(v *float64) float64 {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+const mockLlamaPools = `{
+	"status": "success",
+	"data": [
+		{"pool": "aave-usdc-eth", "project": "aave-v3", "chain": "Ethereum", "symbol": "USDC", "tvlUsd": 500000000, "apy": 5.5, "apyBase": 4.5, "apyReward": 1.0},
+		{"pool": "morpho-weth-base", "project": "morpho-blue", "chain": "Base", "symbol": "WETH", "tvlUsd": 25000000, "apy": 3.0, "apyBase": 3.0, "apyReward": null},
+		{"pool": "uni-pool", "project": "uniswap-v3", "chain": "Ethereum", "symbol": "USDC-WETH", "tvlUsd": 100000000, "apy": 20.0},
+		{"pool": "aave-null-apy", "project": "aave-v3", "chain": "Arbitrum", "symbol": "DAI", "tvlUsd": 1000, "apy": null}
+	]
+}`
+
+// TestParseLlamaProjects tests allow-list parsing and project name mapping
+func TestParseLlamaProjects(t *testing.T) {
+	projects := mustParseLlamaProjects(t, " aave-v3, morpho-blue=Morpho Blue,,euler-v3 ")
+
+	tests := []struct {
+		slug     string
+		wantName string
+	}{
+		{"aave-v3", "Aave V3"},
+		{"morpho-blue", "Morpho Blue"},
+		{"euler-v3", "Euler V3"},
+	}
+
+	if len(projects) != len(tests) {
+		t.Fatalf("ParseLlamaProjects() returned %d projects, want %d", len(projects), len(tests))
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.slug, func(t *testing.T) {
+			if got := projects[tt.slug].Name; got != tt.wantName {
+				t.Errorf("project %s name = %s, want %s", tt.slug, got, tt.wantName)
+			}
+		})
+	}
+}
+
+// mustParseLlamaProjects parses an allow-list without reserved protocols
+func mustParseLlamaProjects(t *testing.T, spec string) map[string]models.Protocol {
+	t.Helper()
+	projects, err := ParseLlamaProjects(spec)
+	if err != nil {
+		t.Fatalf("ParseLlamaProjects(%q) error = %v", spec, err)
+	}
+	return projects
+}
+
+// TestParseLlamaProjects_Reserved tests that projects can't take the names of
+// protocols other sources own
+func TestParseLlamaProjects_Reserved(t *testing.T) {
+	reserved := []models.Protocol{models.ManualProtocol, PendleProtocol, CurveProtocol, ConvexProtocol}
+
+	tests := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{"aave-v3,morpho-blue=Morpho Blue", false},
+		{"pendle", true},
+		{"curve-dex=Curve", true},
+		{"aave-v3,convex-finance=convex", true},
+		{"my-rates=Manual", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			_, err := ParseLlamaProjects(tt.spec, reserved...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseLlamaProjects(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestLlamaSource_FetchRatesFromFile tests ingestion from a local snapshot
+func TestLlamaSource_FetchRatesFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pools.json")
+	if err := os.WriteFile(path, []byte(mockLlamaPools), 0o644); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+
+	source := NewLlamaSource(path, mustParseLlamaProjects(t, "aave-v3,morpho-blue"))
+
+	rates, err := source.FetchRates(&models.FetchRun{})
+	if err != nil {
+		t.Fatalf("FetchRates() error = %v", err)
+	}
+
+	// uniswap-v3 is not allow-listed and the null APY pool is skipped
+	if len(rates) != 2 {
+		t.Fatalf("FetchRates() returned %d rates, want 2", len(rates))
+	}
+
+	aave := rates[0]
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"ProtocolName", aave.ProtocolName, "Aave V3"},
+		{"Asset", aave.Asset, "USDC"},
+		{"Chain", aave.Chain, "Ethereum"},
+		{"APY", aave.APY, 5.5},
+		{"BaseAPY", aave.BaseAPY, 4.5},
+		{"RewardAPY", aave.RewardAPY, 1.0},
+		{"TVL", aave.TVL, 500000000.0},
+		{"PoolName", aave.PoolName, "aave-usdc-eth"},
+		{"Null reward APY", rates[1].RewardAPY, 0.0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+			}
+		})
+	}
+}
+
+// TestLlamaSource_FetchRatesFromURL tests ingestion over HTTP
+func TestLlamaSource_FetchRatesFromURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mockLlamaPools))
+	}))
+	defer server.Close()
+
+	source := NewLlamaSource(server.URL+"/pools", mustParseLlamaProjects(t, "uniswap-v3"))
+
+	rates, err := source.FetchRates(&models.FetchRun{})
+	if err != nil {
+		t.Fatalf("FetchRates() error = %v", err)
+	}
+
+	if len(rates) != 1 || rates[0].ProtocolName != "Uniswap V3" {
+		t.Errorf("FetchRates() = %+v, want a single Uniswap V3 rate", rates)
+	}
+}
+
+// TestLlamaSource_MissingFile tests that a missing snapshot is reported
+func TestLlamaSource_MissingFile(t *testing.T) {
+	source := NewLlamaSource(filepath.Join(t.TempDir(), "missing.json"), mustParseLlamaProjects(t, "aave-v3"))
+
+	if _, err := source.FetchRates(&models.FetchRun{}); err == nil {
+		t.Error("FetchRates() should return an error for a missing snapshot")
+	}
+}
+
+// TestLlamaSource_FetchChainRates tests that a chain-scoped fetch keeps only that chain
+func TestLlamaSource_FetchChainRates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pools.json")
+	if err := os.WriteFile(path, []byte(mockLlamaPools), 0o644); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+
+	source := NewLlamaSource(path, mustParseLlamaProjects(t, "aave-v3,morpho-blue"))
+
+	run := &models.FetchRun{}
+	rates, err := source.FetchChainRates(run, "base")
+	if err != nil {
+		t.Fatalf("FetchChainRates() error = %v", err)
+	}
+
+	if len(rates) != 1 || rates[0].PoolName != "morpho-weth-base" {
+		t.Errorf("FetchChainRates() = %+v, want only the Base pool", rates)
+	}
+	if run.Received != 1 || len(run.ChainsAttempted) != 1 {
+		t.Errorf("run = %+v, want one received pool on one chain", run)
+	}
+}
+
+// TestLlamaSource_HasChain tests that chains are matched against the pools
+// document, case-insensitively
+func TestLlamaSource_HasChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pools.json")
+	if err := os.WriteFile(path, []byte(mockLlamaPools), 0o644); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+
+	source := NewLlamaSource(path, mustParseLlamaProjects(t, "aave-v3"))
+	for chain, want := range map[string]bool{"Ethereum": true, "base": true, "ARBITRUM": true, "Solana": false} {
+		if got := source.HasChain(chain); got != want {
+			t.Errorf("HasChain(%q) = %v, want %v", chain, got, want)
+		}
+	}
+
+	missing := NewLlamaSource(filepath.Join(t.TempDir(), "missing.json"), mustParseLlamaProjects(t, "aave-v3"))
+	if missing.HasChain("Ethereum") {
+		t.Error("HasChain() = true for an unreadable document")
+	}
+}
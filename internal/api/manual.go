@@ -0,0 +1,140 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// ManualFileSource re-reads a JSON or CSV file of hand-maintained rates every
+// fetch cycle and files them under the Manual protocol
+type ManualFileSource struct {
+	path string
+}
+
+// NewManualFileSource creates a source for a .json or .csv manual entries file
+func NewManualFileSource(path string) *ManualFileSource {
+	return &ManualFileSource{path: path}
+}
+
+// Name implements Source
+func (s *ManualFileSource) Name() string {
+	return models.SourceManualFile
+}
+
+// Protocols implements Source
+func (s *ManualFileSource) Protocols() []models.Protocol {
+	return []models.Protocol{models.ManualProtocol}
+}
+
+// FetchRates implements Source
+func (s *ManualFileSource) FetchRates(run *models.FetchRun) ([]models.YieldRate, error) {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manual entries file: %w", err)
+	}
+	defer file.Close()
+
+	var entries []models.ManualEntry
+	switch strings.ToLower(filepath.Ext(s.path)) {
+	case ".json":
+		entries, err = parseManualJSON(file)
+	case ".csv":
+		entries, err = parseManualCSV(file)
+	default:
+		return nil, fmt.Errorf("unsupported manual entries file type %q (want .json or .csv)", filepath.Ext(s.path))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	run.Received = len(entries)
+
+	rates := make([]models.YieldRate, 0, len(entries))
+	for i, entry := range entries {
+		rate, err := entry.YieldRate()
+		if err != nil {
+			return nil, fmt.Errorf("manual entry %d: %w", i+1, err)
+		}
+		rates = append(rates, rate)
+	}
+	run.Active = len(rates)
+
+	return rates, nil
+}
+
+// parseManualJSON parses a JSON array of manual entries
+func parseManualJSON(r io.Reader) ([]models.ManualEntry, error) {
+	var entries []models.ManualEntry
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal manual entries: %w", err)
+	}
+	return entries, nil
+}
+
+// parseManualCSV parses manual entries from a CSV file whose header row uses
+// the same column names as the JSON format
+func parseManualCSV(r io.Reader) ([]models.ManualEntry, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manual entries CSV: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	header := make(map[string]int)
+	for i, column := range records[0] {
+		header[strings.ToLower(strings.TrimSpace(column))] = i
+	}
+
+	field := func(record []string, column string) string {
+		if i, ok := header[column]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var entries []models.ManualEntry
+	for line, record := range records[1:] {
+		entry := models.ManualEntry{
+			Asset:        field(record, "asset"),
+			Chain:        field(record, "chain"),
+			MaturityDate: field(record, "maturity_date"),
+			PoolName:     field(record, "pool_name"),
+			ExternalURL:  field(record, "external_url"),
+		}
+
+		numbers := []struct {
+			column string
+			dest   *float64
+		}{
+			{"apy", &entry.APY},
+			{"base_apy", &entry.BaseAPY},
+			{"reward_apy", &entry.RewardAPY},
+			{"tvl", &entry.TVL},
+		}
+		for _, n := range numbers {
+			value := field(record, n.column)
+			if value == "" {
+				continue
+			}
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid %s %q", line+2, n.column, value)
+			}
+			*n.dest = parsed
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// writeManualFile writes a manual entries file into a temp dir
+func writeManualFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write manual entries file: %v", err)
+	}
+	return path
+}
+
+// TestManualFileSource_JSON tests reading manual entries from JSON
+func TestManualFileSource_JSON(t *testing.T) {
+	path := writeManualFile(t, "manual.json", `[
+		{"asset": "USDC", "chain": "Off-chain", "apy": 9.5, "tvl": 2000000, "maturity_date": "2026-03-31", "pool_name": "OTC-USDC-Q1"},
+		{"asset": "BTC", "chain": "CeFi", "apy": 3.0}
+	]`)
+
+	rates, err := NewManualFileSource(path).FetchRates(&models.FetchRun{})
+	if err != nil {
+		t.Fatalf("FetchRates() error = %v", err)
+	}
+
+	if len(rates) != 2 {
+		t.Fatalf("FetchRates() returned %d rates, want 2", len(rates))
+	}
+
+	if rates[0].ProtocolName != "Manual" || rates[0].PoolName != "OTC-USDC-Q1" {
+		t.Errorf("rates[0] = %+v, want Manual OTC-USDC-Q1", rates[0])
+	}
+
+	expected := time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC)
+	if rates[0].MaturityDate == nil || !rates[0].MaturityDate.Equal(expected) {
+		t.Errorf("MaturityDate = %v, want %v", rates[0].MaturityDate, expected)
+	}
+
+	// Pool name defaults to the asset
+	if rates[1].PoolName != "BTC" || rates[1].MaturityDate != nil {
+		t.Errorf("rates[1] = %+v, want pool BTC with no maturity", rates[1])
+	}
+}
+
+// TestManualFileSource_CSV tests reading manual entries from CSV
+func TestManualFileSource_CSV(t *testing.T) {
+	path := writeManualFile(t, "manual.csv", "asset,chain,apy,tvl,maturity_date,pool_name\n"+
+		"USDT,Off-chain,8.75,500000,2026-06-30,OTC-USDT\n"+
+		"ETH,CeFi,4,,,\n")
+
+	rates, err := NewManualFileSource(path).FetchRates(&models.FetchRun{})
+	if err != nil {
+		t.Fatalf("FetchRates() error = %v", err)
+	}
+
+	if len(rates) != 2 {
+		t.Fatalf("FetchRates() returned %d rates, want 2", len(rates))
+	}
+
+	if rates[0].APY != 8.75 || rates[0].TVL != 500000 || rates[0].PoolName != "OTC-USDT" {
+		t.Errorf("rates[0] = %+v, want OTC-USDT at 8.75%% with $500K", rates[0])
+	}
+	if rates[1].APY != 4 || rates[1].PoolName != "ETH" {
+		t.Errorf("rates[1] = %+v, want ETH at 4%%", rates[1])
+	}
+}
+
+// TestManualFileSource_Errors tests that malformed files are rejected as a whole
+func TestManualFileSource_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{"missing chain", "manual.json", `[{"asset": "USDC", "apy": 5}]`},
+		{"bad maturity", "manual.json", `[{"asset": "USDC", "chain": "CeFi", "maturity_date": "next year"}]`},
+		{"bad number", "manual.csv", "asset,chain,apy\nUSDC,CeFi,lots\n"},
+		{"unsupported type", "manual.yaml", "asset: USDC"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeManualFile(t, tt.file, tt.content)
+			if _, err := NewManualFileSource(path).FetchRates(&models.FetchRun{}); err == nil {
+				t.Error("FetchRates() should return an error")
+			}
+		})
+	}
+}
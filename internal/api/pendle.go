@@ -5,7 +5,11 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
 )
 
 const (
@@ -71,23 +75,31 @@ var ChainIDToName = map[int]string{
 	80094: "Berachain",
 }
 
+// PendleChainIDs lists the chains Pendle markets are fetched from (as of API response)
+var PendleChainIDs = []int{1, 10, 56, 146, 999, 5000, 8453, 9745, 42161, 80094}
+
 // GetMarkets fetches all active markets from Pendle across all supported chains
 func (c *PendleClient) GetMarkets() ([]Market, error) {
-	var allMarkets []Market
+	return c.getMarkets(&models.FetchRun{Source: c.Name()}, PendleChainIDs)
+}
 
-	// Fetch markets from each supported chain (as of API response)
-	// Supported chains: 1, 10, 56, 146, 999, 5000, 8453, 9745, 42161, 80094
-	chainIDs := []int{1, 10, 56, 146, 999, 5000, 8453, 9745, 42161, 80094}
+// getMarkets fetches markets from the given chains, recording the chains
+// attempted and per-chain failures on run
+func (c *PendleClient) getMarkets(run *models.FetchRun, chainIDs []int) ([]Market, error) {
+	var allMarkets []Market
 
 	for _, chainID := range chainIDs {
+		run.ChainsAttempted = append(run.ChainsAttempted, GetChainName(chainID))
 		markets, err := c.GetMarketsForChain(chainID)
 		if err != nil {
 			// Log error but continue with other chains
-			fmt.Printf("Warning: failed to fetch markets for chain %d: %v\n", chainID, err)
+			runLogger(run).Warn("failed to fetch markets", "chain", GetChainName(chainID), "chain_id", chainID, "error", err)
+			run.AddError("chain %d: %v", chainID, err)
 			continue
 		}
 		allMarkets = append(allMarkets, markets...)
 	}
+	run.Received = len(allMarkets)
 
 	if len(allMarkets) == 0 {
 		return nil, fmt.Errorf("no markets fetched from any chain")
@@ -140,19 +152,26 @@ func (c *PendleClient) GetMarketsForChain(chainID int) ([]Market, error) {
 
 // GetActiveMarkets fetches only active (non-expired) markets
 func (c *PendleClient) GetActiveMarkets() ([]Market, error) {
-	allMarkets, err := c.GetMarkets()
+	return c.getActiveMarkets(&models.FetchRun{Source: c.Name()}, PendleChainIDs)
+}
+
+// getActiveMarkets fetches only active markets on the given chains, recording
+// how many were received, active, expired and unparseable on run
+func (c *PendleClient) getActiveMarkets(run *models.FetchRun, chainIDs []int) ([]Market, error) {
+	allMarkets, err := c.getMarkets(run, chainIDs)
 	if err != nil {
 		return nil, err
 	}
 
-	fmt.Printf("DEBUG: GetActiveMarkets received %d markets total\n", len(allMarkets))
+	logger := runLogger(run)
+	logger.Debug("filtering active markets", "received", len(allMarkets))
 
 	now := time.Now()
 	var activeMarkets []Market
 	skippedCount := 0
 	expiredCount := 0
 
-	for i, market := range allMarkets {
+	for _, market := range allMarkets {
 		// Parse expiry date
 		expiry, err := time.Parse("2006-01-02T15:04:05.000Z", market.Expiry)
 		if err != nil {
@@ -161,7 +180,8 @@ func (c *PendleClient) GetActiveMarkets() ([]Market, error) {
 			if err != nil {
 				// Log the first few unparseable dates to debug
 				if skippedCount < 3 {
-					fmt.Printf("DEBUG: [%d] Skipping %s - unparseable expiry: '%s'\n", i, market.Name, market.Expiry)
+					logger.Debug("skipping market with unparseable expiry",
+						"market", market.Name, "chain", GetChainName(market.ChainID), "expiry", market.Expiry)
 				}
 				skippedCount++
 				// Skip markets with unparseable expiry
@@ -174,13 +194,18 @@ func (c *PendleClient) GetActiveMarkets() ([]Market, error) {
 			activeMarkets = append(activeMarkets, market)
 		} else {
 			if expiredCount < 3 {
-				fmt.Printf("DEBUG: [%d] Skipping %s - expired on %s\n", i, market.Name, expiry.Format("2006-01-02"))
+				logger.Debug("skipping expired market",
+					"market", market.Name, "chain", GetChainName(market.ChainID), "expiry", expiry.Format("2006-01-02"))
 			}
 			expiredCount++
 		}
 	}
 
-	fmt.Printf("DEBUG: Result - %d active, %d expired, %d unparseable\n", len(activeMarkets), expiredCount, skippedCount)
+	logger.Debug("filtered active markets", "active", len(activeMarkets), "expired", expiredCount, "unparseable", skippedCount)
+
+	run.Active = len(activeMarkets)
+	run.Expired = expiredCount
+	run.Unparseable = skippedCount
 
 	return activeMarkets, nil
 }
@@ -192,3 +217,120 @@ func GetChainName(chainID int) string {
 	}
 	return fmt.Sprintf("Chain-%d", chainID)
 }
+
+// PendleProtocol describes Pendle for the protocols table
+var PendleProtocol = models.Protocol{
+	Name:        "Pendle",
+	URL:         "https://www.pendle.finance",
+	Description: "Pendle is a protocol that enables the tokenization and trading of future yield",
+}
+
+// Name implements Source
+func (c *PendleClient) Name() string {
+	return PendleProtocol.Name
+}
+
+// Protocols implements Source
+func (c *PendleClient) Protocols() []models.Protocol {
+	return []models.Protocol{PendleProtocol}
+}
+
+// FetchRates implements Source by converting every active market to a yield rate
+func (c *PendleClient) FetchRates(run *models.FetchRun) ([]models.YieldRate, error) {
+	return c.fetchRates(run, PendleChainIDs)
+}
+
+// HasChain implements ChainSource
+func (c *PendleClient) HasChain(chain string) bool {
+	return len(pendleChainIDsFor(chain)) > 0
+}
+
+// FetchChainRates implements ChainSource
+func (c *PendleClient) FetchChainRates(run *models.FetchRun, chain string) ([]models.YieldRate, error) {
+	return c.fetchRates(run, pendleChainIDsFor(chain))
+}
+
+// pendleChainIDsFor returns the Pendle chain IDs named chain
+func pendleChainIDsFor(chain string) []int {
+	var chainIDs []int
+	for _, chainID := range PendleChainIDs {
+		if strings.EqualFold(GetChainName(chainID), chain) {
+			chainIDs = append(chainIDs, chainID)
+		}
+	}
+	return chainIDs
+}
+
+// fetchRates converts the active markets of the given chains to yield rates
+func (c *PendleClient) fetchRates(run *models.FetchRun, chainIDs []int) ([]models.YieldRate, error) {
+	markets, err := c.getActiveMarkets(run, chainIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	rates := make([]models.YieldRate, 0, len(markets))
+	for _, market := range markets {
+		rate := convertMarketToYieldRate(market, 0)
+		rate.ProtocolName = PendleProtocol.Name
+		rates = append(rates, rate)
+	}
+	return rates, nil
+}
+
+// convertMarketToYieldRate converts a Pendle market to our internal YieldRate model
+func convertMarketToYieldRate(market Market, protocolID int64) models.YieldRate {
+	// Parse expiry date
+	var maturityDate *time.Time
+	if expiry, err := time.Parse("2006-01-02T15:04:05.000Z", market.Expiry); err == nil {
+		maturityDate = &expiry
+	} else if expiry, err := time.Parse(time.RFC3339, market.Expiry); err == nil {
+		maturityDate = &expiry
+	}
+
+	// Use market name as asset (e.g., "wstETH", "sUSDe")
+	asset := market.Name
+
+	// Get chain name
+	chain := GetChainName(market.ChainID)
+
+	// Convert implied APY from decimal to percentage
+	apy := market.Details.ImpliedAPY * 100
+
+	// TVL is the liquidity in USD
+	tvl := market.Details.Liquidity
+
+	// Generate pool name and external URL
+	poolName := fmt.Sprintf("%s-%d", market.Name, market.ChainID)
+	externalURL := fmt.Sprintf("https://app.pendle.finance/trade/pools/%s/", market.Address)
+
+	return models.YieldRate{
+		ProtocolID:   protocolID,
+		Asset:        asset,
+		Chain:        chain,
+		APY:          apy,
+		TVL:          tvl,
+		MaturityDate: maturityDate,
+		PoolName:     poolName,
+		ExternalURL:  externalURL,
+		Categories:   market.CategoryIDs,
+		Pendle: &models.PendleMarket{
+			Address:         market.Address,
+			PT:              pendleTokenAddress(market.PT),
+			YT:              pendleTokenAddress(market.YT),
+			SY:              pendleTokenAddress(market.SY),
+			UnderlyingAsset: pendleTokenAddress(market.UnderlyingAsset),
+			FeeRate:         market.Details.FeeRate,
+		},
+	}
+}
+
+// pendleTokenAddress strips the chain ID Pendle prefixes token addresses
+// with, as in "1-0x7f39...", the chain being that of the rate
+func pendleTokenAddress(token string) string {
+	if chainID, address, ok := strings.Cut(token, "-"); ok {
+		if _, err := strconv.Atoi(chainID); err == nil {
+			return address
+		}
+	}
+	return token
+}
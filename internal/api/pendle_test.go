@@ -199,6 +199,50 @@ func TestMarket_JSONParsing(t *testing.T) {
 	}
 }
 
+// TestConvertMarketToYieldRate tests that the categories and token
+// addresses of a market are kept on its rate
+func TestConvertMarketToYieldRate(t *testing.T) {
+	market := Market{
+		Name:            "wstETH",
+		Address:         "0xc374f7ec85f8c7de3207a10bb1978ba104bda3b2",
+		Expiry:          "2025-12-25T00:00:00.000Z",
+		PT:              "1-0xf99985822fb361117fcf3768d34a6353e6022f5f",
+		YT:              "1-0xf3abc972a0f537c1119c990d422463b93227cd83",
+		SY:              "0xcbc72d92b2dc8187414f6734718563898740c0bc",
+		UnderlyingAsset: "1-0x7f39c581f595b53c5cb19bd0b3f8da6c935e2ca0",
+		Details:         MarketDetails{Liquidity: 1000, ImpliedAPY: 0.05, FeeRate: 0.001},
+		CategoryIDs:     []string{"eth", "points"},
+		ChainID:         1,
+	}
+
+	rate := convertMarketToYieldRate(market, 7)
+	if rate.Pendle == nil {
+		t.Fatal("rate has no Pendle metadata")
+	}
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"Address", rate.Pendle.Address, "0xc374f7ec85f8c7de3207a10bb1978ba104bda3b2"},
+		{"PT", rate.Pendle.PT, "0xf99985822fb361117fcf3768d34a6353e6022f5f"},
+		{"YT", rate.Pendle.YT, "0xf3abc972a0f537c1119c990d422463b93227cd83"},
+		{"SY without a chain prefix", rate.Pendle.SY, "0xcbc72d92b2dc8187414f6734718563898740c0bc"},
+		{"UnderlyingAsset", rate.Pendle.UnderlyingAsset, "0x7f39c581f595b53c5cb19bd0b3f8da6c935e2ca0"},
+		{"FeeRate", rate.Pendle.FeeRate, 0.001},
+		{"Categories", len(rate.Categories), 2},
+		{"Chain", rate.Chain, "Ethereum"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+			}
+		})
+	}
+}
+
 // TestGetActiveMarkets_ExpiryFiltering tests that expired markets are filtered out
 func TestGetActiveMarkets_ExpiryFiltering(t *testing.T) {
 	now := time.Now()
@@ -1,7 +1,7 @@
 package api
 
 import (
-	"log"
+	"log/slog"
 	"time"
 
 	"github.com/pretty-andrechal/defirates/internal/database"
@@ -9,8 +9,8 @@ import (
 )
 
 // LoadSampleData loads sample DeFi yield data into the database for demonstration
-func LoadSampleData(db *database.DB) error {
-	log.Println("Loading sample data...")
+func LoadSampleData(db database.Store) error {
+	slog.Info("loading sample data")
 
 	// Create Pendle protocol
 	protocol := &models.Protocol{
@@ -167,14 +167,30 @@ func LoadSampleData(db *database.DB) error {
 		},
 	}
 
+	// Pendle's categories of the sample assets
+	sampleCategories := map[string][]string{
+		"eETH":   {"eth", "points"},
+		"ezETH":  {"eth", "points"},
+		"rsETH":  {"eth", "points"},
+		"agETH":  {"eth", "points"},
+		"weETH":  {"eth", "points"},
+		"wstETH": {"eth"},
+		"mETH":   {"eth"},
+		"USDe":   {"stables", "points"},
+		"sUSDe":  {"stables", "points"},
+		"LBTC":   {"btc", "points"},
+		"cbBTC":  {"btc"},
+	}
+
 	for _, rate := range sampleRates {
+		rate.Categories = sampleCategories[rate.Asset]
 		if err := db.UpsertYieldRate(&rate); err != nil {
-			log.Printf("Failed to insert sample rate: %v", err)
+			slog.Warn("failed to insert sample rate", "pool", rate.PoolName, "error", err)
 			continue
 		}
 	}
 
-	log.Printf("Successfully loaded %d sample yield rates across multiple chains", len(sampleRates))
+	slog.Info("loaded sample yield rates", "count", len(sampleRates))
 	return nil
 }
 
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxBackoff caps how long a failing source waits between runs
+const DefaultMaxBackoff = time.Hour
+
+// Schedule sets how often a source is fetched
+type Schedule struct {
+	// Interval is the wait between the end of one run and the start of the next
+	Interval time.Duration
+	// Jitter adds a random delay of up to Jitter to every wait, so sources
+	// sharing an interval don't hit their APIs in lockstep
+	Jitter time.Duration
+	// MaxBackoff caps the wait after consecutive failures, which doubles
+	// the interval per failure (DefaultMaxBackoff when zero)
+	MaxBackoff time.Duration
+}
+
+// delay returns the wait before the next run of a source that failed its
+// last failures runs in a row
+func (s Schedule) delay(failures int, jitter func(time.Duration) time.Duration) time.Duration {
+	maxBackoff := s.MaxBackoff
+	if maxBackoff == 0 {
+		maxBackoff = DefaultMaxBackoff
+	}
+	maxBackoff = max(maxBackoff, s.Interval)
+
+	d := s.Interval
+	for i := 0; i < failures && d < maxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, maxBackoff)
+
+	if s.Jitter > 0 {
+		d += jitter(s.Jitter)
+	}
+	return d
+}
+
+// Scheduler fetches every source of a Fetcher on its own schedule. The next
+// wait of a source only starts once its current run is done, so a slow run
+// never overlaps the next one and ticks missed meanwhile collapse into a
+// single run. Runs that find the source busy, e.g. with a manual refresh,
+// are skipped
+type Scheduler struct {
+	fetcher   *Fetcher
+	defaults  Schedule
+	schedules map[string]Schedule
+
+	// Replaceable for tests
+	after  func(time.Duration) <-chan time.Time
+	jitter func(time.Duration) time.Duration
+}
+
+// NewScheduler creates a scheduler fetching every source with the default schedule
+func NewScheduler(fetcher *Fetcher, defaults Schedule) *Scheduler {
+	return &Scheduler{
+		fetcher:   fetcher,
+		defaults:  defaults,
+		schedules: make(map[string]Schedule),
+		after:     time.After,
+		jitter: func(max time.Duration) time.Duration {
+			return rand.N(max)
+		},
+	}
+}
+
+// SetSchedule overrides the schedule of the named source; zero fields keep
+// the default value
+func (s *Scheduler) SetSchedule(source string, schedule Schedule) {
+	s.schedules[strings.ToLower(source)] = schedule
+}
+
+// ScheduleFor returns the schedule of the named source
+func (s *Scheduler) ScheduleFor(source string) Schedule {
+	schedule := s.defaults
+	if override, ok := s.schedules[strings.ToLower(source)]; ok {
+		if override.Interval > 0 {
+			schedule.Interval = override.Interval
+		}
+		if override.Jitter > 0 {
+			schedule.Jitter = override.Jitter
+		}
+		if override.MaxBackoff > 0 {
+			schedule.MaxBackoff = override.MaxBackoff
+		}
+	}
+	return schedule
+}
+
+// Start runs every source in the background until ctx is done
+func (s *Scheduler) Start(ctx context.Context) {
+	go s.Run(ctx)
+}
+
+// Run fetches every source right away and then on its schedule, returning
+// once ctx is done and every run has finished
+func (s *Scheduler) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, source := range s.fetcher.Sources() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.loop(ctx, source)
+		}()
+	}
+	wg.Wait()
+}
+
+// loop fetches one source until ctx is done
+func (s *Scheduler) loop(ctx context.Context, source Source) {
+	schedule := s.ScheduleFor(source.Name())
+	logger := slog.With("source", source.Name())
+	logger.Info("fetch scheduled", "interval", schedule.Interval, "jitter", schedule.Jitter)
+
+	failures := 0
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		run, err := s.fetcher.FetchAndStore(source)
+		switch {
+		case errors.Is(err, ErrFetchInProgress):
+			// A manual refresh has the source; its run counts for this tick
+			logFetchError(source, run, err)
+		case err != nil:
+			failures++
+			logFetchError(source, run, err)
+		default:
+			failures = 0
+		}
+
+		wait := schedule.delay(failures, s.jitter)
+		if failures > 0 {
+			logger.Warn("backing off failing source", "failures", failures, "next_run_in", wait.Round(time.Second))
+		} else {
+			logger.Debug("next fetch scheduled", "next_run_in", wait.Round(time.Second))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.after(wait):
+		}
+	}
+}
+
+// ParseSourceIntervals parses per-source intervals given as
+// "Pendle=10m,DefiLlama=1h"; source names are matched case-insensitively
+func ParseSourceIntervals(spec string) (map[string]time.Duration, error) {
+	intervals := make(map[string]time.Duration)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid source interval %q (want Source=duration)", entry)
+		}
+		interval, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("invalid interval for source %s: %q", strings.TrimSpace(name), value)
+		}
+		intervals[strings.TrimSpace(name)] = interval
+	}
+	return intervals, nil
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// flakySource fails its first failures fetches
+type flakySource struct {
+	fakeSource
+	failures int
+	calls    int
+}
+
+func (s *flakySource) FetchRates(run *models.FetchRun) ([]models.YieldRate, error) {
+	s.calls++
+	if s.calls <= s.failures {
+		return nil, errors.New("API returned status 429")
+	}
+	return nil, nil
+}
+
+// fakeClock replaces Scheduler.after, recording each wait and cancelling
+// the scheduler after the given number of waits
+type fakeClock struct {
+	waits  []time.Duration
+	limit  int
+	cancel context.CancelFunc
+}
+
+func (c *fakeClock) after(d time.Duration) <-chan time.Time {
+	c.waits = append(c.waits, d)
+	if len(c.waits) >= c.limit {
+		c.cancel()
+		return make(chan time.Time)
+	}
+	ch := make(chan time.Time, 1)
+	ch <- time.Time{}
+	return ch
+}
+
+// newTestScheduler creates a scheduler driven by a fake clock and a fixed jitter
+func newTestScheduler(t *testing.T, schedule Schedule, waits int) (*Scheduler, *fakeClock, context.Context) {
+	t.Helper()
+
+	fetcher, _ := setupTestFetcher(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	clock := &fakeClock{limit: waits, cancel: cancel}
+	scheduler := NewScheduler(fetcher, schedule)
+	scheduler.after = clock.after
+	scheduler.jitter = func(max time.Duration) time.Duration { return max / 2 }
+	return scheduler, clock, ctx
+}
+
+// TestSchedule_Delay tests exponential backoff, its cap and jitter
+func TestSchedule_Delay(t *testing.T) {
+	noJitter := func(time.Duration) time.Duration { return 0 }
+
+	tests := []struct {
+		name     string
+		schedule Schedule
+		failures int
+		want     time.Duration
+	}{
+		{"healthy", Schedule{Interval: 5 * time.Minute}, 0, 5 * time.Minute},
+		{"one failure", Schedule{Interval: 5 * time.Minute}, 1, 10 * time.Minute},
+		{"three failures", Schedule{Interval: 5 * time.Minute}, 3, 40 * time.Minute},
+		{"capped", Schedule{Interval: 5 * time.Minute, MaxBackoff: 30 * time.Minute}, 3, 30 * time.Minute},
+		{"default cap", Schedule{Interval: 5 * time.Minute}, 50, DefaultMaxBackoff},
+		{"interval above cap", Schedule{Interval: 2 * time.Hour}, 2, 2 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.schedule.delay(tt.failures, noJitter); got != tt.want {
+				t.Errorf("delay(%d) = %v, want %v", tt.failures, got, tt.want)
+			}
+		})
+	}
+
+	schedule := Schedule{Interval: time.Minute, Jitter: 20 * time.Second}
+	halfJitter := func(max time.Duration) time.Duration { return max / 2 }
+	if got := schedule.delay(0, halfJitter); got != 70*time.Second {
+		t.Errorf("delay with jitter = %v, want 70s", got)
+	}
+}
+
+// TestScheduler_ScheduleFor tests per-source overrides of the default schedule
+func TestScheduler_ScheduleFor(t *testing.T) {
+	scheduler := NewScheduler(nil, Schedule{Interval: 5 * time.Minute, Jitter: 30 * time.Second})
+	scheduler.SetSchedule("DefiLlama", Schedule{Interval: time.Hour})
+
+	if got := scheduler.ScheduleFor("defillama"); got.Interval != time.Hour || got.Jitter != 30*time.Second {
+		t.Errorf("ScheduleFor(defillama) = %+v, want the hourly override with the default jitter", got)
+	}
+	if got := scheduler.ScheduleFor("Pendle"); got.Interval != 5*time.Minute {
+		t.Errorf("ScheduleFor(Pendle) = %+v, want the default schedule", got)
+	}
+}
+
+// TestScheduler_BacksOffFailingSource tests that failures stretch the wait
+// and a success resets it
+func TestScheduler_BacksOffFailingSource(t *testing.T) {
+	scheduler, clock, ctx := newTestScheduler(t, Schedule{Interval: 5 * time.Minute, Jitter: 10 * time.Second}, 3)
+
+	source := &flakySource{
+		fakeSource: fakeSource{name: "Flaky", protocols: []models.Protocol{{Name: "Flaky"}}},
+		failures:   2,
+	}
+	scheduler.loop(ctx, source)
+
+	want := []time.Duration{
+		10*time.Minute + 5*time.Second,
+		20*time.Minute + 5*time.Second,
+		5*time.Minute + 5*time.Second,
+	}
+	if source.calls != 3 || len(clock.waits) != len(want) {
+		t.Fatalf("got %d runs and waits %v, want 3 runs and waits %v", source.calls, clock.waits, want)
+	}
+	for i := range want {
+		if clock.waits[i] != want[i] {
+			t.Errorf("wait %d = %v, want %v", i, clock.waits[i], want[i])
+		}
+	}
+}
+
+// TestScheduler_SkipsBusySource tests that a tick finding the source busy
+// is skipped without counting as a failure
+func TestScheduler_SkipsBusySource(t *testing.T) {
+	scheduler, clock, ctx := newTestScheduler(t, Schedule{Interval: time.Minute}, 1)
+
+	source := &flakySource{fakeSource: fakeSource{name: "Busy", protocols: []models.Protocol{{Name: "Busy"}}}}
+	scheduler.fetcher.acquire(source)
+	scheduler.loop(ctx, source)
+
+	if source.calls != 0 {
+		t.Errorf("busy source was fetched %d times, want 0", source.calls)
+	}
+	if len(clock.waits) != 1 || clock.waits[0] != time.Minute {
+		t.Errorf("waits = %v, want a single regular interval", clock.waits)
+	}
+}
+
+// TestParseSourceIntervals tests per-source interval parsing
+func TestParseSourceIntervals(t *testing.T) {
+	intervals, err := ParseSourceIntervals(" Pendle=10m, DefiLlama=1h ,")
+	if err != nil {
+		t.Fatalf("ParseSourceIntervals() error = %v", err)
+	}
+	if len(intervals) != 2 || intervals["Pendle"] != 10*time.Minute || intervals["DefiLlama"] != time.Hour {
+		t.Errorf("ParseSourceIntervals() = %v", intervals)
+	}
+
+	for _, spec := range []string{"Pendle", "Pendle=soon", "Pendle=-1m"} {
+		if _, err := ParseSourceIntervals(spec); err == nil {
+			t.Errorf("ParseSourceIntervals(%q) should fail", spec)
+		}
+	}
+}
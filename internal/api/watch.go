@@ -0,0 +1,43 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/database"
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// WatchStore polls store every interval and publishes an update whenever its
+// rates changed. It stands in for the Fetcher on web replicas that share a
+// database but don't fetch, so their caches and live clients still hear of
+// the rates another replica stored. It returns when ctx is done
+func WatchStore(ctx context.Context, store database.Store, interval time.Duration, publisher Publisher) {
+	version, err := store.RatesVersion()
+	if err != nil {
+		slog.Warn("failed to read rates version", "error", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		current, err := store.RatesVersion()
+		if err != nil {
+			slog.Warn("failed to read rates version", "error", err)
+			continue
+		}
+		if current == version {
+			continue
+		}
+		version = current
+		slog.Debug("rates changed in the shared database")
+		publisher.Publish(models.RatesUpdate{At: time.Now(), Changes: make([]models.RateChange, 0)})
+	}
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// channelPublisher hands published updates to a test goroutine
+type channelPublisher chan models.RatesUpdate
+
+func (p channelPublisher) Publish(update models.RatesUpdate) {
+	p <- update
+}
+
+// TestWatchStore tests that rates stored by another process are published
+func TestWatchStore(t *testing.T) {
+	_, db := setupTestFetcher(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := make(channelPublisher, 1)
+	done := make(chan struct{})
+	go func() {
+		WatchStore(ctx, db, 10*time.Millisecond, updates)
+		close(done)
+	}()
+
+	select {
+	case update := <-updates:
+		t.Fatalf("published %+v before any rate changed", update)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	protocol := &models.Protocol{Name: "Pendle"}
+	db.CreateOrUpdateProtocol(protocol)
+	db.UpsertYieldRate(&models.YieldRate{ProtocolID: protocol.ID, Asset: "ETH", Chain: "Ethereum", APY: 3, PoolName: "Pool"})
+
+	select {
+	case update := <-updates:
+		if update.Changes == nil {
+			t.Error("published nil changes, want an empty list")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no update published after a rate was stored")
+	}
+
+	cancel()
+	<-done
+}
@@ -0,0 +1,220 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrBackupUnsupported is returned by the backup methods on PostgreSQL,
+// which is backed up with its own tools such as pg_dump
+var ErrBackupUnsupported = errors.New("backups are only supported on SQLite, use pg_dump for PostgreSQL")
+
+// backupLayout names the backups StartBackups takes, so they sort by time
+const backupLayout = "defirates-20060102-150405.db"
+
+// Backup writes a consistent copy of the database to path with SQLite's
+// online backup API. It reads through the read pool, so fetches and page
+// loads go on meanwhile. The copy is checked before it is renamed to path, so
+// path never holds a partial backup
+func (db *DB) Backup(ctx context.Context, path string) error {
+	if db.dialect != dialectSQLite {
+		return ErrBackupUnsupported
+	}
+
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	if err := db.backupTo(ctx, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := checkBackup(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// backupTo copies the database into a new file at path, in rollback journal
+// mode so the backup is a single file
+func (db *DB) backupTo(ctx context.Context, path string) error {
+	dest, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+	dest.SetMaxOpenConns(1)
+
+	if err := copySQLite(ctx, dest, db.read.DB); err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	_, err = dest.ExecContext(ctx, `PRAGMA journal_mode=DELETE`)
+	return err
+}
+
+// Restore replaces the contents of the database with the backup at path,
+// after checking it. The copy is taken under the database's own locks, but
+// a running server would keep serving what it has cached, so it should be
+// stopped first. Backups of older versions are migrated when the database is
+// next opened
+func (db *DB) Restore(ctx context.Context, path string) error {
+	if db.dialect != dialectSQLite {
+		return ErrBackupUnsupported
+	}
+	if err := checkBackup(path); err != nil {
+		return err
+	}
+
+	src, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err := copySQLite(ctx, db.conn.DB, src); err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+	return nil
+}
+
+// copySQLite copies the main database of src over that of dest in one step
+func copySQLite(ctx context.Context, dest, src *sql.DB) error {
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return destConn.Raw(func(destDriver interface{}) error {
+		return srcConn.Raw(func(srcDriver interface{}) error {
+			destSQLite, ok := destDriver.(*sqlite3.SQLiteConn)
+			srcSQLite, ok2 := srcDriver.(*sqlite3.SQLiteConn)
+			if !ok || !ok2 {
+				return fmt.Errorf("not a SQLite connection")
+			}
+
+			backup, err := destSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return err
+			}
+			if _, err := backup.Step(-1); err != nil {
+				backup.Finish()
+				return err
+			}
+			return backup.Finish()
+		})
+	})
+}
+
+// checkBackup checks that path holds an intact defirates database
+func checkBackup(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	conn, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var result string
+	if err := conn.QueryRow(`PRAGMA quick_check`).Scan(&result); err != nil {
+		return fmt.Errorf("check %s: %w", path, err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("%s is corrupt: %s", path, result)
+	}
+	var tables int
+	err = conn.QueryRow(
+		`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN ('protocols', 'yield_rates')`,
+	).Scan(&tables)
+	if err != nil {
+		return fmt.Errorf("check %s: %w", path, err)
+	}
+	if tables != 2 {
+		return fmt.Errorf("%s is not a defirates database", path)
+	}
+	return nil
+}
+
+// StartBackups backs the database up into dir every interval until ctx is
+// done, keeping the newest keep backups (0 keeps them all)
+func (db *DB) StartBackups(ctx context.Context, dir string, interval time.Duration, keep int) error {
+	if db.dialect != dialectSQLite {
+		return ErrBackupUnsupported
+	}
+	if interval <= 0 {
+		return fmt.Errorf("backup interval must be positive")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				path, err := db.backupToDir(ctx, dir, keep, now)
+				if err != nil {
+					slog.Warn("scheduled backup failed", "error", err)
+					continue
+				}
+				slog.Info("database backed up", "path", path)
+			}
+		}
+	}()
+	return nil
+}
+
+// backupToDir writes a backup named after now into dir, then deletes all
+// but the newest keep backups there
+func (db *DB) backupToDir(ctx context.Context, dir string, keep int, now time.Time) (string, error) {
+	path := filepath.Join(dir, now.UTC().Format(backupLayout))
+	if err := db.Backup(ctx, path); err != nil {
+		return "", err
+	}
+	return path, rotateBackups(dir, keep)
+}
+
+// rotateBackups deletes all but the newest keep backups in dir. Other files
+// are left alone
+func rotateBackups(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	// ReadDir sorts by name, which for backups is by time
+	var backups []string
+	for _, entry := range entries {
+		if _, err := time.Parse(backupLayout, entry.Name()); err == nil && entry.Type().IsRegular() {
+			backups = append(backups, entry.Name())
+		}
+	}
+	for len(backups) > keep {
+		if err := os.Remove(filepath.Join(dir, backups[0])); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
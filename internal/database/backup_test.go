@@ -0,0 +1,91 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// TestBackupRestore tests backing up a database in use and restoring it
+func TestBackupRestore(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	protocol := &models.Protocol{Name: "Pendle"}
+	db.CreateOrUpdateProtocol(protocol)
+	db.UpsertYieldRate(&models.YieldRate{ProtocolID: protocol.ID, Asset: "ETH", Chain: "Ethereum", APY: 3, PoolName: "Pool-1"})
+
+	// A write transaction in progress doesn't hold the backup up or end up in it
+	tx, err := db.conn.Begin()
+	if err != nil {
+		t.Fatalf("Begin() failed: %v", err)
+	}
+	tx.Exec(`UPDATE yield_rates SET apy = 99`)
+
+	out := filepath.Join(t.TempDir(), "backup.db")
+	if err := db.Backup(ctx, out); err != nil {
+		t.Fatalf("Backup() failed: %v", err)
+	}
+	tx.Rollback()
+	if _, err := os.Stat(out + "-wal"); !os.IsNotExist(err) {
+		t.Error("the backup should be a single file")
+	}
+
+	// Change the database, then restore the backup over it
+	db.UpsertYieldRate(&models.YieldRate{ProtocolID: protocol.ID, Asset: "DAI", Chain: "Ethereum", APY: 5, PoolName: "Pool-2"})
+	if err := db.Restore(ctx, out); err != nil {
+		t.Fatalf("Restore() failed: %v", err)
+	}
+	rates, _ := db.GetYieldRates(models.FilterParams{})
+	if len(rates) != 1 || rates[0].APY != 3 {
+		t.Errorf("rates after restore = %+v, want Pool-1 at 3%%", rates)
+	}
+
+	// Files that aren't defirates databases are refused
+	notes := filepath.Join(t.TempDir(), "notes.txt")
+	os.WriteFile(notes, []byte("not a database"), 0o644)
+	for _, path := range []string{notes, filepath.Join(t.TempDir(), "missing.db")} {
+		if err := db.Restore(ctx, path); err == nil {
+			t.Errorf("Restore(%s) should fail", filepath.Base(path))
+		}
+	}
+	if rates, _ := db.GetYieldRates(models.FilterParams{}); len(rates) != 1 {
+		t.Errorf("a failed restore left %d rates, want 1", len(rates))
+	}
+}
+
+// TestBackupRotation tests that scheduled backups keep the newest ones
+func TestBackupRotation(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "README"), nil, 0o644)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		if _, err := db.backupToDir(context.Background(), dir, 3, start.Add(time.Duration(i)*time.Hour)); err != nil {
+			t.Fatalf("backupToDir() failed: %v", err)
+		}
+	}
+
+	entries, _ := os.ReadDir(dir)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	want := []string{"README", "defirates-20260101-020000.db", "defirates-20260101-030000.db", "defirates-20260101-040000.db"}
+	if len(names) != len(want) {
+		t.Fatalf("backup dir holds %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("backup dir holds %v, want %v", names, want)
+			break
+		}
+	}
+}
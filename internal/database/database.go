@@ -3,39 +3,71 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"log/slog"
 	"strings"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/pretty-andrechal/defirates/internal/models"
 )
 
+// DB is the SQLite or PostgreSQL database behind Store. Writes go through
+// conn; plain reads use the read pool, which for SQLite is separate so reads
+// never queue behind a fetch run
 type DB struct {
-	conn *sql.DB
+	conn     *pool
+	read     *pool
+	dialect  dialect
+	options  Options
+	existing bool // Opened by connectExisting, so Close leaves the database as is
 }
 
-// New creates a new database connection
+// New opens the SQLite database at dbPath with DefaultOptions
 func New(dbPath string) (*DB, error) {
-	conn, err := sql.Open("sqlite3", dbPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
-	}
+	return Open(dbPath, DefaultOptions())
+}
 
-	if err := conn.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to ping database: %w", err)
+// Open opens the database at dsn, creating and migrating it as needed. A
+// postgres:// or postgresql:// URL opens PostgreSQL, anything else is the path
+// of a SQLite database file
+func Open(dsn string, options Options) (*DB, error) {
+	db, err := connect(dsn, options)
+	if err != nil {
+		return nil, err
 	}
 
-	db := &DB{conn: conn}
 	if err := db.migrate(); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
+	if options.ForeignKeys && db.dialect == dialectSQLite {
+		if err := db.checkForeignKeys(); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to check foreign keys: %w", err)
+		}
+	}
 
 	return db, nil
 }
 
-// migrate creates the database schema
-func (db *DB) migrate() error {
-	schema := `
+// connect opens the database at dsn without migrating it
+func connect(dsn string, options Options) (*DB, error) {
+	if err := options.validate(); err != nil {
+		return nil, fmt.Errorf("invalid database options: %w", err)
+	}
+	if IsPostgresDSN(dsn) {
+		return openPostgres(dsn, options)
+	}
+	return openSQLite(dsn, options)
+}
+
+// Backend returns "sqlite" or "postgres"
+func (db *DB) Backend() string {
+	return db.dialect.String()
+}
+
+// sqliteSchema is the SQLite schema as first created. Later changes to
+// existing tables are the other migrations in migrations.go
+const sqliteSchema = `
 	CREATE TABLE IF NOT EXISTS protocols (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		name TEXT NOT NULL UNIQUE,
@@ -63,15 +95,158 @@ func (db *DB) migrate() error {
 	CREATE INDEX IF NOT EXISTS idx_yield_rates_apy ON yield_rates(apy);
 	CREATE INDEX IF NOT EXISTS idx_yield_rates_asset ON yield_rates(asset);
 	CREATE INDEX IF NOT EXISTS idx_yield_rates_chain ON yield_rates(chain);
-	`
 
-	_, err := db.conn.Exec(schema)
-	return err
-}
+	CREATE TABLE IF NOT EXISTS yield_rewards (
+		yield_rate_id INTEGER NOT NULL,
+		token TEXT NOT NULL,
+		apy REAL NOT NULL,
+		PRIMARY KEY (yield_rate_id, token),
+		FOREIGN KEY (yield_rate_id) REFERENCES yield_rates(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS yield_categories (
+		yield_rate_id INTEGER NOT NULL,
+		category TEXT NOT NULL,
+		PRIMARY KEY (yield_rate_id, category),
+		FOREIGN KEY (yield_rate_id) REFERENCES yield_rates(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_yield_categories_category ON yield_categories(category);
+
+	CREATE TABLE IF NOT EXISTS pendle_markets (
+		yield_rate_id INTEGER PRIMARY KEY,
+		address TEXT NOT NULL,
+		pt TEXT NOT NULL,
+		yt TEXT NOT NULL,
+		sy TEXT NOT NULL,
+		underlying_asset TEXT NOT NULL,
+		FOREIGN KEY (yield_rate_id) REFERENCES yield_rates(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS fetch_runs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		source TEXT NOT NULL,
+		status TEXT NOT NULL,
+		started_at DATETIME NOT NULL,
+		finished_at DATETIME,
+		chains_attempted TEXT NOT NULL DEFAULT '[]',
+		markets_received INTEGER NOT NULL DEFAULT 0,
+		markets_active INTEGER NOT NULL DEFAULT 0,
+		markets_expired INTEGER NOT NULL DEFAULT 0,
+		markets_unparseable INTEGER NOT NULL DEFAULT 0,
+		rows_upserted INTEGER NOT NULL DEFAULT 0,
+		rows_failed INTEGER NOT NULL DEFAULT 0,
+		errors TEXT NOT NULL DEFAULT '[]'
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_fetch_runs_started ON fetch_runs(started_at);
+
+	CREATE TABLE IF NOT EXISTS yield_history (
+		yield_rate_id INTEGER NOT NULL,
+		observed_at INTEGER NOT NULL,
+		apy REAL NOT NULL,
+		tvl REAL NOT NULL,
+		FOREIGN KEY (yield_rate_id) REFERENCES yield_rates(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_yield_history_rate_time ON yield_history(yield_rate_id, observed_at);
+
+	CREATE TABLE IF NOT EXISTS yield_history_rollups (
+		yield_rate_id INTEGER NOT NULL,
+		resolution INTEGER NOT NULL,
+		bucket INTEGER NOT NULL,
+		open_apy REAL NOT NULL,
+		high_apy REAL NOT NULL,
+		low_apy REAL NOT NULL,
+		close_apy REAL NOT NULL,
+		avg_tvl REAL NOT NULL,
+		samples INTEGER NOT NULL,
+		PRIMARY KEY (yield_rate_id, resolution, bucket),
+		FOREIGN KEY (yield_rate_id) REFERENCES yield_rates(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS profiles (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		token TEXT NOT NULL UNIQUE,
+		user_id INTEGER,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS saved_filters (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		profile_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		slug TEXT NOT NULL UNIQUE,
+		query TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (profile_id, name),
+		FOREIGN KEY (profile_id) REFERENCES profiles(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT NOT NULL UNIQUE COLLATE NOCASE,
+		password_hash TEXT NOT NULL,
+		scopes TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS sessions (
+		token_hash TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS admin_sessions (
+		token_hash TEXT PRIMARY KEY,
+		admin_token_hash TEXT NOT NULL,
+		expires_at INTEGER NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
 
-// Close closes the database connection
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		prefix TEXT NOT NULL,
+		key_hash TEXT NOT NULL UNIQUE,
+		scopes TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_used_at DATETIME,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_profiles_user ON profiles(user_id);
+
+	CREATE TABLE IF NOT EXISTS watchlist (
+		profile_id INTEGER NOT NULL,
+		yield_rate_id INTEGER NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (profile_id, yield_rate_id),
+		FOREIGN KEY (profile_id) REFERENCES profiles(id) ON DELETE CASCADE,
+		FOREIGN KEY (yield_rate_id) REFERENCES yield_rates(id) ON DELETE CASCADE
+	);
+`
+
+// Close closes the database connections. SQLite gets a last PRAGMA optimize
+// first, as it recommends for long-lived connections
 func (db *DB) Close() error {
-	return db.conn.Close()
+	if db.dialect == dialectPostgres {
+		return db.conn.Close()
+	}
+
+	if !db.existing {
+		if _, err := db.conn.Exec(`PRAGMA optimize`); err != nil {
+			slog.Warn("failed to optimize database", "error", err)
+		}
+	}
+	readErr := db.read.Close()
+	if err := db.conn.Close(); err != nil {
+		return err
+	}
+	return readErr
 }
 
 // CreateOrUpdateProtocol creates or updates a protocol
@@ -98,7 +273,7 @@ func (db *DB) GetProtocolByName(name string) (*models.Protocol, error) {
 	protocol := &models.Protocol{}
 	query := `SELECT id, name, url, description, created_at FROM protocols WHERE name = ?`
 
-	err := db.conn.QueryRow(query, name).Scan(
+	err := db.read.QueryRow(query, name).Scan(
 		&protocol.ID,
 		&protocol.Name,
 		&protocol.URL,
@@ -112,74 +287,191 @@ func (db *DB) GetProtocolByName(name string) (*models.Protocol, error) {
 	return protocol, nil
 }
 
-// UpsertYieldRate creates or updates a yield rate
+// UpsertYieldRate creates or updates a yield rate. Rates entered manually can
+// only be overwritten by the same manual source; other writers get ErrManualRate
 func (db *DB) UpsertYieldRate(rate *models.YieldRate) error {
-	// First, check if this exact pool already exists
-	var existingID int64
-	checkQuery := `
-		SELECT id FROM yield_rates
-		WHERE protocol_id = ? AND pool_name = ? AND chain = ?
-	`
-	err := db.conn.QueryRow(checkQuery, rate.ProtocolID, rate.PoolName, rate.Chain).Scan(&existingID)
+	_, err := db.UpsertYieldRateChange(rate)
+	return err
+}
 
-	now := time.Now()
-	if err == sql.ErrNoRows {
-		// Insert new record
-		query := `
-			INSERT INTO yield_rates (protocol_id, asset, chain, apy, tvl, maturity_date, pool_name, external_url, updated_at, created_at)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-			RETURNING id
-		`
-		return db.conn.QueryRow(
-			query,
-			rate.ProtocolID,
-			rate.Asset,
-			rate.Chain,
-			rate.APY,
-			rate.TVL,
-			rate.MaturityDate,
-			rate.PoolName,
-			rate.ExternalURL,
-			now,
-			now,
-		).Scan(&rate.ID)
-	} else if err != nil {
-		return err
+// apyChangeThreshold is the smallest APY move reported as a change; smaller
+// moves don't show at the two decimals the table displays
+const apyChangeThreshold = 0.005
+
+// UpsertYieldRateChange is UpsertYieldRate that also reports how the APY of
+// the pool moved, or nil if it didn't
+func (db *DB) UpsertYieldRateChange(rate *models.YieldRate) (*models.RateChange, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
 	}
+	defer tx.Rollback()
 
-	// Update existing record
-	query := `
-		UPDATE yield_rates
-		SET asset = ?, apy = ?, tvl = ?, maturity_date = ?, external_url = ?, updated_at = ?
-		WHERE id = ?
-	`
-	_, err = db.conn.Exec(
-		query,
-		rate.Asset,
-		rate.APY,
-		rate.TVL,
-		rate.MaturityDate,
-		rate.ExternalURL,
-		now,
-		existingID,
+	w, err := newRateWriter(tx, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	_, change, err := w.write(rate)
+	if err != nil {
+		return nil, err
+	}
+	return change, tx.Commit()
+}
+
+// recordObservation appends the current APY and TVL of a stored rate to its history
+func recordObservation(tx *txn, rate *models.YieldRate, at time.Time) error {
+	_, err := tx.Exec(
+		`INSERT INTO yield_history (yield_rate_id, observed_at, apy, tvl) VALUES (?, ?, ?, ?)`,
+		rate.ID, at.Unix(), rate.APY, rate.TVL,
 	)
-	rate.ID = existingID
 	return err
 }
 
+// newRateChange describes the APY move of a stored rate
+func newRateChange(rate *models.YieldRate, oldAPY float64, direction string) *models.RateChange {
+	return &models.RateChange{
+		ID:        rate.ID,
+		PoolName:  rate.PoolName,
+		Chain:     rate.Chain,
+		OldAPY:    oldAPY,
+		APY:       rate.APY,
+		Direction: direction,
+	}
+}
+
+// replaceRewards overwrites the reward token breakdown of a yield rate
+func replaceRewards(tx *txn, yieldRateID int64, rewards []models.RewardAPY) error {
+	if _, err := tx.Exec(`DELETE FROM yield_rewards WHERE yield_rate_id = ?`, yieldRateID); err != nil {
+		return err
+	}
+
+	for _, reward := range rewards {
+		_, err := tx.Exec(
+			`INSERT INTO yield_rewards (yield_rate_id, token, apy) VALUES (?, ?, ?)
+			ON CONFLICT(yield_rate_id, token) DO UPDATE SET apy = yield_rewards.apy + excluded.apy`,
+			yieldRateID, reward.Token, reward.APY,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rateIndex maps the IDs of rates to their positions, for attaching rows of
+// the tables keyed by yield_rate_id. in is the placeholder list of the IDs
+// and args their values
+func rateIndex(rates []models.YieldRate) (index map[int64]int, in string, args []interface{}) {
+	index = make(map[int64]int, len(rates))
+	placeholders := make([]string, len(rates))
+	args = make([]interface{}, len(rates))
+	for i, rate := range rates {
+		index[rate.ID] = i
+		placeholders[i] = "?"
+		args[i] = rate.ID
+	}
+	return index, strings.Join(placeholders, ","), args
+}
+
+// loadRewards attaches the reward token breakdown to each rate
+func (db *DB) loadRewards(rates []models.YieldRate) error {
+	if len(rates) == 0 {
+		return nil
+	}
+
+	index, in, args := rateIndex(rates)
+	query := fmt.Sprintf(
+		`SELECT yield_rate_id, token, apy FROM yield_rewards WHERE yield_rate_id IN (%s) ORDER BY apy DESC`,
+		in,
+	)
+	rows, err := db.read.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var reward models.RewardAPY
+		if err := rows.Scan(&id, &reward.Token, &reward.APY); err != nil {
+			return err
+		}
+		if i, ok := index[id]; ok {
+			rates[i].Rewards = append(rates[i].Rewards, reward)
+		}
+	}
+
+	return rows.Err()
+}
+
+// loadCategories attaches the categories to each rate
+func (db *DB) loadCategories(rates []models.YieldRate) error {
+	if len(rates) == 0 {
+		return nil
+	}
+
+	index, in, args := rateIndex(rates)
+	query := fmt.Sprintf(
+		`SELECT yield_rate_id, category FROM yield_categories WHERE yield_rate_id IN (%s) ORDER BY category`,
+		in,
+	)
+	rows, err := db.read.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var category string
+		if err := rows.Scan(&id, &category); err != nil {
+			return err
+		}
+		if i, ok := index[id]; ok {
+			rates[i].Categories = append(rates[i].Categories, category)
+		}
+	}
+
+	return rows.Err()
+}
+
+// loadPendleMarkets attaches the Pendle market metadata to the rates that have it
+func (db *DB) loadPendleMarkets(rates []models.YieldRate) error {
+	if len(rates) == 0 {
+		return nil
+	}
+
+	index, in, args := rateIndex(rates)
+	query := fmt.Sprintf(
+		`SELECT yield_rate_id, address, pt, yt, sy, underlying_asset, fee_rate FROM pendle_markets WHERE yield_rate_id IN (%s)`,
+		in,
+	)
+	rows, err := db.read.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var market models.PendleMarket
+		if err := rows.Scan(&id, &market.Address, &market.PT, &market.YT, &market.SY, &market.UnderlyingAsset, &market.FeeRate); err != nil {
+			return err
+		}
+		if i, ok := index[id]; ok {
+			rates[i].Pendle = &market
+		}
+	}
+
+	return rows.Err()
+}
+
 // GetYieldRates retrieves yield rates with optional filtering
 func (db *DB) GetYieldRates(filters models.FilterParams) ([]models.YieldRate, error) {
-	query := `
-		SELECT
-			yr.id, yr.protocol_id, p.name as protocol_name, yr.asset, yr.chain,
-			yr.apy, yr.tvl, yr.maturity_date, yr.pool_name, yr.external_url,
-			yr.updated_at, yr.created_at
-		FROM yield_rates yr
-		JOIN protocols p ON yr.protocol_id = p.id
-		WHERE 1=1
-	`
+	query := yieldRateSelect + " WHERE 1=1"
 
-	args := []interface{}{}
+	now := time.Now()
+	args := changeWindowArgs(now)
 
 	if filters.MinAPY > 0 {
 		query += " AND yr.apy >= ?"
@@ -211,6 +503,39 @@ func (db *DB) GetYieldRates(filters models.FilterParams) ([]models.YieldRate, er
 		args = append(args, filters.ProtocolName)
 	}
 
+	if filters.Category != "" {
+		query += " AND EXISTS (SELECT 1 FROM yield_categories c WHERE c.yield_rate_id = yr.id AND c.category = ?)"
+		args = append(args, normalizeCategory(filters.Category))
+	}
+
+	// Maturities are stored in UTC, so SQLite can compare them as text
+	if filters.MinDaysToMaturity > 0 {
+		query += " AND yr.maturity_date >= ?"
+		args = append(args, now.AddDate(0, 0, filters.MinDaysToMaturity).UTC())
+	}
+
+	if filters.MaxDaysToMaturity > 0 {
+		query += " AND yr.maturity_date <= ?"
+		args = append(args, now.AddDate(0, 0, filters.MaxDaysToMaturity).UTC())
+	}
+
+	if !filters.MaturityAfter.IsZero() {
+		query += " AND yr.maturity_date >= ?"
+		args = append(args, startOfDay(filters.MaturityAfter).AddDate(0, 0, 1))
+	}
+
+	if !filters.MaturityBefore.IsZero() {
+		query += " AND yr.maturity_date < ?"
+		args = append(args, startOfDay(filters.MaturityBefore))
+	}
+
+	switch filters.Term {
+	case models.TermFixed:
+		query += " AND yr.maturity_date IS NOT NULL"
+	case models.TermVariable:
+		query += " AND yr.maturity_date IS NULL"
+	}
+
 	// Sorting
 	sortBy := "yr.apy"
 	if filters.SortBy != "" {
@@ -221,6 +546,21 @@ func (db *DB) GetYieldRates(filters models.FilterParams) ([]models.YieldRate, er
 			sortBy = "yr.tvl"
 		case "updated_at":
 			sortBy = "yr.updated_at"
+		case "apy_change_24h":
+			sortBy = "apy_change_24h"
+		case "maturity":
+			sortBy = "yr.maturity_date"
+		case "apy_at_size":
+			// As models.YieldRate.APYAtSize, the APY of other rates. Pendle
+			// pools without liquidity have no estimate and sort last
+			sortBy = `CASE WHEN yr.tvl > 0 OR NOT EXISTS (SELECT 1 FROM pendle_markets pm WHERE pm.yield_rate_id = yr.id)
+				THEN COALESCE((SELECT yr.apy * yr.tvl / (yr.tvl + ?) - pm.fee_rate * 100
+					FROM pendle_markets pm WHERE pm.yield_rate_id = yr.id), yr.apy) END`
+			tradeSize := filters.TradeSize
+			if tradeSize <= 0 {
+				tradeSize = models.DefaultTradeSize
+			}
+			args = append(args, tradeSize)
 		}
 	}
 
@@ -229,9 +569,63 @@ func (db *DB) GetYieldRates(filters models.FilterParams) ([]models.YieldRate, er
 		sortOrder = "ASC"
 	}
 
-	query += fmt.Sprintf(" ORDER BY %s %s", sortBy, sortOrder)
+	// Pools without enough history, a maturity or an APY estimate sort last
+	// either way
+	query += fmt.Sprintf(" ORDER BY %s %s NULLS LAST", sortBy, sortOrder)
 
-	rows, err := db.conn.Query(query, args...)
+	return db.queryYieldRates(query, args...)
+}
+
+// GetYieldRate retrieves a single yield rate by ID
+func (db *DB) GetYieldRate(id int64) (*models.YieldRate, error) {
+	args := append(changeWindowArgs(time.Now()), id)
+	rates, err := db.queryYieldRates(yieldRateSelect+" WHERE yr.id = ?", args...)
+	if err != nil {
+		return nil, err
+	}
+	if len(rates) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &rates[0], nil
+}
+
+// yieldRateSelect selects every yield rate column joined with its protocol
+// name, and the APY and TVL changes against the last observations before the
+// cutoffs bound by changeWindowArgs
+const yieldRateSelect = `
+	SELECT
+		yr.id, yr.protocol_id, p.name as protocol_name, yr.asset, yr.chain,
+		yr.apy, yr.base_apy, yr.reward_apy, yr.tvl, yr.maturity_date, yr.pool_name, yr.external_url,
+		yr.source, yr.updated_at, yr.created_at,
+		yr.apy - (` + pastObservation + `) AS apy_change_24h,
+		yr.apy - (` + pastObservation + `) AS apy_change_7d,
+		yr.tvl - (` + pastTVLObservation + `) AS tvl_change_24h,
+		yr.tvl - (` + pastTVLObservation + `) AS tvl_change_7d
+	FROM yield_rates yr
+	JOIN protocols p ON yr.protocol_id = p.id
+`
+
+// pastObservation and pastTVLObservation select the APY and TVL of the last
+// observation of a pool at or before a cutoff
+const (
+	pastObservation = `SELECT h.apy FROM yield_history h
+		WHERE h.yield_rate_id = yr.id AND h.observed_at <= ? ORDER BY h.observed_at DESC LIMIT 1`
+	pastTVLObservation = `SELECT h.tvl FROM yield_history h
+		WHERE h.yield_rate_id = yr.id AND h.observed_at <= ? ORDER BY h.observed_at DESC LIMIT 1`
+)
+
+// changeWindowArgs returns the cutoff arguments of yieldRateSelect, which
+// must come before any other query argument
+func changeWindowArgs(now time.Time) []interface{} {
+	day := now.Add(-24 * time.Hour).Unix()
+	week := now.Add(-7 * 24 * time.Hour).Unix()
+	return []interface{}{day, week, day, week}
+}
+
+// queryYieldRates runs a yieldRateSelect query and scans the results,
+// rewards, categories and protocol metadata included
+func (db *DB) queryYieldRates(query string, args ...interface{}) ([]models.YieldRate, error) {
+	rows, err := db.read.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -241,6 +635,7 @@ func (db *DB) GetYieldRates(filters models.FilterParams) ([]models.YieldRate, er
 	for rows.Next() {
 		var rate models.YieldRate
 		var maturityDate sql.NullTime
+		var apyChange24h, apyChange7d, tvlChange24h, tvlChange7d sql.NullFloat64
 
 		err := rows.Scan(
 			&rate.ID,
@@ -249,12 +644,19 @@ func (db *DB) GetYieldRates(filters models.FilterParams) ([]models.YieldRate, er
 			&rate.Asset,
 			&rate.Chain,
 			&rate.APY,
+			&rate.BaseAPY,
+			&rate.RewardAPY,
 			&rate.TVL,
 			&maturityDate,
 			&rate.PoolName,
 			&rate.ExternalURL,
+			&rate.Source,
 			&rate.UpdatedAt,
 			&rate.CreatedAt,
+			&apyChange24h,
+			&apyChange7d,
+			&tvlChange24h,
+			&tvlChange7d,
 		)
 		if err != nil {
 			return nil, err
@@ -263,17 +665,54 @@ func (db *DB) GetYieldRates(filters models.FilterParams) ([]models.YieldRate, er
 		if maturityDate.Valid {
 			rate.MaturityDate = &maturityDate.Time
 		}
+		rate.APYChange24h = nullFloatPtr(apyChange24h)
+		rate.APYChange7d = nullFloatPtr(apyChange7d)
+		rate.TVLChange24h = nullFloatPtr(tvlChange24h)
+		rate.TVLChange7d = nullFloatPtr(tvlChange7d)
 
 		rates = append(rates, rate)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := db.loadRewards(rates); err != nil {
+		return nil, err
+	}
+	if err := db.loadCategories(rates); err != nil {
+		return nil, err
+	}
+	if err := db.loadPendleMarkets(rates); err != nil {
+		return nil, err
+	}
 
-	return rates, rows.Err()
+	return rates, nil
+}
+
+// nullFloatPtr returns a pointer to the value of f, or nil if it is NULL
+func nullFloatPtr(f sql.NullFloat64) *float64 {
+	if !f.Valid {
+		return nil
+	}
+	return &f.Float64
+}
+
+// RatesVersion returns a value that changes whenever yield rates are
+// written or deleted, by this process or any other sharing the database
+func (db *DB) RatesVersion() (string, error) {
+	var count int64
+	var updated sql.NullString
+	err := db.read.QueryRow(`SELECT COUNT(*), MAX(updated_at) FROM yield_rates`).Scan(&count, &updated)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d/%s", count, updated.String), nil
 }
 
 // GetDistinctAssets returns all unique assets
 func (db *DB) GetDistinctAssets() ([]string, error) {
 	query := `SELECT DISTINCT asset FROM yield_rates ORDER BY asset`
-	rows, err := db.conn.Query(query)
+	rows, err := db.read.Query(query)
 	if err != nil {
 		return nil, err
 	}
@@ -294,7 +733,7 @@ func (db *DB) GetDistinctAssets() ([]string, error) {
 // GetDistinctChains returns all unique chains
 func (db *DB) GetDistinctChains() ([]string, error) {
 	query := `SELECT DISTINCT chain FROM yield_rates ORDER BY chain`
-	rows, err := db.conn.Query(query)
+	rows, err := db.read.Query(query)
 	if err != nil {
 		return nil, err
 	}
@@ -311,3 +750,34 @@ func (db *DB) GetDistinctChains() ([]string, error) {
 
 	return chains, rows.Err()
 }
+
+// GetDistinctCategories returns all categories rates are listed under
+func (db *DB) GetDistinctCategories() ([]string, error) {
+	query := `SELECT DISTINCT category FROM yield_categories ORDER BY category`
+	rows, err := db.read.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []string
+	for rows.Next() {
+		var category string
+		if err := rows.Scan(&category); err != nil {
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+
+	return categories, rows.Err()
+}
+
+// startOfDay returns midnight UTC of the date of t
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// normalizeCategory returns the form categories are stored and matched in
+func normalizeCategory(category string) string {
+	return strings.ToLower(strings.TrimSpace(category))
+}
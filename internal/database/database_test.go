@@ -2,6 +2,7 @@ package database
 
 import (
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -152,6 +153,195 @@ func TestUpsertYieldRate(t *testing.T) {
 	}
 }
 
+// TestUpsertYieldRate_Rewards tests that the reward breakdown is stored and replaced
+func TestUpsertYieldRate_Rewards(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	protocol := &models.Protocol{Name: "Curve"}
+	db.CreateOrUpdateProtocol(protocol)
+
+	rate := &models.YieldRate{
+		ProtocolID: protocol.ID,
+		Asset:      "DAI/USDC/USDT",
+		Chain:      "Ethereum",
+		APY:        3.5,
+		BaseAPY:    0.5,
+		RewardAPY:  3.0,
+		Rewards: []models.RewardAPY{
+			{Token: "CRV", APY: 2.0},
+			{Token: "CVX", APY: 1.0},
+		},
+		TVL:      1000000,
+		PoolName: "3pool",
+	}
+
+	if err := db.UpsertYieldRate(rate); err != nil {
+		t.Fatalf("UpsertYieldRate() insert failed: %v", err)
+	}
+
+	rates, err := db.GetYieldRates(models.FilterParams{})
+	if err != nil {
+		t.Fatalf("GetYieldRates() failed: %v", err)
+	}
+
+	if rates[0].BaseAPY != 0.5 || rates[0].RewardAPY != 3.0 {
+		t.Errorf("BaseAPY/RewardAPY = %.2f/%.2f, want 0.50/3.00", rates[0].BaseAPY, rates[0].RewardAPY)
+	}
+
+	if len(rates[0].Rewards) != 2 || rates[0].Rewards[0].Token != "CRV" {
+		t.Fatalf("Rewards = %+v, want CRV then CVX", rates[0].Rewards)
+	}
+
+	// Updating with a single reward should drop the stale one
+	rate.Rewards = []models.RewardAPY{{Token: "CRV", APY: 2.5}}
+	if err := db.UpsertYieldRate(rate); err != nil {
+		t.Fatalf("UpsertYieldRate() update failed: %v", err)
+	}
+
+	rates, err = db.GetYieldRates(models.FilterParams{})
+	if err != nil {
+		t.Fatalf("GetYieldRates() failed: %v", err)
+	}
+
+	if len(rates[0].Rewards) != 1 || rates[0].Rewards[0].APY != 2.5 {
+		t.Errorf("Rewards after update = %+v, want only CRV at 2.5", rates[0].Rewards)
+	}
+}
+
+// TestUpsertYieldRateChange tests that APY moves are reported
+func TestUpsertYieldRateChange(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	protocol := &models.Protocol{Name: "Pendle"}
+	db.CreateOrUpdateProtocol(protocol)
+
+	tests := []struct {
+		name          string
+		apy           float64
+		wantDirection string
+		wantOldAPY    float64
+	}{
+		{"new pool", 10.0, models.DirectionNew, 10.0},
+		{"APY up", 12.5, models.DirectionUp, 10.0},
+		{"APY down", 11.0, models.DirectionDown, 12.5},
+		{"rounding noise", 11.001, "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rate := &models.YieldRate{
+				ProtocolID: protocol.ID,
+				Asset:      "ETH",
+				Chain:      "Ethereum",
+				APY:        tt.apy,
+				PoolName:   "PT-ETH",
+			}
+
+			change, err := db.UpsertYieldRateChange(rate)
+			if err != nil {
+				t.Fatalf("UpsertYieldRateChange() error = %v", err)
+			}
+
+			if tt.wantDirection == "" {
+				if change != nil {
+					t.Errorf("change = %+v, want nil", change)
+				}
+				return
+			}
+			if change == nil {
+				t.Fatal("change = nil, want a change")
+			}
+			if change.Direction != tt.wantDirection || change.OldAPY != tt.wantOldAPY || change.APY != tt.apy || change.ID != rate.ID {
+				t.Errorf("change = %+v, want %s from %.2f", change, tt.wantDirection, tt.wantOldAPY)
+			}
+		})
+	}
+}
+
+// TestGetYieldRates_Changes tests 24h and 7d deltas and sorting by APY change
+func TestGetYieldRates_Changes(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	protocol := &models.Protocol{Name: "Pendle"}
+	db.CreateOrUpdateProtocol(protocol)
+
+	now := time.Now()
+	observe := func(rate *models.YieldRate, age time.Duration, apy, tvl float64) {
+		_, err := db.conn.Exec(
+			`INSERT INTO yield_history (yield_rate_id, observed_at, apy, tvl) VALUES (?, ?, ?, ?)`,
+			rate.ID, now.Add(-age).Unix(), apy, tvl,
+		)
+		if err != nil {
+			t.Fatalf("Failed to insert observation: %v", err)
+		}
+	}
+
+	rising := &models.YieldRate{ProtocolID: protocol.ID, Asset: "ETH", Chain: "Ethereum", APY: 10, TVL: 1_500_000, PoolName: "rising"}
+	falling := &models.YieldRate{ProtocolID: protocol.ID, Asset: "USDC", Chain: "Ethereum", APY: 20, TVL: 100, PoolName: "falling"}
+	fresh := &models.YieldRate{ProtocolID: protocol.ID, Asset: "DAI", Chain: "Ethereum", APY: 30, TVL: 100, PoolName: "fresh"}
+	for _, rate := range []*models.YieldRate{rising, falling, fresh} {
+		if err := db.UpsertYieldRate(rate); err != nil {
+			t.Fatalf("UpsertYieldRate() failed: %v", err)
+		}
+	}
+
+	observe(rising, 8*24*time.Hour, 5, 500_000)
+	observe(rising, 30*time.Hour, 8, 1_000_000)
+	observe(rising, 2*time.Hour, 9.5, 1_400_000) // Too recent for the 24h delta
+	observe(falling, 25*time.Hour, 21, 100)
+
+	rates, err := db.GetYieldRates(models.FilterParams{SortBy: "apy_change_24h", SortOrder: "desc"})
+	if err != nil {
+		t.Fatalf("GetYieldRates() failed: %v", err)
+	}
+
+	var order []string
+	for _, rate := range rates {
+		order = append(order, rate.PoolName)
+	}
+	if strings.Join(order, ",") != "rising,falling,fresh" {
+		t.Fatalf("order = %v, want rising, falling, then fresh without history", order)
+	}
+
+	deref := func(f *float64) interface{} {
+		if f == nil {
+			return nil
+		}
+		return *f
+	}
+
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"rising APY 24h", deref(rates[0].APYChange24h), 2.0},
+		{"rising APY 7d", deref(rates[0].APYChange7d), 5.0},
+		{"rising TVL 24h", deref(rates[0].TVLChange24h), 500_000.0},
+		{"rising TVL 7d", deref(rates[0].TVLChange7d), 1_000_000.0},
+		{"falling APY 24h", deref(rates[1].APYChange24h), -1.0},
+		{"falling APY 7d", deref(rates[1].APYChange7d), nil},
+		{"fresh APY 24h", deref(rates[2].APYChange24h), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+			}
+		})
+	}
+
+	// Ascending order still puts pools without history last
+	rates, _ = db.GetYieldRates(models.FilterParams{SortBy: "apy_change_24h", SortOrder: "asc"})
+	if rates[0].PoolName != "falling" || rates[2].PoolName != "fresh" {
+		t.Errorf("ascending order starts with %s and ends with %s, want falling and fresh", rates[0].PoolName, rates[2].PoolName)
+	}
+}
+
 // TestGetYieldRates_Filtering tests various filter combinations
 func TestGetYieldRates_Filtering(t *testing.T) {
 	db, cleanup := setupTestDB(t)
@@ -232,6 +422,127 @@ func TestGetYieldRates_Filtering(t *testing.T) {
 	}
 }
 
+// TestGetYieldRates_Maturity tests the maturity filters and sorting by maturity
+func TestGetYieldRates_Maturity(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	protocol := &models.Protocol{Name: "Pendle"}
+	db.CreateOrUpdateProtocol(protocol)
+
+	now := time.Now()
+	inDays := func(days int) *time.Time {
+		maturity := now.AddDate(0, 0, days)
+		return &maturity
+	}
+	// Stored in UTC whatever the zone it comes in
+	tokyo := now.AddDate(0, 0, 200).In(time.FixedZone("JST", 9*60*60))
+	for _, rate := range []models.YieldRate{
+		{ProtocolID: protocol.ID, Asset: "USDe", Chain: "Ethereum", APY: 9, PoolName: "PT-10d", MaturityDate: inDays(10)},
+		{ProtocolID: protocol.ID, Asset: "USDe", Chain: "Ethereum", APY: 8, PoolName: "PT-60d", MaturityDate: inDays(60)},
+		{ProtocolID: protocol.ID, Asset: "USDe", Chain: "Ethereum", APY: 7, PoolName: "PT-200d", MaturityDate: &tokyo},
+		{ProtocolID: protocol.ID, Asset: "USDe", Chain: "Ethereum", APY: 6, PoolName: "Variable"},
+	} {
+		if err := db.UpsertYieldRate(&rate); err != nil {
+			t.Fatalf("UpsertYieldRate() failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		filters models.FilterParams
+		want    []string
+	}{
+		{"sorted by maturity", models.FilterParams{}, []string{"PT-10d", "PT-60d", "PT-200d", "Variable"}},
+		{"min days", models.FilterParams{MinDaysToMaturity: 30}, []string{"PT-60d", "PT-200d"}},
+		{"max days", models.FilterParams{MaxDaysToMaturity: 90}, []string{"PT-10d", "PT-60d"}},
+		{"days window", models.FilterParams{MinDaysToMaturity: 30, MaxDaysToMaturity: 90}, []string{"PT-60d"}},
+		{"after", models.FilterParams{MaturityAfter: now.AddDate(0, 0, 60)}, []string{"PT-200d"}},
+		{"before", models.FilterParams{MaturityBefore: now.AddDate(0, 0, 60)}, []string{"PT-10d"}},
+		{"fixed", models.FilterParams{Term: models.TermFixed}, []string{"PT-10d", "PT-60d", "PT-200d"}},
+		{"variable", models.FilterParams{Term: models.TermVariable}, []string{"Variable"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.filters.SortBy = "maturity"
+			tt.filters.SortOrder = "asc"
+
+			rates, err := db.GetYieldRates(tt.filters)
+			if err != nil {
+				t.Fatalf("GetYieldRates() error = %v", err)
+			}
+			var got []string
+			for _, rate := range rates {
+				got = append(got, rate.PoolName)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("GetYieldRates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestGetYieldRates_APYAtSize tests sorting by the estimated APY after the
+// price impact of a trade, which puts thin Pendle pools behind deeper ones
+func TestGetYieldRates_APYAtSize(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	protocol := &models.Protocol{Name: "Pendle"}
+	db.CreateOrUpdateProtocol(protocol)
+	for _, rate := range []models.YieldRate{
+		{Asset: "USDe", APY: 30, TVL: 100000, PoolName: "Thin", Pendle: &models.PendleMarket{FeeRate: 0.001}},
+		{Asset: "USDe", APY: 12, TVL: 50e6, PoolName: "Deep", Pendle: &models.PendleMarket{FeeRate: 0.001}},
+		{Asset: "USDC", APY: 10, TVL: 1e6, PoolName: "Variable"},
+		{Asset: "USDe", APY: 50, TVL: 0, PoolName: "Empty", Pendle: &models.PendleMarket{FeeRate: 0.001}},
+	} {
+		rate.ProtocolID, rate.Chain = protocol.ID, "Ethereum"
+		if err := db.UpsertYieldRate(&rate); err != nil {
+			t.Fatalf("UpsertYieldRate() failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		tradeSize float64
+		want      []string
+	}{
+		// Thin: 30% * 100k / 200k - 0.1% = 14.9%; Deep: about 11.88%
+		// Empty has no liquidity, so no estimate, and sorts last
+		{"default size", 0, []string{"Thin", "Deep", "Variable", "Empty"}},
+		// Thin: 30% * 100k / 1.1M - 0.1% = 2.63%
+		{"large trade", 1e6, []string{"Deep", "Variable", "Thin", "Empty"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rates, err := db.GetYieldRates(models.FilterParams{SortBy: "apy_at_size", TradeSize: tt.tradeSize})
+			if err != nil {
+				t.Fatalf("GetYieldRates() error = %v", err)
+			}
+			var got []string
+			for i, rate := range rates {
+				got = append(got, rate.PoolName)
+				// The order must match the estimate the table shows
+				if i > 0 && rate.TVL > 0 {
+					size := tt.tradeSize
+					if size == 0 {
+						size = models.DefaultTradeSize
+					}
+					prev, _ := rates[i-1].APYAtSize(size)
+					apy, _ := rate.APYAtSize(size)
+					if apy > prev {
+						t.Errorf("%s (%.2f%%) sorted after %s (%.2f%%)", rate.PoolName, apy, rates[i-1].PoolName, prev)
+					}
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("GetYieldRates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 // TestGetYieldRates_Sorting tests sorting functionality
 func TestGetYieldRates_Sorting(t *testing.T) {
 	db, cleanup := setupTestDB(t)
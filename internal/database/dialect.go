@@ -0,0 +1,112 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// dialect is the SQL flavour of the database behind a DB. Queries are
+// written once with ? placeholders in the subset of SQL both dialects share;
+// the differences live in sqlite.go and postgres.go
+type dialect int
+
+const (
+	dialectSQLite dialect = iota
+	dialectPostgres
+)
+
+// String returns the name of the backend, as logged at startup
+func (d dialect) String() string {
+	if d == dialectPostgres {
+		return "postgres"
+	}
+	return "sqlite"
+}
+
+// rebind rewrites the ? placeholders of query into the dialect's style
+func (d dialect) rebind(query string) string {
+	if d != dialectPostgres || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n, quoted := 0, false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'':
+			quoted = !quoted
+		case c == '?' && !quoted:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// isUniqueViolation reports whether err comes from a UNIQUE constraint
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+	}
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// pool is a connection pool running queries written with ? placeholders
+type pool struct {
+	*sql.DB
+	dialect dialect
+}
+
+func (p *pool) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return p.DB.Exec(p.dialect.rebind(query), args...)
+}
+
+func (p *pool) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return p.DB.Query(p.dialect.rebind(query), args...)
+}
+
+func (p *pool) QueryRow(query string, args ...interface{}) *sql.Row {
+	return p.DB.QueryRow(p.dialect.rebind(query), args...)
+}
+
+func (p *pool) Begin() (*txn, error) {
+	tx, err := p.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &txn{Tx: tx, dialect: p.dialect}, nil
+}
+
+// txn is a transaction running queries written with ? placeholders
+type txn struct {
+	*sql.Tx
+	dialect dialect
+}
+
+func (t *txn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return t.Tx.Exec(t.dialect.rebind(query), args...)
+}
+
+func (t *txn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return t.Tx.Query(t.dialect.rebind(query), args...)
+}
+
+func (t *txn) QueryRow(query string, args ...interface{}) *sql.Row {
+	return t.Tx.QueryRow(t.dialect.rebind(query), args...)
+}
+
+func (t *txn) Prepare(query string) (*sql.Stmt, error) {
+	return t.Tx.Prepare(t.dialect.rebind(query))
+}
@@ -0,0 +1,147 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// CreateFetchRun inserts a fetch run and sets its ID
+func (db *DB) CreateFetchRun(run *models.FetchRun) error {
+	chains, errs, err := marshalFetchRunLists(run)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO fetch_runs (source, status, started_at, chains_attempted, errors)
+		VALUES (?, ?, ?, ?, ?)
+		RETURNING id
+	`
+	return db.conn.QueryRow(query, run.Source, run.Status, run.StartedAt, chains, errs).Scan(&run.ID)
+}
+
+// FinishFetchRun stores the final counts and status of a fetch run
+func (db *DB) FinishFetchRun(run *models.FetchRun) error {
+	chains, errs, err := marshalFetchRunLists(run)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE fetch_runs
+		SET status = ?, finished_at = ?, chains_attempted = ?,
+			markets_received = ?, markets_active = ?, markets_expired = ?, markets_unparseable = ?,
+			rows_upserted = ?, rows_inserted = ?, rows_updated = ?, rows_unchanged = ?,
+			rows_failed = ?, errors = ?
+		WHERE id = ?
+	`
+	_, err = db.conn.Exec(
+		query,
+		run.Status,
+		run.FinishedAt,
+		chains,
+		run.Received,
+		run.Active,
+		run.Expired,
+		run.Unparseable,
+		run.Upserted,
+		run.Inserted,
+		run.Updated,
+		run.Unchanged,
+		run.Failed,
+		errs,
+		run.ID,
+	)
+	return err
+}
+
+// GetFetchRuns returns the most recent fetch runs, newest first, optionally
+// limited to one source
+func (db *DB) GetFetchRuns(source string, limit int) ([]models.FetchRun, error) {
+	query := `
+		SELECT id, source, status, started_at, finished_at, chains_attempted,
+			markets_received, markets_active, markets_expired, markets_unparseable,
+			rows_upserted, rows_inserted, rows_updated, rows_unchanged, rows_failed, errors
+		FROM fetch_runs
+	`
+	args := []interface{}{}
+	if source != "" {
+		query += " WHERE source = ?"
+		args = append(args, source)
+	}
+	query += " ORDER BY started_at DESC, id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := db.read.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []models.FetchRun
+	for rows.Next() {
+		var run models.FetchRun
+		var finishedAt sql.NullTime
+		var chains, errs string
+
+		err := rows.Scan(
+			&run.ID,
+			&run.Source,
+			&run.Status,
+			&run.StartedAt,
+			&finishedAt,
+			&chains,
+			&run.Received,
+			&run.Active,
+			&run.Expired,
+			&run.Unparseable,
+			&run.Upserted,
+			&run.Inserted,
+			&run.Updated,
+			&run.Unchanged,
+			&run.Failed,
+			&errs,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if finishedAt.Valid {
+			run.FinishedAt = &finishedAt.Time
+		}
+		if err := json.Unmarshal([]byte(chains), &run.ChainsAttempted); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(errs), &run.Errors); err != nil {
+			return nil, err
+		}
+
+		runs = append(runs, run)
+	}
+
+	return runs, rows.Err()
+}
+
+// marshalFetchRunLists encodes the list columns of a fetch run as JSON arrays
+func marshalFetchRunLists(run *models.FetchRun) (string, string, error) {
+	chainsAttempted := run.ChainsAttempted
+	if chainsAttempted == nil {
+		chainsAttempted = []string{}
+	}
+	runErrors := run.Errors
+	if runErrors == nil {
+		runErrors = []string{}
+	}
+
+	chains, err := json.Marshal(chainsAttempted)
+	if err != nil {
+		return "", "", err
+	}
+	errs, err := json.Marshal(runErrors)
+	if err != nil {
+		return "", "", err
+	}
+	return string(chains), string(errs), nil
+}
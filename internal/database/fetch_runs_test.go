@@ -0,0 +1,68 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// TestFetchRuns tests recording and listing fetch runs
+func TestFetchRuns(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	start := time.Now().Add(-time.Hour)
+	for i, source := range []string{"Pendle", "Curve", "Pendle"} {
+		run := &models.FetchRun{
+			Source:    source,
+			Status:    models.FetchRunRunning,
+			StartedAt: start.Add(time.Duration(i) * time.Minute),
+		}
+		if err := db.CreateFetchRun(run); err != nil {
+			t.Fatalf("CreateFetchRun() failed: %v", err)
+		}
+		if run.ID == 0 {
+			t.Fatal("FetchRun ID should be set after creation")
+		}
+
+		finishedAt := run.StartedAt.Add(3 * time.Second)
+		run.FinishedAt = &finishedAt
+		run.Status = models.FetchRunSuccess
+		run.ChainsAttempted = []string{"Ethereum", "Arbitrum"}
+		run.Received, run.Active, run.Expired, run.Unparseable = 10, 7, 2, 1
+		run.Upserted = 7 + i
+		run.Errors = []string{"chain 42161: timeout"}
+		if err := db.FinishFetchRun(run); err != nil {
+			t.Fatalf("FinishFetchRun() failed: %v", err)
+		}
+	}
+
+	runs, err := db.GetFetchRuns("", 10)
+	if err != nil {
+		t.Fatalf("GetFetchRuns() failed: %v", err)
+	}
+	if len(runs) != 3 {
+		t.Fatalf("GetFetchRuns() returned %d runs, want 3", len(runs))
+	}
+
+	// Newest first
+	latest := runs[0]
+	if latest.Upserted != 9 || latest.Status != models.FetchRunSuccess {
+		t.Errorf("latest run = %+v, want the third run", latest)
+	}
+	if latest.FinishedAt == nil || latest.Duration() != 3*time.Second {
+		t.Errorf("latest run duration = %v, want 3s", latest.Duration())
+	}
+	if len(latest.ChainsAttempted) != 2 || len(latest.Errors) != 1 || latest.Unparseable != 1 {
+		t.Errorf("latest run lists = %v / %v, want 2 chains and 1 error", latest.ChainsAttempted, latest.Errors)
+	}
+
+	pendleRuns, err := db.GetFetchRuns("Pendle", 1)
+	if err != nil {
+		t.Fatalf("GetFetchRuns() failed: %v", err)
+	}
+	if len(pendleRuns) != 1 || pendleRuns[0].Source != "Pendle" {
+		t.Errorf("GetFetchRuns(Pendle, 1) = %+v, want one Pendle run", pendleRuns)
+	}
+}
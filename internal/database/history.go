@@ -0,0 +1,331 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// Retention is how long the history of a pool is kept at each resolution.
+// Observations older than Raw are rolled up into hourly points, hourly
+// points older than Hourly into daily points, and daily points older than
+// Daily are dropped
+type Retention struct {
+	Raw    time.Duration // 0 keeps every observation forever
+	Hourly time.Duration
+	Daily  time.Duration // 0 keeps daily points forever
+}
+
+// DefaultRetention returns the retention DefaultOptions has
+func DefaultRetention() Retention {
+	return Retention{
+		Raw:    14 * 24 * time.Hour,
+		Hourly: 90 * 24 * time.Hour,
+	}
+}
+
+// changeWindow is the longest window GetYieldRates reports changes over.
+// They are computed from raw observations, which must cover it
+const changeWindow = 7 * 24 * time.Hour
+
+// validate checks that each resolution is kept at least as long as the finer one
+func (r Retention) validate() error {
+	switch {
+	case r.Raw == 0:
+		return nil
+	case r.Raw < changeWindow:
+		return fmt.Errorf("raw history must be kept at least %s, for the 7d changes", changeWindow)
+	case r.Hourly < r.Raw:
+		return fmt.Errorf("hourly history must be kept at least as long as raw history")
+	case r.Daily != 0 && r.Daily < r.Hourly:
+		return fmt.Errorf("daily history must be kept at least as long as hourly history")
+	}
+	return nil
+}
+
+// historyResolution is the period covered by a point of history
+type historyResolution struct {
+	name    string
+	seconds int64 // 0 for raw observations
+}
+
+var (
+	resolutionRaw  = historyResolution{models.ResolutionRaw, 0}
+	resolutionHour = historyResolution{models.ResolutionHour, 3600}
+	resolutionDay  = historyResolution{models.ResolutionDay, 86400}
+)
+
+// truncate returns the start of the period t falls in, in Unix seconds
+func (r historyResolution) truncate(t time.Time) int64 {
+	if r.seconds == 0 {
+		return t.Unix()
+	}
+	return t.Unix() / r.seconds * r.seconds
+}
+
+// resolutionFor picks the resolution of the history between from and to:
+// the coarser the longer the range, and never finer than what retention
+// still keeps at from
+func (db *DB) resolutionFor(from, to, now time.Time) historyResolution {
+	resolution := resolutionRaw
+	switch span := to.Sub(from); {
+	case span > 31*24*time.Hour:
+		resolution = resolutionDay
+	case span > 2*24*time.Hour:
+		resolution = resolutionHour
+	}
+
+	retention := db.options.Retention
+	if retention.Raw == 0 {
+		return resolution
+	}
+	if from.Before(now.Add(-retention.Hourly)) {
+		return resolutionDay
+	}
+	if from.Before(now.Add(-retention.Raw)) && resolution == resolutionRaw {
+		return resolutionHour
+	}
+	return resolution
+}
+
+// GetYieldHistory returns the history of a yield rate between from and to.
+// Long ranges, and ranges reaching back past the raw observations, are
+// read from the hourly or daily aggregates
+func (db *DB) GetYieldHistory(id int64, from, to time.Time) (*models.YieldHistory, error) {
+	if to.Before(from) {
+		return nil, fmt.Errorf("history range ends before it starts")
+	}
+
+	resolution := db.resolutionFor(from, to, time.Now())
+	history := &models.YieldHistory{
+		YieldRateID: id,
+		Resolution:  resolution.name,
+		From:        from,
+		To:          to,
+		Points:      []models.HistoryPoint{},
+	}
+	start := resolution.truncate(from)
+
+	raw, err := db.queryHistory(
+		`SELECT observed_at, apy, apy, apy, apy, tvl, 1 FROM yield_history
+		WHERE yield_rate_id = ? AND observed_at >= ? AND observed_at <= ? ORDER BY observed_at`,
+		id, start, to.Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if resolution == resolutionRaw {
+		history.Points = append(history.Points, raw...)
+		return history, nil
+	}
+
+	rollups, err := db.queryHistory(
+		`SELECT bucket, open_apy, high_apy, low_apy, close_apy, avg_tvl, samples FROM yield_history_rollups
+		WHERE yield_rate_id = ? AND resolution <= ? AND bucket >= ? AND bucket <= ? ORDER BY bucket, resolution DESC`,
+		id, resolution.seconds, start, to.Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Compaction leaves no observation inside a rolled up period, so the two
+	// lists only need merging by time
+	points := make([]models.HistoryPoint, 0, len(raw)+len(rollups))
+	for len(raw) > 0 || len(rollups) > 0 {
+		if len(raw) == 0 || len(rollups) > 0 && rollups[0].At.Before(raw[0].At) {
+			points, rollups = append(points, rollups[0]), rollups[1:]
+		} else {
+			points, raw = append(points, raw[0]), raw[1:]
+		}
+	}
+	history.Points = append(history.Points, foldHistory(points, resolution)...)
+	return history, nil
+}
+
+// queryHistory scans rows of time, open, high, low and close APY, TVL and samples
+func (db *DB) queryHistory(query string, args ...interface{}) ([]models.HistoryPoint, error) {
+	rows, err := db.read.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []models.HistoryPoint
+	for rows.Next() {
+		point, err := scanHistoryPoint(rows)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, point)
+	}
+	return points, rows.Err()
+}
+
+// scanHistoryPoint scans a row of queryHistory
+func scanHistoryPoint(row interface{ Scan(...interface{}) error }, extra ...interface{}) (models.HistoryPoint, error) {
+	var point models.HistoryPoint
+	var at int64
+	dest := append(extra, &at, &point.OpenAPY, &point.HighAPY, &point.LowAPY, &point.CloseAPY, &point.TVL, &point.Samples)
+	if err := row.Scan(dest...); err != nil {
+		return point, err
+	}
+	point.At = time.Unix(at, 0).UTC()
+	return point, nil
+}
+
+// foldHistory merges time-ordered points into one point per period of resolution
+func foldHistory(points []models.HistoryPoint, resolution historyResolution) []models.HistoryPoint {
+	var folded []models.HistoryPoint
+	for _, point := range points {
+		bucket := time.Unix(resolution.truncate(point.At), 0).UTC()
+		if n := len(folded); n > 0 && folded[n-1].At.Equal(bucket) {
+			folded[n-1].Merge(point)
+			continue
+		}
+		point.At = bucket
+		folded = append(folded, point)
+	}
+	return folded
+}
+
+// CompactResult counts what CompactHistory did
+type CompactResult struct {
+	Raw          int64 // Observations rolled up into hourly points
+	Hourly       int64 // Hourly points rolled up into daily points
+	DailyDropped int64 // Daily points deleted past retention
+}
+
+// Compacted returns how many rows were rolled up or deleted
+func (r *CompactResult) Compacted() int64 {
+	return r.Raw + r.Hourly + r.DailyDropped
+}
+
+// CompactHistory applies the retention of the database options as of now,
+// in one transaction. Only whole hours and days are rolled up, so a period is
+// never split between raw and aggregated history
+func (db *DB) CompactHistory(now time.Time) (*CompactResult, error) {
+	result := &CompactResult{}
+	retention := db.options.Retention
+	if retention.Raw == 0 {
+		return result, nil
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result.Raw, err = rollUpHistory(tx,
+		`SELECT yield_rate_id, observed_at, apy, apy, apy, apy, tvl, 1 FROM yield_history
+		WHERE observed_at < ? ORDER BY yield_rate_id, observed_at`,
+		`DELETE FROM yield_history WHERE observed_at < ?`,
+		resolutionHour.truncate(now.Add(-retention.Raw)), resolutionHour,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("roll up raw history: %w", err)
+	}
+
+	result.Hourly, err = rollUpHistory(tx,
+		`SELECT yield_rate_id, bucket, open_apy, high_apy, low_apy, close_apy, avg_tvl, samples FROM yield_history_rollups
+		WHERE resolution = 3600 AND bucket < ? ORDER BY yield_rate_id, bucket`,
+		`DELETE FROM yield_history_rollups WHERE resolution = 3600 AND bucket < ?`,
+		resolutionDay.truncate(now.Add(-retention.Hourly)), resolutionDay,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("roll up hourly history: %w", err)
+	}
+
+	if retention.Daily > 0 {
+		deleted, err := tx.Exec(
+			`DELETE FROM yield_history_rollups WHERE resolution = 86400 AND bucket < ?`,
+			resolutionDay.truncate(now.Add(-retention.Daily)),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("drop daily history: %w", err)
+		}
+		result.DailyDropped, _ = deleted.RowsAffected()
+	}
+
+	return result, tx.Commit()
+}
+
+// rollUpHistory folds the rows of selectQuery, history points ordered by rate
+// and time, into rollups of resolution, then deletes them with deleteQuery.
+// Both queries take cutoff. It returns how many rows were rolled up
+func rollUpHistory(tx *txn, selectQuery, deleteQuery string, cutoff int64, resolution historyResolution) (int64, error) {
+	rows, err := tx.Query(selectQuery, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	// The rows are read in full first, as PostgreSQL can't run the upserts
+	// while they are still being streamed over the same connection
+	type ratePoints struct {
+		id     int64
+		points []models.HistoryPoint
+	}
+	var rates []ratePoints
+	var count int64
+	for rows.Next() {
+		var id int64
+		point, err := scanHistoryPoint(rows, &id)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if n := len(rates); n == 0 || rates[n-1].id != id {
+			rates = append(rates, ratePoints{id: id})
+		}
+		rates[len(rates)-1].points = append(rates[len(rates)-1].points, point)
+		count++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return 0, nil
+	}
+
+	upsert, err := tx.Prepare(`
+		INSERT INTO yield_history_rollups (yield_rate_id, resolution, bucket, open_apy, high_apy, low_apy, close_apy, avg_tvl, samples)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (yield_rate_id, resolution, bucket) DO UPDATE SET
+			high_apy = CASE WHEN excluded.high_apy > yield_history_rollups.high_apy
+				THEN excluded.high_apy ELSE yield_history_rollups.high_apy END,
+			low_apy = CASE WHEN excluded.low_apy < yield_history_rollups.low_apy
+				THEN excluded.low_apy ELSE yield_history_rollups.low_apy END,
+			close_apy = excluded.close_apy,
+			avg_tvl = (yield_history_rollups.avg_tvl * yield_history_rollups.samples + excluded.avg_tvl * excluded.samples)
+				/ (yield_history_rollups.samples + excluded.samples),
+			samples = yield_history_rollups.samples + excluded.samples
+	`)
+	if err != nil {
+		return 0, err
+	}
+	defer upsert.Close()
+
+	for _, rate := range rates {
+		for _, p := range foldHistory(rate.points, resolution) {
+			_, err := upsert.Exec(rate.id, resolution.seconds, p.At.Unix(), p.OpenAPY, p.HighAPY, p.LowAPY, p.CloseAPY, p.TVL, p.Samples)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if _, err := tx.Exec(deleteQuery, cutoff); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// Vacuum rebuilds the database file, returning the pages freed by compacted
+// history to the file system. On PostgreSQL it runs a plain VACUUM, which
+// makes them reusable without locking the tables
+func (db *DB) Vacuum() error {
+	_, err := db.conn.Exec(`VACUUM`)
+	return err
+}
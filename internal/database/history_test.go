@@ -0,0 +1,122 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// TestCompactHistory tests rolling up old observations and reading them back
+func TestCompactHistory(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	protocol := &models.Protocol{Name: "Pendle"}
+	db.CreateOrUpdateProtocol(protocol)
+	rate := &models.YieldRate{ProtocolID: protocol.ID, Asset: "ETH", Chain: "Ethereum", APY: 3, PoolName: "Pool"}
+	db.UpsertYieldRate(rate)
+	db.conn.Exec(`DELETE FROM yield_history`)
+
+	// One observation an hour for 100 days, cycling the APY through 0-9
+	now := time.Now()
+	const observations = 100*24 + 1
+	for h := 0; h < observations; h++ {
+		_, err := db.conn.Exec(
+			`INSERT INTO yield_history (yield_rate_id, observed_at, apy, tvl) VALUES (?, ?, ?, ?)`,
+			rate.ID, now.Add(-time.Duration(h)*time.Hour).Unix(), float64(h%10), 100,
+		)
+		if err != nil {
+			t.Fatalf("failed to insert history: %v", err)
+		}
+	}
+
+	result, err := db.CompactHistory(now)
+	if err != nil {
+		t.Fatalf("CompactHistory() failed: %v", err)
+	}
+	var raw, hourly, daily int64
+	db.conn.QueryRow(`SELECT COUNT(*) FROM yield_history`).Scan(&raw)
+	db.conn.QueryRow(`SELECT COUNT(*) FROM yield_history_rollups WHERE resolution = 3600`).Scan(&hourly)
+	db.conn.QueryRow(`SELECT COUNT(*) FROM yield_history_rollups WHERE resolution = 86400`).Scan(&daily)
+	if result.Raw+raw != observations || result.Hourly == 0 || daily == 0 {
+		t.Errorf("result = %+v with %d raw, %d hourly and %d daily rows left", result, raw, hourly, daily)
+	}
+	if raw < 14*24 || raw > 15*24 {
+		t.Errorf("%d raw observations left, want the last 14 days", raw)
+	}
+
+	var high, low float64
+	var samples int
+	db.conn.QueryRow(`SELECT high_apy, low_apy, samples FROM yield_history_rollups WHERE resolution = 86400 ORDER BY bucket LIMIT 1 OFFSET 1`).
+		Scan(&high, &low, &samples)
+	if high != 9 || low != 0 || samples != 24 {
+		t.Errorf("daily point high = %v, low = %v, samples = %d, want 9, 0 and 24", high, low, samples)
+	}
+
+	// Compacting again has nothing left to do
+	if again, err := db.CompactHistory(now); err != nil || again.Compacted() != 0 {
+		t.Errorf("second CompactHistory() = %+v, %v, want nothing compacted", again, err)
+	}
+
+	tests := []struct {
+		name       string
+		from, to   time.Time
+		resolution string
+		minPoints  int
+	}{
+		{"last day", now.Add(-24 * time.Hour), now, models.ResolutionRaw, 24},
+		{"last month", now.Add(-30 * 24 * time.Hour), now, models.ResolutionHour, 30*24 - 1},
+		{"day past raw retention", now.Add(-20 * 24 * time.Hour), now.Add(-19 * 24 * time.Hour), models.ResolutionHour, 24},
+		{"all", now.Add(-101 * 24 * time.Hour), now, models.ResolutionDay, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			history, err := db.GetYieldHistory(rate.ID, tt.from, tt.to)
+			if err != nil {
+				t.Fatalf("GetYieldHistory() failed: %v", err)
+			}
+			if history.Resolution != tt.resolution || len(history.Points) < tt.minPoints {
+				t.Errorf("history has %d %s points, want at least %d %s points",
+					len(history.Points), history.Resolution, tt.minPoints, tt.resolution)
+			}
+		})
+	}
+
+	// Every observation is counted once, whichever table it ended up in
+	history, _ := db.GetYieldHistory(rate.ID, now.Add(-101*24*time.Hour), now)
+	total := 0
+	for i, point := range history.Points {
+		total += point.Samples
+		if i > 0 && !point.At.After(history.Points[i-1].At) {
+			t.Fatalf("point %d at %v isn't after the previous one", i, point.At)
+		}
+	}
+	if total != observations {
+		t.Errorf("daily points aggregate %d observations, want %d", total, observations)
+	}
+}
+
+// TestRetention_Validate tests that each resolution must outlive the finer one
+func TestRetention_Validate(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		name      string
+		retention Retention
+		wantErr   bool
+	}{
+		{"default", DefaultRetention(), false},
+		{"raw kept forever", Retention{}, false},
+		{"daily kept 1 year", Retention{Raw: 7 * day, Hourly: 30 * day, Daily: 365 * day}, false},
+		{"raw shorter than the 7d change", Retention{Raw: 3 * day, Hourly: 30 * day}, true},
+		{"hourly shorter than raw", Retention{Raw: 14 * day, Hourly: 7 * day}, true},
+		{"daily shorter than hourly", Retention{Raw: 7 * day, Hourly: 30 * day, Daily: 10 * day}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.retention.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
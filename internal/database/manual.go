@@ -0,0 +1,168 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+var (
+	// ErrManualRate is returned when a source tries to overwrite a manual entry
+	ErrManualRate = errors.New("yield rate is maintained manually")
+	// ErrNotManualRate is returned when editing a rate that wasn't entered manually
+	ErrNotManualRate = errors.New("yield rate is not a manual entry")
+	// ErrInvalidManualRate is returned for manual entries missing a field or
+	// with an invalid value
+	ErrInvalidManualRate = errors.New("invalid manual rate")
+	// ErrDuplicatePool is returned when an edit gives a manual rate the pool
+	// name and chain of another one
+	ErrDuplicatePool = errors.New("another manual rate has this pool name and chain")
+)
+
+// validateManualRate checks the fields a manual entry must provide
+func validateManualRate(rate *models.YieldRate) error {
+	if rate.Asset == "" {
+		return fmt.Errorf("%w: asset is required", ErrInvalidManualRate)
+	}
+	if rate.Chain == "" {
+		return fmt.Errorf("%w: chain is required", ErrInvalidManualRate)
+	}
+	if math.IsNaN(rate.APY) || math.IsInf(rate.APY, 0) {
+		return fmt.Errorf("%w: apy must be a number", ErrInvalidManualRate)
+	}
+	if rate.TVL < 0 {
+		return fmt.Errorf("%w: tvl can't be negative", ErrInvalidManualRate)
+	}
+	if rate.PoolName == "" {
+		rate.PoolName = rate.Asset
+	}
+	return nil
+}
+
+// ensureManualProtocol creates the Manual protocol and returns its ID
+func (db *DB) ensureManualProtocol() (int64, error) {
+	protocol := models.ManualProtocol
+	if err := db.CreateOrUpdateProtocol(&protocol); err != nil {
+		return 0, err
+	}
+	return protocol.ID, nil
+}
+
+// CreateManualRate creates a manual yield rate under the Manual protocol, or
+// replaces the manual rate with the same pool name and chain
+func (db *DB) CreateManualRate(rate *models.YieldRate) error {
+	if err := validateManualRate(rate); err != nil {
+		return err
+	}
+
+	protocolID, err := db.ensureManualProtocol()
+	if err != nil {
+		return fmt.Errorf("failed to create manual protocol: %w", err)
+	}
+
+	rate.ProtocolID = protocolID
+	rate.ProtocolName = models.ManualProtocol.Name
+	rate.Source = models.SourceManual
+	return db.UpsertYieldRate(rate)
+}
+
+// UpdateManualRate overwrites every field of the manual rate with rate.ID,
+// recording the new APY in its history and replacing its rewards in the same
+// transaction
+func (db *DB) UpdateManualRate(rate *models.YieldRate) error {
+	if err := validateManualRate(rate); err != nil {
+		return err
+	}
+
+	existing, err := db.GetYieldRate(rate.ID)
+	if err != nil {
+		return err
+	}
+	if existing.Source != models.SourceManual {
+		return ErrNotManualRate
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE yield_rates
+		SET asset = ?, chain = ?, apy = ?, base_apy = ?, reward_apy = ?, tvl = ?, maturity_date = ?,
+			pool_name = ?, external_url = ?, updated_at = ?
+		WHERE id = ?
+	`
+	now := time.Now()
+	_, err = tx.Exec(
+		query,
+		rate.Asset,
+		rate.Chain,
+		rate.APY,
+		rate.BaseAPY,
+		rate.RewardAPY,
+		rate.TVL,
+		rate.MaturityDate,
+		rate.PoolName,
+		rate.ExternalURL,
+		now,
+		rate.ID,
+	)
+	if isUniqueViolation(err) {
+		return ErrDuplicatePool
+	}
+	if err != nil {
+		return err
+	}
+	if err := recordObservation(tx, rate, now); err != nil {
+		return err
+	}
+	if err := replaceRewards(tx, rate.ID, rate.Rewards); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	rate.ProtocolID = existing.ProtocolID
+	rate.ProtocolName = existing.ProtocolName
+	rate.Source = existing.Source
+	return nil
+}
+
+// DeleteManualRate deletes the manual rate with the given ID, whether it was
+// entered through the admin API or read from the manual entries file. A rate
+// still in the file comes back on the next fetch
+func (db *DB) DeleteManualRate(id int64) error {
+	existing, err := db.GetYieldRate(id)
+	if err != nil {
+		return err
+	}
+	if !models.IsManualSource(existing.Source) {
+		return ErrNotManualRate
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deleteRate(tx, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetManualRates returns every rate filed under the Manual protocol
+func (db *DB) GetManualRates() ([]models.YieldRate, error) {
+	return db.GetYieldRates(models.FilterParams{
+		ProtocolName: models.ManualProtocol.Name,
+		SortBy:       "updated_at",
+		SortOrder:    "desc",
+	})
+}
@@ -0,0 +1,195 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// TestManualRate_CRUD tests creating, editing and deleting manual rates
+func TestManualRate_CRUD(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	rate := &models.YieldRate{Asset: "USDC", Chain: "Off-chain", APY: 9.5, TVL: 2000000, PoolName: "OTC-USDC-Q1"}
+	if err := db.CreateManualRate(rate); err != nil {
+		t.Fatalf("CreateManualRate() failed: %v", err)
+	}
+
+	if rate.ID == 0 || rate.Source != models.SourceManual {
+		t.Fatalf("CreateManualRate() set ID=%d Source=%q, want an ID and %q", rate.ID, rate.Source, models.SourceManual)
+	}
+
+	rate.APY = 10.25
+	rate.Chain = "Ethereum"
+	if err := db.UpdateManualRate(rate); err != nil {
+		t.Fatalf("UpdateManualRate() failed: %v", err)
+	}
+
+	rates, err := db.GetManualRates()
+	if err != nil {
+		t.Fatalf("GetManualRates() failed: %v", err)
+	}
+	if len(rates) != 1 || rates[0].APY != 10.25 || rates[0].Chain != "Ethereum" {
+		t.Fatalf("GetManualRates() = %+v, want the edited rate", rates)
+	}
+	if rates[0].ProtocolName != "Manual" {
+		t.Errorf("ProtocolName = %s, want Manual", rates[0].ProtocolName)
+	}
+
+	if err := db.DeleteManualRate(rate.ID); err != nil {
+		t.Fatalf("DeleteManualRate() failed: %v", err)
+	}
+	if _, err := db.GetYieldRate(rate.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetYieldRate() after delete error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+// TestManualRate_UpdateDuplicatePool tests that an edit can't give a manual
+// rate the pool of another one, and leaves the rate untouched when it fails
+func TestManualRate_UpdateDuplicatePool(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	first := &models.YieldRate{Asset: "USDC", Chain: "Ethereum", APY: 9, PoolName: "OTC-1"}
+	second := &models.YieldRate{Asset: "USDC", Chain: "Ethereum", APY: 8, PoolName: "OTC-2",
+		Rewards: []models.RewardAPY{{Token: "ARB", APY: 1}}}
+	for _, rate := range []*models.YieldRate{first, second} {
+		if err := db.CreateManualRate(rate); err != nil {
+			t.Fatalf("CreateManualRate() failed: %v", err)
+		}
+	}
+
+	edit := *second
+	edit.PoolName, edit.APY, edit.Rewards = "OTC-1", 12, nil
+	if err := db.UpdateManualRate(&edit); !errors.Is(err, ErrDuplicatePool) {
+		t.Fatalf("UpdateManualRate() error = %v, want ErrDuplicatePool", err)
+	}
+
+	got, err := db.GetYieldRate(second.ID)
+	if err != nil {
+		t.Fatalf("GetYieldRate() failed: %v", err)
+	}
+	if got.PoolName != "OTC-2" || got.APY != 8 || len(got.Rewards) != 1 {
+		t.Errorf("rate after a failed update = %+v, want it unchanged", got)
+	}
+}
+
+// TestManualRate_Validation tests that incomplete manual rates are rejected
+func TestManualRate_Validation(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := db.CreateManualRate(&models.YieldRate{Chain: "Ethereum", APY: 5}); err == nil {
+		t.Error("CreateManualRate() without asset should fail")
+	}
+	if err := db.CreateManualRate(&models.YieldRate{Asset: "USDC", APY: 5}); err == nil {
+		t.Error("CreateManualRate() without chain should fail")
+	}
+}
+
+// TestManualRate_ProtectedFromOtherSources tests that fetched rates can't overwrite manual ones
+func TestManualRate_ProtectedFromOtherSources(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	manual := &models.YieldRate{Asset: "USDC", Chain: "Ethereum", APY: 9.5, PoolName: "OTC-USDC"}
+	if err := db.CreateManualRate(manual); err != nil {
+		t.Fatalf("CreateManualRate() failed: %v", err)
+	}
+
+	// An automated source writing the same key must not clobber the manual row
+	fetched := &models.YieldRate{
+		ProtocolID: manual.ProtocolID,
+		Asset:      "USDC",
+		Chain:      "Ethereum",
+		APY:        1.0,
+		PoolName:   "OTC-USDC",
+		Source:     "Pendle",
+	}
+	if err := db.UpsertYieldRate(fetched); !errors.Is(err, ErrManualRate) {
+		t.Errorf("UpsertYieldRate() over a manual rate error = %v, want ErrManualRate", err)
+	}
+
+	stored, err := db.GetYieldRate(manual.ID)
+	if err != nil {
+		t.Fatalf("GetYieldRate() failed: %v", err)
+	}
+	if stored.APY != 9.5 {
+		t.Errorf("Manual rate APY = %.2f, want 9.50", stored.APY)
+	}
+
+	// Fetched rates can't be edited or deleted through the manual API
+	protocol := &models.Protocol{Name: "Pendle"}
+	db.CreateOrUpdateProtocol(protocol)
+	other := &models.YieldRate{ProtocolID: protocol.ID, Asset: "ETH", Chain: "Ethereum", APY: 3, PoolName: "ETH-1", Source: "Pendle"}
+	db.UpsertYieldRate(other)
+
+	if err := db.DeleteManualRate(other.ID); !errors.Is(err, ErrNotManualRate) {
+		t.Errorf("DeleteManualRate() on a fetched rate error = %v, want ErrNotManualRate", err)
+	}
+}
+
+// TestManualRate_FileRemovals tests that rates dropped from the manual
+// entries file are deleted on the next fetch, and that file rates can be
+// deleted by hand
+func TestManualRate_FileRemovals(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	protocolID, err := db.ensureManualProtocol()
+	if err != nil {
+		t.Fatalf("ensureManualProtocol() failed: %v", err)
+	}
+	admin := &models.YieldRate{Asset: "DAI", Chain: "Ethereum", APY: 4, PoolName: "Desk-DAI"}
+	if err := db.CreateManualRate(admin); err != nil {
+		t.Fatalf("CreateManualRate() failed: %v", err)
+	}
+	file := func(pools ...string) []models.YieldRate {
+		var rates []models.YieldRate
+		for _, pool := range pools {
+			rates = append(rates, models.YieldRate{ProtocolID: protocolID, Asset: "USDC", Chain: "Off-chain", APY: 8, PoolName: pool})
+		}
+		return rates
+	}
+
+	if _, err := db.ReplaceSourceRates(models.SourceManualFile, file("A", "B")); err != nil {
+		t.Fatalf("ReplaceSourceRates() failed: %v", err)
+	}
+	result, err := db.ReplaceSourceRates(models.SourceManualFile, file("A"))
+	if err != nil {
+		t.Fatalf("ReplaceSourceRates() failed: %v", err)
+	}
+	if result.Removed != 1 {
+		t.Errorf("Removed = %d, want 1", result.Removed)
+	}
+
+	pools := func() []string {
+		rates, err := db.GetManualRates()
+		if err != nil {
+			t.Fatalf("GetManualRates() failed: %v", err)
+		}
+		var names []string
+		for _, rate := range rates {
+			names = append(names, rate.PoolName)
+		}
+		sort.Strings(names)
+		return names
+	}
+	if got := strings.Join(pools(), ","); got != "A,Desk-DAI" {
+		t.Errorf("manual rates = %s, want A and the admin entry", got)
+	}
+
+	var fileRate int64
+	db.conn.QueryRow(`SELECT id FROM yield_rates WHERE pool_name = 'A'`).Scan(&fileRate)
+	if err := db.DeleteManualRate(fileRate); err != nil {
+		t.Errorf("DeleteManualRate() on a file rate failed: %v", err)
+	}
+	if got := strings.Join(pools(), ","); got != "Desk-DAI" {
+		t.Errorf("manual rates = %s, want the admin entry", got)
+	}
+}
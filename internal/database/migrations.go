@@ -0,0 +1,280 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+)
+
+// ErrSchemaOutdated is returned by OpenExisting for databases missing a
+// migration
+var ErrSchemaOutdated = errors.New("database schema is missing or out of date")
+
+// Migration is a step of the schema and whether a database has had it
+type Migration struct {
+	Name    string
+	Applied bool
+}
+
+// migration is a schema change. applied reports whether the database
+// already has it, so that apply only runs once and status can be reported
+// without changing anything
+type migration struct {
+	name    string
+	applied func() (bool, error)
+	apply   func() error
+}
+
+// addedColumn is a column added to a table after it was first created
+type addedColumn struct {
+	table, column, definition string
+}
+
+// sqliteColumns are the columns added to SQLite tables after they were
+// first created
+var sqliteColumns = []addedColumn{
+	{"yield_rates", "base_apy", "REAL NOT NULL DEFAULT 0"},
+	{"yield_rates", "reward_apy", "REAL NOT NULL DEFAULT 0"},
+	{"yield_rates", "source", "TEXT NOT NULL DEFAULT ''"},
+	{"fetch_runs", "rows_inserted", "INTEGER NOT NULL DEFAULT 0"},
+	{"fetch_runs", "rows_updated", "INTEGER NOT NULL DEFAULT 0"},
+	{"fetch_runs", "rows_unchanged", "INTEGER NOT NULL DEFAULT 0"},
+	{"pendle_markets", "fee_rate", "REAL NOT NULL DEFAULT 0"},
+}
+
+// postgresColumns are the columns added to PostgreSQL tables after they
+// were first created
+var postgresColumns = []addedColumn{
+	{"pendle_markets", "fee_rate", "DOUBLE PRECISION NOT NULL DEFAULT 0"},
+}
+
+// migrations returns the migrations of the database's dialect in order
+func (db *DB) migrations() []migration {
+	if db.dialect == dialectPostgres {
+		return append([]migration{{
+			name:    "create tables and indexes",
+			applied: func() (bool, error) { return db.hasSchema(postgresSchema) },
+			apply:   db.migratePostgres,
+		}}, db.columnMigrations(postgresColumns)...)
+	}
+
+	migrations := []migration{{
+		name:    "create tables and indexes",
+		applied: func() (bool, error) { return db.hasSchema(sqliteSchema) },
+		apply: func() error {
+			_, err := db.conn.Exec(sqliteSchema)
+			return err
+		},
+	}}
+	migrations = append(migrations, db.columnMigrations(sqliteColumns)...)
+	return append(migrations, migration{
+		name:    "unique index on pools",
+		applied: func() (bool, error) { return db.hasObject("idx_yield_rates_pool") },
+		apply:   db.ensureUniquePools,
+	})
+}
+
+// columnMigrations returns the migrations adding columns
+func (db *DB) columnMigrations(columns []addedColumn) []migration {
+	var migrations []migration
+	for _, c := range columns {
+		migrations = append(migrations, migration{
+			name:    fmt.Sprintf("add column %s.%s", c.table, c.column),
+			applied: func() (bool, error) { return db.hasColumn(c.table, c.column) },
+			apply:   func() error { return db.addColumn(c.table, c.column, c.definition) },
+		})
+	}
+	return migrations
+}
+
+// migrate applies the migrations the database doesn't have yet
+func (db *DB) migrate() error {
+	for _, m := range db.migrations() {
+		applied, err := m.applied()
+		if err != nil {
+			return fmt.Errorf("%s: %w", m.name, err)
+		}
+		if applied {
+			continue
+		}
+		if err := m.apply(); err != nil {
+			return fmt.Errorf("%s: %w", m.name, err)
+		}
+	}
+	return nil
+}
+
+// Migrations reports which migrations the database has had
+func (db *DB) Migrations() ([]Migration, error) {
+	var status []Migration
+	for _, m := range db.migrations() {
+		applied, err := m.applied()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", m.name, err)
+		}
+		status = append(status, Migration{Name: m.name, Applied: applied})
+	}
+	return status, nil
+}
+
+// MigrationStatus opens the database at dsn without migrating it and
+// reports which migrations it has had
+func MigrationStatus(dsn string) ([]Migration, error) {
+	db, err := connectExisting(dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return db.Migrations()
+}
+
+// OpenExisting opens the database at dsn with DefaultOptions for reading,
+// without creating or migrating it, and fails with ErrSchemaOutdated unless
+// it has had every migration. Closing it leaves the database as it was
+func OpenExisting(dsn string) (*DB, error) {
+	db, err := connectExisting(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, err := db.Migrations()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	for _, m := range migrations {
+		if !m.Applied {
+			db.Close()
+			return nil, fmt.Errorf("%w: %s not applied", ErrSchemaOutdated, m.Name)
+		}
+	}
+	return db, nil
+}
+
+// connectExisting connects to the database at dsn with DefaultOptions,
+// failing rather than creating a SQLite database that doesn't exist and
+// keeping its journal mode
+func connectExisting(dsn string) (*DB, error) {
+	if !IsPostgresDSN(dsn) {
+		if _, err := os.Stat(dsn); err != nil {
+			return nil, err
+		}
+	}
+	options := DefaultOptions()
+	options.JournalMode = ""
+	db, err := connect(dsn, options)
+	if err != nil {
+		return nil, err
+	}
+	db.existing = true
+	return db, nil
+}
+
+// schemaObjectPattern matches the tables and indexes a schema creates
+var schemaObjectPattern = regexp.MustCompile(`(?i)CREATE\s+(?:UNIQUE\s+)?(?:TABLE|INDEX)\s+IF\s+NOT\s+EXISTS\s+(\w+)`)
+
+// hasSchema reports whether every table and index of schema exists
+func (db *DB) hasSchema(schema string) (bool, error) {
+	for _, match := range schemaObjectPattern.FindAllStringSubmatch(schema, -1) {
+		exists, err := db.hasObject(match[1])
+		if err != nil || !exists {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// hasObject reports whether a table or index exists
+func (db *DB) hasObject(name string) (bool, error) {
+	query := `SELECT COUNT(*) > 0 FROM sqlite_master WHERE name = ?`
+	if db.dialect == dialectPostgres {
+		query = `SELECT to_regclass(?) IS NOT NULL`
+	}
+	var exists bool
+	err := db.conn.QueryRow(query, name).Scan(&exists)
+	return exists, err
+}
+
+// hasColumn reports whether a table has a column
+func (db *DB) hasColumn(table, column string) (bool, error) {
+	if db.dialect == dialectPostgres {
+		var exists bool
+		err := db.conn.QueryRow(`SELECT COUNT(*) > 0 FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?`, table, column).Scan(&exists)
+		return exists, err
+	}
+
+	rows, err := db.conn.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// addColumn adds a column to a table. On PostgreSQL replicas may race to
+// add it, so an existing column is left alone
+func (db *DB) addColumn(table, column, definition string) error {
+	add := "ADD COLUMN"
+	if db.dialect == dialectPostgres {
+		add = "ADD COLUMN IF NOT EXISTS"
+	}
+	if _, err := db.conn.Exec(fmt.Sprintf("ALTER TABLE %s %s %s %s", table, add, column, definition)); err != nil {
+		return err
+	}
+	slog.Info("migrated database", "table", table, "added_column", column)
+	return nil
+}
+
+// ensureUniquePools creates the unique index upserts conflict on. Databases
+// from before the index may hold duplicate pools; the oldest row of each is
+// kept, as it is the one watchlists and history point to
+func (db *DB) ensureUniquePools() error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		DELETE FROM yield_rates WHERE id NOT IN (
+			SELECT MIN(id) FROM yield_rates GROUP BY protocol_id, pool_name, chain
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	if removed, _ := result.RowsAffected(); removed > 0 {
+		for _, table := range rateTables {
+			if _, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE yield_rate_id NOT IN (SELECT id FROM yield_rates)`, table)); err != nil {
+				return err
+			}
+		}
+		slog.Info("migrated database", "table", "yield_rates", "removed_duplicates", removed)
+	}
+
+	if _, err := tx.Exec(`CREATE UNIQUE INDEX idx_yield_rates_pool ON yield_rates(protocol_id, pool_name, chain)`); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
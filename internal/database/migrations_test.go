@@ -0,0 +1,141 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// TestMigrationStatus tests that the status of an older database lists its
+// missing migrations as pending, without applying them, until it is opened
+func TestMigrationStatus(t *testing.T) {
+	dbPath := "test_defirates_" + t.Name() + ".db"
+	defer os.Remove(dbPath)
+
+	db, err := New(dbPath)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	// Roll back to before the unique index and the reward_apy and fee_rate columns
+	db.conn.Exec(`DROP INDEX idx_yield_rates_pool`)
+	for _, drop := range []string{
+		`ALTER TABLE yield_rates DROP COLUMN reward_apy`,
+		`ALTER TABLE pendle_markets DROP COLUMN fee_rate`,
+	} {
+		if _, err := db.conn.Exec(drop); err != nil {
+			t.Fatalf("failed to drop column: %v", err)
+		}
+	}
+	db.Close()
+
+	pending := func(migrations []Migration) []string {
+		var names []string
+		for _, m := range migrations {
+			if !m.Applied {
+				names = append(names, m.Name)
+			}
+		}
+		return names
+	}
+
+	for i := 0; i < 2; i++ {
+		migrations, err := MigrationStatus(dbPath)
+		if err != nil {
+			t.Fatalf("MigrationStatus() failed: %v", err)
+		}
+		got := pending(migrations)
+		want := []string{"add column yield_rates.reward_apy", "add column pendle_markets.fee_rate", "unique index on pools"}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("pending migrations = %v, want %v", got, want)
+		}
+	}
+
+	db, err = New(dbPath)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer db.Close()
+	migrations, err := db.Migrations()
+	if err != nil {
+		t.Fatalf("Migrations() failed: %v", err)
+	}
+	if got := pending(migrations); len(got) != 0 {
+		t.Errorf("pending migrations after New() = %v, want none", got)
+	}
+}
+
+// TestOpenExisting tests that the read-only commands neither create nor
+// migrate a database
+func TestOpenExisting(t *testing.T) {
+	dbPath := "test_defirates_" + t.Name() + ".db"
+	defer os.Remove(dbPath)
+
+	if _, err := OpenExisting(dbPath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("OpenExisting() of a missing database error = %v, want os.ErrNotExist", err)
+	}
+	if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+		t.Fatalf("OpenExisting() created the missing database: %v", err)
+	}
+
+	db, err := New(dbPath)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	db.Close()
+
+	db, err = OpenExisting(dbPath)
+	if err != nil {
+		t.Fatalf("OpenExisting() of a migrated database failed: %v", err)
+	}
+	if _, err := db.GetYieldRates(models.FilterParams{}); err != nil {
+		t.Errorf("GetYieldRates() failed: %v", err)
+	}
+	if _, err := db.conn.Exec(`ALTER TABLE pendle_markets DROP COLUMN fee_rate`); err != nil {
+		t.Fatalf("failed to drop column: %v", err)
+	}
+	db.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, err := OpenExisting(dbPath); !errors.Is(err, ErrSchemaOutdated) {
+			t.Errorf("OpenExisting() of an older database error = %v, want ErrSchemaOutdated", err)
+		}
+	}
+}
+
+// TestMigrationStatus_LeavesDatabase tests that reporting the status neither
+// creates a missing database nor changes the journal mode of an existing one
+func TestMigrationStatus_LeavesDatabase(t *testing.T) {
+	dbPath := "test_defirates_" + t.Name() + ".db"
+	defer os.Remove(dbPath)
+
+	if _, err := MigrationStatus(dbPath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("MigrationStatus() of a missing database error = %v, want os.ErrNotExist", err)
+	}
+	if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+		t.Fatalf("MigrationStatus() created the missing database: %v", err)
+	}
+
+	options := DefaultOptions()
+	options.JournalMode = "delete"
+	db, err := Open(dbPath, options)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	db.Close()
+
+	if _, err := MigrationStatus(dbPath); err != nil {
+		t.Fatalf("MigrationStatus() failed: %v", err)
+	}
+	db, err = OpenExisting(dbPath)
+	if err != nil {
+		t.Fatalf("OpenExisting() failed: %v", err)
+	}
+	defer db.Close()
+	var mode string
+	if err := db.conn.QueryRow(`PRAGMA journal_mode`).Scan(&mode); err != nil || mode != "delete" {
+		t.Errorf("journal mode = %q (err %v), want delete", mode, err)
+	}
+}
@@ -0,0 +1,216 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	_ "github.com/lib/pq"
+)
+
+// IsPostgresDSN reports whether dsn is a PostgreSQL connection URL rather
+// than the path of a SQLite database
+func IsPostgresDSN(dsn string) bool {
+	return strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://")
+}
+
+// openPostgres connects to the PostgreSQL database at dsn. PostgreSQL handles
+// concurrent writers itself, so reads and writes share one pool
+func openPostgres(dsn string, options Options) (*DB, error) {
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	conn.SetMaxOpenConns(options.MaxReaders + 1)
+	conn.SetMaxIdleConns(options.MaxReaders + 1)
+
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	p := &pool{DB: conn, dialect: dialectPostgres}
+	return &DB{conn: p, read: p, dialect: dialectPostgres, options: options}, nil
+}
+
+// migratePostgres creates the PostgreSQL schema. It mirrors the SQLite schema
+// of migrate, with every column the SQLite databases were migrated to; the
+// advisory lock keeps replicas starting together from racing each other
+func (db *DB) migratePostgres() error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(4242)`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(postgresSchema); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// postgresSchema is the PostgreSQL schema, see migratePostgres
+const postgresSchema = `
+	CREATE TABLE IF NOT EXISTS protocols (
+		id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE,
+		url TEXT,
+		description TEXT,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS yield_rates (
+		id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+		protocol_id BIGINT NOT NULL REFERENCES protocols(id),
+		asset TEXT NOT NULL,
+		chain TEXT NOT NULL,
+		apy DOUBLE PRECISION NOT NULL,
+		base_apy DOUBLE PRECISION NOT NULL DEFAULT 0,
+		reward_apy DOUBLE PRECISION NOT NULL DEFAULT 0,
+		tvl DOUBLE PRECISION NOT NULL,
+		maturity_date TIMESTAMPTZ,
+		pool_name TEXT NOT NULL,
+		external_url TEXT,
+		source TEXT NOT NULL DEFAULT '',
+		updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_yield_rates_protocol ON yield_rates(protocol_id);
+	CREATE INDEX IF NOT EXISTS idx_yield_rates_apy ON yield_rates(apy);
+	CREATE INDEX IF NOT EXISTS idx_yield_rates_asset ON yield_rates(asset);
+	CREATE INDEX IF NOT EXISTS idx_yield_rates_chain ON yield_rates(chain);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_yield_rates_pool ON yield_rates(protocol_id, pool_name, chain);
+
+	CREATE TABLE IF NOT EXISTS yield_rewards (
+		yield_rate_id BIGINT NOT NULL REFERENCES yield_rates(id) ON DELETE CASCADE,
+		token TEXT NOT NULL,
+		apy DOUBLE PRECISION NOT NULL,
+		PRIMARY KEY (yield_rate_id, token)
+	);
+
+	CREATE TABLE IF NOT EXISTS yield_categories (
+		yield_rate_id BIGINT NOT NULL REFERENCES yield_rates(id) ON DELETE CASCADE,
+		category TEXT NOT NULL,
+		PRIMARY KEY (yield_rate_id, category)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_yield_categories_category ON yield_categories(category);
+
+	CREATE TABLE IF NOT EXISTS pendle_markets (
+		yield_rate_id BIGINT PRIMARY KEY REFERENCES yield_rates(id) ON DELETE CASCADE,
+		address TEXT NOT NULL,
+		pt TEXT NOT NULL,
+		yt TEXT NOT NULL,
+		sy TEXT NOT NULL,
+		underlying_asset TEXT NOT NULL,
+		fee_rate DOUBLE PRECISION NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS fetch_runs (
+		id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+		source TEXT NOT NULL,
+		status TEXT NOT NULL,
+		started_at TIMESTAMPTZ NOT NULL,
+		finished_at TIMESTAMPTZ,
+		chains_attempted TEXT NOT NULL DEFAULT '[]',
+		markets_received INTEGER NOT NULL DEFAULT 0,
+		markets_active INTEGER NOT NULL DEFAULT 0,
+		markets_expired INTEGER NOT NULL DEFAULT 0,
+		markets_unparseable INTEGER NOT NULL DEFAULT 0,
+		rows_upserted INTEGER NOT NULL DEFAULT 0,
+		rows_failed INTEGER NOT NULL DEFAULT 0,
+		rows_inserted INTEGER NOT NULL DEFAULT 0,
+		rows_updated INTEGER NOT NULL DEFAULT 0,
+		rows_unchanged INTEGER NOT NULL DEFAULT 0,
+		errors TEXT NOT NULL DEFAULT '[]'
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_fetch_runs_started ON fetch_runs(started_at);
+
+	CREATE TABLE IF NOT EXISTS yield_history (
+		yield_rate_id BIGINT NOT NULL REFERENCES yield_rates(id) ON DELETE CASCADE,
+		observed_at BIGINT NOT NULL,
+		apy DOUBLE PRECISION NOT NULL,
+		tvl DOUBLE PRECISION NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_yield_history_rate_time ON yield_history(yield_rate_id, observed_at);
+
+	CREATE TABLE IF NOT EXISTS yield_history_rollups (
+		yield_rate_id BIGINT NOT NULL REFERENCES yield_rates(id) ON DELETE CASCADE,
+		resolution INTEGER NOT NULL,
+		bucket BIGINT NOT NULL,
+		open_apy DOUBLE PRECISION NOT NULL,
+		high_apy DOUBLE PRECISION NOT NULL,
+		low_apy DOUBLE PRECISION NOT NULL,
+		close_apy DOUBLE PRECISION NOT NULL,
+		avg_tvl DOUBLE PRECISION NOT NULL,
+		samples INTEGER NOT NULL,
+		PRIMARY KEY (yield_rate_id, resolution, bucket)
+	);
+
+	CREATE TABLE IF NOT EXISTS profiles (
+		id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+		token TEXT NOT NULL UNIQUE,
+		user_id BIGINT,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS saved_filters (
+		id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+		profile_id BIGINT NOT NULL REFERENCES profiles(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		slug TEXT NOT NULL UNIQUE,
+		query TEXT NOT NULL,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (profile_id, name)
+	);
+
+	CREATE TABLE IF NOT EXISTS users (
+		id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+		username TEXT NOT NULL,
+		password_hash TEXT NOT NULL,
+		scopes TEXT NOT NULL,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username ON users(lower(username));
+
+	CREATE TABLE IF NOT EXISTS sessions (
+		token_hash TEXT PRIMARY KEY,
+		user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		expires_at BIGINT NOT NULL,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS admin_sessions (
+		token_hash TEXT PRIMARY KEY,
+		admin_token_hash TEXT NOT NULL,
+		expires_at BIGINT NOT NULL,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+		user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		prefix TEXT NOT NULL,
+		key_hash TEXT NOT NULL UNIQUE,
+		scopes TEXT NOT NULL,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		last_used_at TIMESTAMPTZ
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_profiles_user ON profiles(user_id);
+
+	CREATE TABLE IF NOT EXISTS watchlist (
+		profile_id BIGINT NOT NULL REFERENCES profiles(id) ON DELETE CASCADE,
+		yield_rate_id BIGINT NOT NULL REFERENCES yield_rates(id) ON DELETE CASCADE,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (profile_id, yield_rate_id)
+	);
+`
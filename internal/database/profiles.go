@@ -0,0 +1,190 @@
+package database
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// slugAlphabet and slugLength make short share links with ~47 bits of randomness
+const (
+	slugAlphabet = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	slugLength   = 8
+)
+
+// newSlug returns a random slug for a saved filter's short link
+func newSlug() (string, error) {
+	buf := make([]byte, slugLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for i, b := range buf {
+		buf[i] = slugAlphabet[int(b)%len(slugAlphabet)]
+	}
+	return string(buf), nil
+}
+
+// CreateProfile creates an anonymous profile with a new random token
+func (db *DB) CreateProfile() (*models.Profile, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("failed to generate profile token: %w", err)
+	}
+
+	profile := &models.Profile{Token: hex.EncodeToString(buf)}
+	err := db.conn.QueryRow(
+		`INSERT INTO profiles (token) VALUES (?) RETURNING id, created_at`,
+		profile.Token,
+	).Scan(&profile.ID, &profile.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// GetProfileByToken returns the profile with the given cookie token, or
+// sql.ErrNoRows if there is none
+func (db *DB) GetProfileByToken(token string) (*models.Profile, error) {
+	var profile models.Profile
+	var userID sql.NullInt64
+	err := db.read.QueryRow(
+		`SELECT id, token, user_id, created_at FROM profiles WHERE token = ?`,
+		token,
+	).Scan(&profile.ID, &profile.Token, &userID, &profile.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if userID.Valid {
+		profile.UserID = &userID.Int64
+	}
+	return &profile, nil
+}
+
+// SaveFilter stores a named filter for filter.ProfileID. Saving under a name
+// the profile already uses replaces that filter's query and keeps its slug
+func (db *DB) SaveFilter(filter *models.SavedFilter) error {
+	filter.Name = strings.TrimSpace(filter.Name)
+	if filter.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+
+	query := `
+		INSERT INTO saved_filters (profile_id, name, slug, query)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (profile_id, name) DO UPDATE SET query = excluded.query
+		RETURNING id, slug, created_at
+	`
+
+	// A fresh slug colliding with an existing one is unlikely but possible
+	for attempt := 0; ; attempt++ {
+		slug, err := newSlug()
+		if err != nil {
+			return fmt.Errorf("failed to generate slug: %w", err)
+		}
+
+		err = db.conn.QueryRow(query, filter.ProfileID, filter.Name, slug, filter.Query).
+			Scan(&filter.ID, &filter.Slug, &filter.CreatedAt)
+		if isUniqueViolation(err) && attempt < 3 {
+			continue
+		}
+		return err
+	}
+}
+
+// GetSavedFilters returns the saved filters of a profile by name
+func (db *DB) GetSavedFilters(profileID int64) ([]models.SavedFilter, error) {
+	rows, err := db.read.Query(`
+		SELECT id, profile_id, name, slug, query, created_at
+		FROM saved_filters
+		WHERE profile_id = ?
+		ORDER BY lower(name)
+	`, profileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var filters []models.SavedFilter
+	for rows.Next() {
+		var f models.SavedFilter
+		if err := rows.Scan(&f.ID, &f.ProfileID, &f.Name, &f.Slug, &f.Query, &f.CreatedAt); err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	return filters, rows.Err()
+}
+
+// GetSavedFilterBySlug returns the saved filter behind a short link, or
+// sql.ErrNoRows if there is none
+func (db *DB) GetSavedFilterBySlug(slug string) (*models.SavedFilter, error) {
+	var f models.SavedFilter
+	err := db.read.QueryRow(`
+		SELECT id, profile_id, name, slug, query, created_at
+		FROM saved_filters
+		WHERE slug = ?
+	`, slug).Scan(&f.ID, &f.ProfileID, &f.Name, &f.Slug, &f.Query, &f.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// DeleteSavedFilter deletes a saved filter of the profile. It returns
+// sql.ErrNoRows if the profile has no filter with that ID
+func (db *DB) DeleteSavedFilter(profileID, id int64) error {
+	result, err := db.conn.Exec(`DELETE FROM saved_filters WHERE id = ? AND profile_id = ?`, id, profileID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// AddToWatchlist pins a yield rate to the top of the profile's table. Adding
+// a rate that is already watched is a no-op
+func (db *DB) AddToWatchlist(profileID, yieldRateID int64) error {
+	_, err := db.conn.Exec(
+		`INSERT INTO watchlist (profile_id, yield_rate_id) VALUES (?, ?) ON CONFLICT DO NOTHING`,
+		profileID, yieldRateID,
+	)
+	return err
+}
+
+// RemoveFromWatchlist unpins a yield rate. Removing a rate that isn't watched
+// is a no-op
+func (db *DB) RemoveFromWatchlist(profileID, yieldRateID int64) error {
+	_, err := db.conn.Exec(
+		`DELETE FROM watchlist WHERE profile_id = ? AND yield_rate_id = ?`,
+		profileID, yieldRateID,
+	)
+	return err
+}
+
+// GetWatchlist returns the IDs of the yield rates the profile watches
+func (db *DB) GetWatchlist(profileID int64) ([]int64, error) {
+	rows, err := db.read.Query(
+		`SELECT yield_rate_id FROM watchlist WHERE profile_id = ? ORDER BY created_at, yield_rate_id`,
+		profileID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
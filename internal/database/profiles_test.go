@@ -0,0 +1,155 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// TestProfiles tests creating profiles and looking them up by token
+func TestProfiles(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	profile, err := db.CreateProfile()
+	if err != nil {
+		t.Fatalf("CreateProfile() failed: %v", err)
+	}
+	if profile.ID == 0 || len(profile.Token) != 64 {
+		t.Fatalf("CreateProfile() = %+v, want an ID and a 64 character token", profile)
+	}
+
+	other, err := db.CreateProfile()
+	if err != nil {
+		t.Fatalf("CreateProfile() failed: %v", err)
+	}
+	if other.Token == profile.Token {
+		t.Error("CreateProfile() should generate a new token for every profile")
+	}
+
+	got, err := db.GetProfileByToken(profile.Token)
+	if err != nil {
+		t.Fatalf("GetProfileByToken() failed: %v", err)
+	}
+	if got.ID != profile.ID || got.UserID != nil {
+		t.Errorf("GetProfileByToken() = %+v, want anonymous profile %d", got, profile.ID)
+	}
+
+	if _, err := db.GetProfileByToken("unknown"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetProfileByToken(unknown) error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+// TestSavedFilters tests saving, replacing, sharing and deleting filters
+func TestSavedFilters(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	profile, _ := db.CreateProfile()
+	other, _ := db.CreateProfile()
+
+	stables := &models.SavedFilter{ProfileID: profile.ID, Name: " Stables ", Query: "asset=USDC"}
+	if err := db.SaveFilter(stables); err != nil {
+		t.Fatalf("SaveFilter() failed: %v", err)
+	}
+	if stables.ID == 0 || len(stables.Slug) != slugLength || stables.Name != "Stables" {
+		t.Fatalf("SaveFilter() = %+v, want an ID, a slug and a trimmed name", stables)
+	}
+
+	// Saving under the same name replaces the query and keeps the short link
+	replaced := &models.SavedFilter{ProfileID: profile.ID, Name: "Stables", Query: "asset=USDT&min_apy=5"}
+	if err := db.SaveFilter(replaced); err != nil {
+		t.Fatalf("SaveFilter() replace failed: %v", err)
+	}
+	if replaced.ID != stables.ID || replaced.Slug != stables.Slug {
+		t.Errorf("SaveFilter() replace = %+v, want ID %d and slug %s", replaced, stables.ID, stables.Slug)
+	}
+
+	if err := db.SaveFilter(&models.SavedFilter{ProfileID: profile.ID, Name: "arbitrum", Query: "chain=Arbitrum"}); err != nil {
+		t.Fatalf("SaveFilter() failed: %v", err)
+	}
+	if err := db.SaveFilter(&models.SavedFilter{ProfileID: other.ID, Name: "Stables", Query: "asset=DAI"}); err != nil {
+		t.Fatalf("SaveFilter() for another profile failed: %v", err)
+	}
+	if err := db.SaveFilter(&models.SavedFilter{ProfileID: profile.ID, Name: "  "}); err == nil {
+		t.Error("SaveFilter() should require a name")
+	}
+
+	filters, err := db.GetSavedFilters(profile.ID)
+	if err != nil {
+		t.Fatalf("GetSavedFilters() failed: %v", err)
+	}
+	if len(filters) != 2 || filters[0].Name != "arbitrum" || filters[1].Query != "asset=USDT&min_apy=5" {
+		t.Errorf("GetSavedFilters() = %+v, want arbitrum then the replaced Stables", filters)
+	}
+
+	shared, err := db.GetSavedFilterBySlug(stables.Slug)
+	if err != nil {
+		t.Fatalf("GetSavedFilterBySlug() failed: %v", err)
+	}
+	if shared.ID != stables.ID || shared.Query != "asset=USDT&min_apy=5" {
+		t.Errorf("GetSavedFilterBySlug() = %+v, want filter %d", shared, stables.ID)
+	}
+
+	// Only the owning profile can delete a filter
+	if err := db.DeleteSavedFilter(other.ID, stables.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("DeleteSavedFilter() by another profile error = %v, want sql.ErrNoRows", err)
+	}
+	if err := db.DeleteSavedFilter(profile.ID, stables.ID); err != nil {
+		t.Fatalf("DeleteSavedFilter() failed: %v", err)
+	}
+	if _, err := db.GetSavedFilterBySlug(stables.Slug); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetSavedFilterBySlug() after delete error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+// TestWatchlist tests adding and removing watched rates
+func TestWatchlist(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	protocol := &models.Protocol{Name: "TestProtocol"}
+	db.CreateOrUpdateProtocol(protocol)
+
+	var ids []int64
+	for _, pool := range []string{"Pool-A", "Pool-B"} {
+		rate := &models.YieldRate{ProtocolID: protocol.ID, Asset: "USDC", Chain: "Ethereum", APY: 5, TVL: 1000, PoolName: pool}
+		if err := db.UpsertYieldRate(rate); err != nil {
+			t.Fatalf("UpsertYieldRate() failed: %v", err)
+		}
+		ids = append(ids, rate.ID)
+	}
+
+	profile, _ := db.CreateProfile()
+	other, _ := db.CreateProfile()
+
+	for _, id := range []int64{ids[1], ids[0], ids[1]} {
+		if err := db.AddToWatchlist(profile.ID, id); err != nil {
+			t.Fatalf("AddToWatchlist() failed: %v", err)
+		}
+	}
+
+	watched, err := db.GetWatchlist(profile.ID)
+	if err != nil {
+		t.Fatalf("GetWatchlist() failed: %v", err)
+	}
+	if len(watched) != 2 {
+		t.Errorf("GetWatchlist() = %v, want both rates once", watched)
+	}
+	if watched, _ := db.GetWatchlist(other.ID); len(watched) != 0 {
+		t.Errorf("GetWatchlist() of another profile = %v, want none", watched)
+	}
+
+	if err := db.RemoveFromWatchlist(profile.ID, ids[1]); err != nil {
+		t.Fatalf("RemoveFromWatchlist() failed: %v", err)
+	}
+	if err := db.RemoveFromWatchlist(profile.ID, ids[1]); err != nil {
+		t.Errorf("RemoveFromWatchlist() of an unwatched rate failed: %v", err)
+	}
+	watched, _ = db.GetWatchlist(profile.ID)
+	if len(watched) != 1 || watched[0] != ids[0] {
+		t.Errorf("GetWatchlist() after remove = %v, want [%d]", watched, ids[0])
+	}
+}
@@ -0,0 +1,380 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// ReplaceResult counts what ReplaceSourceRates did with the rates of a fetch cycle
+type ReplaceResult struct {
+	Inserted  int                 // Pools stored for the first time
+	Updated   int                 // Pools whose stored values changed
+	Unchanged int                 // Pools stored again with the same values
+	Changes   []models.RateChange // APY moves, as UpsertYieldRateChange reports them
+	Failed    []RateError         // Rates that couldn't be stored
+	Removed   int                 // Manual file rates deleted as no longer in the file
+}
+
+// Stored returns how many rates were written
+func (r *ReplaceResult) Stored() int {
+	return r.Inserted + r.Updated + r.Unchanged
+}
+
+// RateError is a rate ReplaceSourceRates couldn't store
+type RateError struct {
+	PoolName string
+	Chain    string
+	Err      error
+}
+
+func (e RateError) Error() string {
+	return fmt.Sprintf("store %s on %s: %v", e.PoolName, e.Chain, e.Err)
+}
+
+func (e RateError) Unwrap() error {
+	return e.Err
+}
+
+// ReplaceSourceRates stores the rates of one fetch cycle of source in a single
+// transaction, so readers see either all of them or none. A rate that can't
+// be stored, such as one overwriting a manual entry, is rolled back on its own
+// and reported in Failed; the others are still committed. Each rate gets its
+// ID and source set. The manual entries file holds every rate of its source,
+// so rates of that source missing from it are deleted
+func (db *DB) ReplaceSourceRates(source string, rates []models.YieldRate) (*ReplaceResult, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	w, err := newRateWriter(tx, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ReplaceResult{}
+	for i := range rates {
+		rate := &rates[i]
+		rate.Source = source
+
+		outcome, change, err := w.writeIsolated(rate)
+		if err != nil {
+			result.Failed = append(result.Failed, RateError{PoolName: rate.PoolName, Chain: rate.Chain, Err: err})
+			continue
+		}
+		switch outcome {
+		case rateInserted:
+			result.Inserted++
+		case rateUpdated:
+			result.Updated++
+		default:
+			result.Unchanged++
+		}
+		if change != nil {
+			result.Changes = append(result.Changes, *change)
+		}
+	}
+
+	if source == models.SourceManualFile {
+		if result.Removed, err = removeMissingRates(tx, source, rates); err != nil {
+			return nil, fmt.Errorf("failed to remove rates missing from the manual file: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// removeMissingRates deletes the rates of source that aren't among rates,
+// including those that failed to store, and returns how many it deleted
+func removeMissingRates(tx *txn, source string, rates []models.YieldRate) (int, error) {
+	type poolKey struct {
+		protocolID int64
+		pool       string
+		chain      string
+	}
+	keep := make(map[poolKey]bool, len(rates))
+	for _, rate := range rates {
+		keep[poolKey{rate.ProtocolID, rate.PoolName, rate.Chain}] = true
+	}
+
+	rows, err := tx.Query(`SELECT id, protocol_id, pool_name, chain FROM yield_rates WHERE source = ?`, source)
+	if err != nil {
+		return 0, err
+	}
+	var missing []int64
+	for rows.Next() {
+		var (
+			id  int64
+			key poolKey
+		)
+		if err := rows.Scan(&id, &key.protocolID, &key.pool, &key.chain); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if !keep[key] {
+			missing = append(missing, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, id := range missing {
+		if err := deleteRate(tx, id); err != nil {
+			return 0, err
+		}
+	}
+	return len(missing), nil
+}
+
+// rateTables are the tables with rows of a yield rate, by yield_rate_id
+var rateTables = []string{"yield_rewards", "yield_categories", "pendle_markets", "yield_history", "yield_history_rollups", "watchlist"}
+
+// deleteRate deletes a yield rate with its rows in rateTables, which the
+// foreign keys would cascade to unless they are disabled
+func deleteRate(tx *txn, id int64) error {
+	for _, table := range rateTables {
+		if _, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE yield_rate_id = ?`, table), id); err != nil {
+			return err
+		}
+	}
+	result, err := tx.Exec(`DELETE FROM yield_rates WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// rateOutcome is what writing a rate did to its row
+type rateOutcome int
+
+const (
+	rateInserted rateOutcome = iota
+	rateUpdated
+	rateUnchanged
+)
+
+// rateWriter upserts yield rates with statements prepared once per transaction
+type rateWriter struct {
+	tx  *txn
+	now time.Time
+
+	existing        *sql.Stmt
+	upsert          *sql.Stmt
+	observe         *sql.Stmt
+	clearRewards    *sql.Stmt
+	addReward       *sql.Stmt
+	clearCategories *sql.Stmt
+	addCategory     *sql.Stmt
+	upsertPendle    *sql.Stmt
+	clearPendle     *sql.Stmt
+}
+
+// newRateWriter prepares the upsert statements on tx. They are closed with
+// the transaction
+func newRateWriter(tx *txn, now time.Time) (*rateWriter, error) {
+	w := &rateWriter{tx: tx, now: now}
+	statements := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&w.existing, `
+			SELECT source, asset, apy, base_apy, reward_apy, tvl, maturity_date, external_url
+			FROM yield_rates
+			WHERE protocol_id = ? AND pool_name = ? AND chain = ?
+		`},
+		{&w.upsert, `
+			INSERT INTO yield_rates (protocol_id, asset, chain, apy, base_apy, reward_apy, tvl, maturity_date, pool_name, external_url, source, updated_at, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(protocol_id, pool_name, chain) DO UPDATE SET
+				asset = excluded.asset,
+				apy = excluded.apy,
+				base_apy = excluded.base_apy,
+				reward_apy = excluded.reward_apy,
+				tvl = excluded.tvl,
+				maturity_date = excluded.maturity_date,
+				external_url = excluded.external_url,
+				source = excluded.source,
+				updated_at = excluded.updated_at
+			RETURNING id
+		`},
+		{&w.observe, `INSERT INTO yield_history (yield_rate_id, observed_at, apy, tvl) VALUES (?, ?, ?, ?)`},
+		{&w.clearRewards, `DELETE FROM yield_rewards WHERE yield_rate_id = ?`},
+		{&w.addReward, `
+			INSERT INTO yield_rewards (yield_rate_id, token, apy) VALUES (?, ?, ?)
+			ON CONFLICT(yield_rate_id, token) DO UPDATE SET apy = yield_rewards.apy + excluded.apy
+		`},
+		{&w.clearCategories, `DELETE FROM yield_categories WHERE yield_rate_id = ?`},
+		{&w.addCategory, `INSERT INTO yield_categories (yield_rate_id, category) VALUES (?, ?) ON CONFLICT DO NOTHING`},
+		{&w.upsertPendle, `
+			INSERT INTO pendle_markets (yield_rate_id, address, pt, yt, sy, underlying_asset, fee_rate) VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(yield_rate_id) DO UPDATE SET
+				address = excluded.address,
+				pt = excluded.pt,
+				yt = excluded.yt,
+				sy = excluded.sy,
+				underlying_asset = excluded.underlying_asset,
+				fee_rate = excluded.fee_rate
+		`},
+		{&w.clearPendle, `DELETE FROM pendle_markets WHERE yield_rate_id = ?`},
+	}
+	for _, s := range statements {
+		stmt, err := tx.Prepare(s.query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare rate upsert: %w", err)
+		}
+		*s.stmt = stmt
+	}
+	return w, nil
+}
+
+// writeIsolated is write inside a savepoint, so a rate failing halfway
+// leaves nothing of it behind in the transaction
+func (w *rateWriter) writeIsolated(rate *models.YieldRate) (rateOutcome, *models.RateChange, error) {
+	if _, err := w.tx.Exec(`SAVEPOINT rate`); err != nil {
+		return 0, nil, err
+	}
+	outcome, change, err := w.write(rate)
+	if err != nil {
+		if _, rollbackErr := w.tx.Exec(`ROLLBACK TO rate`); rollbackErr != nil {
+			return 0, nil, fmt.Errorf("%w (rollback failed: %v)", err, rollbackErr)
+		}
+	}
+	if _, releaseErr := w.tx.Exec(`RELEASE rate`); releaseErr != nil && err == nil {
+		err = releaseErr
+	}
+	return outcome, change, err
+}
+
+// write upserts one rate with its history observation and rewards. Rates
+// entered manually can only be overwritten by the same manual source
+func (w *rateWriter) write(rate *models.YieldRate) (rateOutcome, *models.RateChange, error) {
+	var (
+		existing     models.YieldRate
+		maturityDate sql.NullTime
+	)
+	err := w.existing.QueryRow(rate.ProtocolID, rate.PoolName, rate.Chain).Scan(
+		&existing.Source,
+		&existing.Asset,
+		&existing.APY,
+		&existing.BaseAPY,
+		&existing.RewardAPY,
+		&existing.TVL,
+		&maturityDate,
+		&existing.ExternalURL,
+	)
+	found := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		return 0, nil, err
+	}
+	if maturityDate.Valid {
+		existing.MaturityDate = &maturityDate.Time
+	}
+	if found && models.IsManualSource(existing.Source) && rate.Source != existing.Source {
+		return 0, nil, ErrManualRate
+	}
+
+	if rate.MaturityDate != nil {
+		maturity := rate.MaturityDate.UTC()
+		rate.MaturityDate = &maturity
+	}
+	err = w.upsert.QueryRow(
+		rate.ProtocolID,
+		rate.Asset,
+		rate.Chain,
+		rate.APY,
+		rate.BaseAPY,
+		rate.RewardAPY,
+		rate.TVL,
+		rate.MaturityDate,
+		rate.PoolName,
+		rate.ExternalURL,
+		rate.Source,
+		w.now,
+		w.now,
+	).Scan(&rate.ID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if _, err := w.observe.Exec(rate.ID, w.now.Unix(), rate.APY, rate.TVL); err != nil {
+		return 0, nil, err
+	}
+	if _, err := w.clearRewards.Exec(rate.ID); err != nil {
+		return 0, nil, err
+	}
+	for _, reward := range rate.Rewards {
+		if _, err := w.addReward.Exec(rate.ID, reward.Token, reward.APY); err != nil {
+			return 0, nil, err
+		}
+	}
+	if err := w.writeMetadata(rate); err != nil {
+		return 0, nil, err
+	}
+
+	if !found {
+		return rateInserted, newRateChange(rate, rate.APY, models.DirectionNew), nil
+	}
+
+	var change *models.RateChange
+	switch {
+	case rate.APY-existing.APY >= apyChangeThreshold:
+		change = newRateChange(rate, existing.APY, models.DirectionUp)
+	case existing.APY-rate.APY >= apyChangeThreshold:
+		change = newRateChange(rate, existing.APY, models.DirectionDown)
+	}
+	if sameStoredValues(&existing, rate) {
+		return rateUnchanged, change, nil
+	}
+	return rateUpdated, change, nil
+}
+
+// writeMetadata replaces the categories and protocol metadata of a stored rate
+func (w *rateWriter) writeMetadata(rate *models.YieldRate) error {
+	if _, err := w.clearCategories.Exec(rate.ID); err != nil {
+		return err
+	}
+	for _, category := range rate.Categories {
+		if category = normalizeCategory(category); category == "" {
+			continue
+		}
+		if _, err := w.addCategory.Exec(rate.ID, category); err != nil {
+			return err
+		}
+	}
+
+	if rate.Pendle == nil {
+		_, err := w.clearPendle.Exec(rate.ID)
+		return err
+	}
+	market := rate.Pendle
+	_, err := w.upsertPendle.Exec(rate.ID, market.Address, market.PT, market.YT, market.SY, market.UnderlyingAsset, market.FeeRate)
+	return err
+}
+
+// sameStoredValues reports whether writing b over the stored row a changes
+// none of its columns, leaving aside updated_at, the reward breakdown and
+// the metadata
+func sameStoredValues(a, b *models.YieldRate) bool {
+	sameMaturity := a.MaturityDate == nil && b.MaturityDate == nil ||
+		a.MaturityDate != nil && b.MaturityDate != nil && a.MaturityDate.Equal(*b.MaturityDate)
+	return sameMaturity &&
+		a.Source == b.Source &&
+		a.Asset == b.Asset &&
+		a.APY == b.APY &&
+		a.BaseAPY == b.BaseAPY &&
+		a.RewardAPY == b.RewardAPY &&
+		a.TVL == b.TVL &&
+		a.ExternalURL == b.ExternalURL
+}
@@ -0,0 +1,138 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// TestReplaceSourceRates tests storing a fetch cycle and counting what changed
+func TestReplaceSourceRates(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	protocol := &models.Protocol{Name: "Pendle"}
+	db.CreateOrUpdateProtocol(protocol)
+
+	cycle := func(apys ...float64) []models.YieldRate {
+		var rates []models.YieldRate
+		for i, apy := range apys {
+			rates = append(rates, models.YieldRate{
+				ProtocolID: protocol.ID,
+				Asset:      "USDC",
+				Chain:      "Ethereum",
+				APY:        apy,
+				TVL:        1000000,
+				PoolName:   []string{"Pool-A", "Pool-B", "Pool-C"}[i],
+				Rewards:    []models.RewardAPY{{Token: "PENDLE", APY: 1}},
+			})
+		}
+		return rates
+	}
+
+	first := cycle(5, 6)
+	result, err := db.ReplaceSourceRates("pendle", first)
+	if err != nil {
+		t.Fatalf("ReplaceSourceRates() failed: %v", err)
+	}
+	if result.Inserted != 2 || result.Updated != 0 || result.Unchanged != 0 || len(result.Changes) != 2 {
+		t.Errorf("first cycle = %+v, want 2 inserted", result)
+	}
+	if first[0].ID == 0 || first[0].Source != "pendle" {
+		t.Errorf("stored rate = %+v, want its ID and source set", first[0])
+	}
+
+	result, err = db.ReplaceSourceRates("pendle", cycle(5, 7, 8))
+	if err != nil {
+		t.Fatalf("ReplaceSourceRates() failed: %v", err)
+	}
+	if result.Inserted != 1 || result.Updated != 1 || result.Unchanged != 1 || result.Stored() != 3 {
+		t.Errorf("second cycle = %+v, want 1 inserted, 1 updated and 1 unchanged", result)
+	}
+	if len(result.Changes) != 2 || result.Changes[0].Direction != models.DirectionUp || result.Changes[1].Direction != models.DirectionNew {
+		t.Errorf("second cycle changes = %+v, want Pool-B up and Pool-C new", result.Changes)
+	}
+
+	rates, _ := db.GetYieldRates(models.FilterParams{SortBy: "apy", SortOrder: "desc"})
+	if len(rates) != 3 {
+		t.Fatalf("GetYieldRates() returned %d rates, want 3 without duplicates", len(rates))
+	}
+	if len(rates[0].Rewards) != 1 || rates[0].Rewards[0].APY != 1 {
+		t.Errorf("rewards = %+v, want one PENDLE reward replaced each cycle", rates[0].Rewards)
+	}
+}
+
+// TestReplaceSourceRates_Failures tests that a rate that can't be stored
+// doesn't keep the others from being committed
+func TestReplaceSourceRates_Failures(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	manual := &models.YieldRate{Asset: "USDC", Chain: "Off-chain", APY: 9, PoolName: "Desk"}
+	if err := db.CreateManualRate(manual); err != nil {
+		t.Fatalf("CreateManualRate() failed: %v", err)
+	}
+
+	rates := []models.YieldRate{
+		{ProtocolID: manual.ProtocolID, Asset: "USDC", Chain: "Off-chain", APY: 1, PoolName: "Desk"},
+		{ProtocolID: manual.ProtocolID, Asset: "DAI", Chain: "Off-chain", APY: 4, PoolName: "Other"},
+	}
+	result, err := db.ReplaceSourceRates("llama", rates)
+	if err != nil {
+		t.Fatalf("ReplaceSourceRates() failed: %v", err)
+	}
+	if len(result.Failed) != 1 || !errors.Is(result.Failed[0], ErrManualRate) || result.Inserted != 1 {
+		t.Errorf("result = %+v, want the manual pool failed and the other inserted", result)
+	}
+
+	stored, err := db.GetYieldRate(manual.ID)
+	if err != nil || stored.APY != 9 {
+		t.Errorf("manual rate = %+v (err %v), want it untouched", stored, err)
+	}
+}
+
+// TestEnsureUniquePools tests that duplicate pools from older databases are
+// merged before the unique index is created
+func TestEnsureUniquePools(t *testing.T) {
+	dbPath := "test_defirates_" + t.Name() + ".db"
+	defer os.Remove(dbPath)
+
+	db, err := New(dbPath)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	protocol := &models.Protocol{Name: "Pendle"}
+	db.CreateOrUpdateProtocol(protocol)
+	rate := &models.YieldRate{ProtocolID: protocol.ID, Asset: "ETH", Chain: "Ethereum", APY: 3, PoolName: "Pool"}
+	db.UpsertYieldRate(rate)
+
+	// Recreate the schema from before the unique index, with a duplicate
+	db.conn.Exec(`DROP INDEX idx_yield_rates_pool`)
+	_, err = db.conn.Exec(
+		`INSERT INTO yield_rates (protocol_id, asset, chain, apy, tvl, pool_name) VALUES (?, 'ETH', 'Ethereum', 4, 0, 'Pool')`,
+		protocol.ID,
+	)
+	if err != nil {
+		t.Fatalf("failed to insert duplicate: %v", err)
+	}
+	db.Close()
+
+	db, err = New(dbPath)
+	if err != nil {
+		t.Fatalf("New() over duplicate pools failed: %v", err)
+	}
+	defer db.Close()
+
+	rates, _ := db.GetYieldRates(models.FilterParams{})
+	if len(rates) != 1 || rates[0].ID != rate.ID {
+		t.Errorf("rates after migration = %+v, want only the original row", rates)
+	}
+	if _, err := db.conn.Exec(
+		`INSERT INTO yield_rates (protocol_id, asset, chain, apy, tvl, pool_name) VALUES (?, 'ETH', 'Ethereum', 4, 0, 'Pool')`,
+		protocol.ID,
+	); err == nil {
+		t.Error("the unique index should reject duplicate pools")
+	}
+}
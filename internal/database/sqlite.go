@@ -0,0 +1,219 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Options tunes how the database is opened. Only MaxReaders applies to
+// PostgreSQL, where it bounds the connection pool
+type Options struct {
+	// JournalMode is the SQLite journal mode. In "wal" mode readers don't
+	// block the writer and the writer doesn't block readers. Empty keeps the
+	// mode the database already has
+	JournalMode string
+	// BusyTimeout is how long a statement waits for a lock held by another
+	// connection before failing with "database is locked"
+	BusyTimeout time.Duration
+	// ForeignKeys makes SQLite enforce the foreign keys of the schema
+	ForeignKeys bool
+	// MaxReaders is the size of the read-only connection pool. Writes always
+	// go through a single connection, as SQLite allows one writer at a time
+	MaxReaders int
+	// MaintenanceInterval is how often StartMaintenance compacts the history
+	// and runs Maintain
+	MaintenanceInterval time.Duration
+	// VacuumInterval is how often StartMaintenance runs Vacuum to return the
+	// space of compacted history to the file system (0 never vacuums)
+	VacuumInterval time.Duration
+	// Retention is how long history is kept at each resolution
+	Retention Retention
+}
+
+// DefaultOptions returns the options New opens databases with
+func DefaultOptions() Options {
+	return Options{
+		JournalMode:         "wal",
+		BusyTimeout:         5 * time.Second,
+		ForeignKeys:         true,
+		MaxReaders:          4,
+		MaintenanceInterval: time.Hour,
+		VacuumInterval:      7 * 24 * time.Hour,
+		Retention:           DefaultRetention(),
+	}
+}
+
+// journalModes are the journal modes SQLite accepts
+var journalModes = []string{"wal", "delete", "truncate", "persist", "memory", "off"}
+
+// validate checks the options and normalises the journal mode
+func (o *Options) validate() error {
+	o.JournalMode = strings.ToLower(strings.TrimSpace(o.JournalMode))
+	if o.JournalMode != "" && !slices.Contains(journalModes, o.JournalMode) {
+		return fmt.Errorf("unknown journal mode %q (want one of %s)", o.JournalMode, strings.Join(journalModes, ", "))
+	}
+	if o.BusyTimeout < 0 {
+		return fmt.Errorf("busy timeout must not be negative")
+	}
+	if o.MaxReaders < 1 {
+		return fmt.Errorf("max readers must be at least 1")
+	}
+	if o.VacuumInterval < 0 {
+		return fmt.Errorf("vacuum interval must not be negative")
+	}
+	return o.Retention.validate()
+}
+
+// dsn returns the go-sqlite3 data source name for path. Writer connections
+// take their lock when a transaction begins, so two transactions never
+// deadlock upgrading read locks; reader connections refuse to write
+func (o Options) dsn(path string, writer bool) string {
+	params := url.Values{}
+	params.Set("_busy_timeout", strconv.FormatInt(o.BusyTimeout.Milliseconds(), 10))
+	params.Set("_foreign_keys", strconv.FormatBool(o.ForeignKeys))
+	if writer {
+		if o.JournalMode != "" {
+			params.Set("_journal_mode", o.JournalMode)
+		}
+		params.Set("_txlock", "immediate")
+		if o.JournalMode == "wal" {
+			// Durable across application crashes; a power loss can only
+			// lose the last transactions, never corrupt the database
+			params.Set("_synchronous", "NORMAL")
+		}
+	} else {
+		params.Set("_query_only", "true")
+	}
+	return path + "?" + params.Encode()
+}
+
+// openSQLite opens the SQLite database at path: a single writer connection
+// and a pool of read-only connections
+func openSQLite(path string, options Options) (*DB, error) {
+	conn, err := sql.Open("sqlite3", options.dsn(path, true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	conn.SetMaxOpenConns(1)
+
+	// The writer creates the file and sets the journal mode before any reader opens it
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	read, err := sql.Open("sqlite3", options.dsn(path, false))
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	read.SetMaxOpenConns(options.MaxReaders)
+	read.SetMaxIdleConns(options.MaxReaders)
+
+	return &DB{
+		conn:    &pool{DB: conn, dialect: dialectSQLite},
+		read:    &pool{DB: read, dialect: dialectSQLite},
+		dialect: dialectSQLite,
+		options: options,
+	}, nil
+}
+
+// Maintain runs PRAGMA optimize so the query planner has current statistics
+// and, in WAL mode, checkpoints the write-ahead log back to its minimum size.
+// On PostgreSQL it runs ANALYZE; autovacuum does the rest
+func (db *DB) Maintain() error {
+	if db.dialect == dialectPostgres {
+		if _, err := db.conn.Exec(`ANALYZE`); err != nil {
+			return fmt.Errorf("analyze: %w", err)
+		}
+		return nil
+	}
+
+	if _, err := db.conn.Exec(`PRAGMA optimize`); err != nil {
+		return fmt.Errorf("optimize: %w", err)
+	}
+	if db.options.JournalMode != "wal" {
+		return nil
+	}
+
+	var busy, logPages, checkpointed int
+	if err := db.conn.QueryRow(`PRAGMA wal_checkpoint(TRUNCATE)`).Scan(&busy, &logPages, &checkpointed); err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	slog.Debug("database maintained", "checkpoint_busy", busy == 1, "wal_pages", logPages, "checkpointed_pages", checkpointed)
+	return nil
+}
+
+// StartMaintenance compacts the history and runs Maintain every
+// MaintenanceInterval, and Vacuum once every VacuumInterval, until ctx is
+// done. It does nothing when the maintenance interval is zero
+func (db *DB) StartMaintenance(ctx context.Context) {
+	interval := db.options.MaintenanceInterval
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		lastVacuum := time.Now()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if result, err := db.CompactHistory(now); err != nil {
+					slog.Warn("history compaction failed", "error", err)
+				} else if result.Compacted() > 0 {
+					slog.Info("history compacted", "raw", result.Raw, "hourly", result.Hourly, "daily_dropped", result.DailyDropped)
+				}
+				if err := db.Maintain(); err != nil {
+					slog.Warn("database maintenance failed", "error", err)
+				}
+				if db.options.VacuumInterval > 0 && now.Sub(lastVacuum) >= db.options.VacuumInterval {
+					lastVacuum = now
+					if err := db.Vacuum(); err != nil {
+						slog.Warn("database vacuum failed", "error", err)
+					}
+				}
+			}
+		}
+	}()
+}
+
+// checkForeignKeys logs rows that break a foreign key. SQLite only enforces
+// foreign keys on new writes, so databases from before they were enforced may
+// hold such rows
+func (db *DB) checkForeignKeys() error {
+	rows, err := db.conn.Query(`PRAGMA foreign_key_check`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	violations := map[string]int{}
+	for rows.Next() {
+		var table, parent string
+		var rowID, fkID interface{}
+		if err := rows.Scan(&table, &rowID, &parent, &fkID); err != nil {
+			return err
+		}
+		violations[table]++
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for table, count := range violations {
+		slog.Warn("rows reference missing parents", "table", table, "rows", count)
+	}
+	return nil
+}
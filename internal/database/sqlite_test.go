@@ -0,0 +1,103 @@
+package database
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// TestOpen_Options tests the connection settings of a new database
+func TestOpen_Options(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var journalMode string
+	if err := db.conn.QueryRow(`PRAGMA journal_mode`).Scan(&journalMode); err != nil || journalMode != "wal" {
+		t.Errorf("journal_mode = %q (err %v), want wal", journalMode, err)
+	}
+	var timeout, foreignKeys int
+	db.read.QueryRow(`PRAGMA busy_timeout`).Scan(&timeout)
+	db.read.QueryRow(`PRAGMA foreign_keys`).Scan(&foreignKeys)
+	if timeout != 5000 || foreignKeys != 1 {
+		t.Errorf("reader busy_timeout = %d, foreign_keys = %d, want 5000 and 1", timeout, foreignKeys)
+	}
+
+	// Foreign keys are enforced
+	if err := db.AddToWatchlist(1, 12345); err == nil {
+		t.Error("watching a rate that doesn't exist should fail")
+	}
+	// Readers can't write
+	if _, err := db.read.Exec(`DELETE FROM protocols`); err == nil {
+		t.Error("the read pool should refuse writes")
+	}
+
+	if err := db.Maintain(); err != nil {
+		t.Errorf("Maintain() failed: %v", err)
+	}
+}
+
+// TestOpen_ReadsDuringWrite tests that reads don't wait for an open write transaction
+func TestOpen_ReadsDuringWrite(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	protocol := &models.Protocol{Name: "Pendle"}
+	db.CreateOrUpdateProtocol(protocol)
+	db.UpsertYieldRate(&models.YieldRate{ProtocolID: protocol.ID, Asset: "ETH", Chain: "Ethereum", APY: 3, PoolName: "Pool-1"})
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		t.Fatalf("Begin() failed: %v", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`UPDATE yield_rates SET apy = 99`); err != nil {
+		t.Fatalf("failed to update rates: %v", err)
+	}
+
+	done := make(chan []models.YieldRate)
+	go func() {
+		rates, _ := db.GetYieldRates(models.FilterParams{})
+		done <- rates
+	}()
+	select {
+	case rates := <-done:
+		if len(rates) != 1 || rates[0].APY != 3 {
+			t.Errorf("rates during a write = %+v, want the committed APY 3", rates)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("GetYieldRates() waited for the write transaction")
+	}
+}
+
+// TestOpen_InvalidOptions tests that bad options are rejected
+func TestOpen_InvalidOptions(t *testing.T) {
+	dbPath := "test_defirates_" + t.Name() + ".db"
+	defer os.Remove(dbPath)
+
+	for name, modify := range map[string]func(*Options){
+		"journal mode": func(o *Options) { o.JournalMode = "fast" },
+		"busy timeout": func(o *Options) { o.BusyTimeout = -time.Second },
+		"readers":      func(o *Options) { o.MaxReaders = 0 },
+	} {
+		options := DefaultOptions()
+		modify(&options)
+		if db, err := Open(dbPath, options); err == nil {
+			db.Close()
+			t.Errorf("Open() with an invalid %s should fail", name)
+		}
+	}
+
+	// Other journal modes work too
+	options := DefaultOptions()
+	options.JournalMode = "DELETE"
+	db, err := Open(dbPath, options)
+	if err != nil {
+		t.Fatalf("Open() in delete mode failed: %v", err)
+	}
+	defer db.Close()
+	if err := db.Maintain(); err != nil {
+		t.Errorf("Maintain() failed: %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// Store is the storage the handlers and the fetcher work against. DB
+// implements it on SQLite and PostgreSQL; which one is picked by the DSN
+// given to Open
+type Store interface {
+	Close() error
+	Backend() string
+	Migrations() ([]Migration, error)
+	Maintain() error
+	StartMaintenance(ctx context.Context)
+	CompactHistory(now time.Time) (*CompactResult, error)
+	Vacuum() error
+	Backup(ctx context.Context, path string) error
+	Restore(ctx context.Context, path string) error
+	StartBackups(ctx context.Context, dir string, interval time.Duration, keep int) error
+
+	// Protocols and yield rates
+	CreateOrUpdateProtocol(protocol *models.Protocol) error
+	GetProtocolByName(name string) (*models.Protocol, error)
+	UpsertYieldRate(rate *models.YieldRate) error
+	UpsertYieldRateChange(rate *models.YieldRate) (*models.RateChange, error)
+	ReplaceSourceRates(source string, rates []models.YieldRate) (*ReplaceResult, error)
+	GetYieldRates(filters models.FilterParams) ([]models.YieldRate, error)
+	GetYieldRate(id int64) (*models.YieldRate, error)
+	GetDistinctAssets() ([]string, error)
+	GetDistinctChains() ([]string, error)
+	GetDistinctCategories() ([]string, error)
+	RatesVersion() (string, error)
+	GetYieldHistory(id int64, from, to time.Time) (*models.YieldHistory, error)
+
+	// Fetch runs
+	CreateFetchRun(run *models.FetchRun) error
+	FinishFetchRun(run *models.FetchRun) error
+	GetFetchRuns(source string, limit int) ([]models.FetchRun, error)
+
+	// Manual rates
+	CreateManualRate(rate *models.YieldRate) error
+	UpdateManualRate(rate *models.YieldRate) error
+	DeleteManualRate(id int64) error
+	GetManualRates() ([]models.YieldRate, error)
+
+	// Profiles, saved filters and watchlists
+	CreateProfile() (*models.Profile, error)
+	GetProfileByToken(token string) (*models.Profile, error)
+	GetUserProfile(userID int64) (*models.Profile, error)
+	ProfileForUser(userID int64, anonymous *models.Profile) (*models.Profile, error)
+	SaveFilter(filter *models.SavedFilter) error
+	GetSavedFilters(profileID int64) ([]models.SavedFilter, error)
+	GetSavedFilterBySlug(slug string) (*models.SavedFilter, error)
+	DeleteSavedFilter(profileID, id int64) error
+	AddToWatchlist(profileID, yieldRateID int64) error
+	RemoveFromWatchlist(profileID, yieldRateID int64) error
+	GetWatchlist(profileID int64) ([]int64, error)
+
+	// Users, sessions and API keys
+	CreateUser(user *models.User, password string) error
+	GetUser(id int64) (*models.User, error)
+	GetUserByUsername(username string) (*models.User, error)
+	GetUsers() ([]models.User, error)
+	SetPassword(userID int64, password string) error
+	DeleteUser(userID int64) error
+	AuthenticateUser(username, password string) (*models.User, error)
+	CreateSession(userID int64, ttl time.Duration) (string, error)
+	GetSessionUser(token string) (*models.User, error)
+	DeleteSession(token string) error
+	CreateAdminSession(adminToken string, ttl time.Duration) (string, error)
+	IsAdminSession(token, adminToken string) (bool, error)
+	DeleteAdminSession(token string) error
+	CreateAPIKey(key *models.APIKey) (string, error)
+	GetAPIKeys(userID int64) ([]models.APIKey, error)
+	DeleteAPIKey(userID, id int64) error
+	AuthenticateAPIKey(secret string) (*models.User, *models.APIKey, error)
+}
+
+var _ Store = (*DB)(nil)
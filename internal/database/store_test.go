@@ -0,0 +1,266 @@
+package database
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TestStore_SQLite runs the Store conformance suite on SQLite
+func TestStore_SQLite(t *testing.T) {
+	runStoreConformance(t, func(t *testing.T) Store {
+		db, err := New(filepath.Join(t.TempDir(), "defirates.db"))
+		if err != nil {
+			t.Fatalf("New() failed: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+		return db
+	})
+}
+
+// TestStore_Postgres runs the Store conformance suite on the PostgreSQL
+// database of DEFIRATES_TEST_POSTGRES_DSN. Each subtest gets an empty schema
+func TestStore_Postgres(t *testing.T) {
+	dsn := os.Getenv("DEFIRATES_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("DEFIRATES_TEST_POSTGRES_DSN not set")
+	}
+
+	admin, err := Open(dsn, DefaultOptions())
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	defer admin.Close()
+
+	runStoreConformance(t, func(t *testing.T) Store {
+		schema := "defirates_test_" + url.PathEscape(filepath.Base(t.Name()))
+		reset := func() {
+			if _, err := admin.conn.Exec(`DROP SCHEMA IF EXISTS "` + schema + `" CASCADE`); err != nil {
+				t.Fatalf("failed to drop schema: %v", err)
+			}
+		}
+		reset()
+		if _, err := admin.conn.Exec(`CREATE SCHEMA "` + schema + `"`); err != nil {
+			t.Fatalf("failed to create schema: %v", err)
+		}
+
+		u, err := url.Parse(dsn)
+		if err != nil {
+			t.Fatalf("invalid DSN: %v", err)
+		}
+		query := u.Query()
+		query.Set("search_path", schema)
+		u.RawQuery = query.Encode()
+
+		db, err := Open(u.String(), DefaultOptions())
+		if err != nil {
+			t.Fatalf("Open() failed: %v", err)
+		}
+		t.Cleanup(func() {
+			db.Close()
+			reset()
+		})
+		return db
+	})
+}
+
+// runStoreConformance checks the behaviour every Store must share, against
+// a fresh store from open for each subtest
+func runStoreConformance(t *testing.T, open func(t *testing.T) Store) {
+	bcryptCost = bcrypt.MinCost
+	t.Cleanup(func() { bcryptCost = bcrypt.DefaultCost })
+
+	t.Run("rates", func(t *testing.T) {
+		store := open(t)
+
+		protocol := &models.Protocol{Name: "Pendle", URL: "https://pendle.finance"}
+		if err := store.CreateOrUpdateProtocol(protocol); err != nil {
+			t.Fatalf("CreateOrUpdateProtocol() failed: %v", err)
+		}
+		before, err := store.RatesVersion()
+		if err != nil {
+			t.Fatalf("RatesVersion() failed: %v", err)
+		}
+
+		maturity := time.Date(2027, 6, 24, 0, 0, 0, 0, time.UTC)
+		market := &models.PendleMarket{Address: "0xmarket", PT: "0xpt", YT: "0xyt", SY: "0xsy", UnderlyingAsset: "0xusdc"}
+		rates := []models.YieldRate{
+			{ProtocolID: protocol.ID, Asset: "USDC", Chain: "Ethereum", APY: 5, TVL: 1000, PoolName: "PT-USDC", MaturityDate: &maturity,
+				Rewards:    []models.RewardAPY{{Token: "PENDLE", APY: 1}, {Token: "PENDLE", APY: 0.5}},
+				Categories: []string{"Stables", "points", "stables"}, Pendle: market},
+			{ProtocolID: protocol.ID, Asset: "ETH", Chain: "Arbitrum", APY: 3, TVL: 5000, PoolName: "PT-ETH"},
+		}
+		result, err := store.ReplaceSourceRates("pendle", rates)
+		if err != nil || result.Inserted != 2 {
+			t.Fatalf("ReplaceSourceRates() = %+v, %v, want 2 inserted", result, err)
+		}
+		if after, _ := store.RatesVersion(); after == before {
+			t.Error("RatesVersion() should change when rates are stored")
+		}
+
+		got, err := store.GetYieldRates(models.FilterParams{SortBy: "tvl", SortOrder: "asc"})
+		if err != nil {
+			t.Fatalf("GetYieldRates() failed: %v", err)
+		}
+		if len(got) != 2 || got[0].PoolName != "PT-USDC" || got[0].ProtocolName != "Pendle" {
+			t.Fatalf("GetYieldRates() = %+v, want PT-USDC then PT-ETH", got)
+		}
+		if got[0].MaturityDate == nil || !got[0].MaturityDate.Equal(maturity) {
+			t.Errorf("maturity = %v, want %v", got[0].MaturityDate, maturity)
+		}
+		if len(got[0].Rewards) != 1 || got[0].Rewards[0].APY != 1.5 {
+			t.Errorf("rewards = %+v, want the PENDLE rewards summed", got[0].Rewards)
+		}
+
+		if len(got[0].Categories) != 2 || got[0].Categories[0] != "points" || got[0].Categories[1] != "stables" {
+			t.Errorf("categories = %v, want [points stables]", got[0].Categories)
+		}
+		if got[0].Pendle == nil || *got[0].Pendle != *market || got[1].Pendle != nil {
+			t.Errorf("Pendle metadata = %+v and %+v, want it on PT-USDC only", got[0].Pendle, got[1].Pendle)
+		}
+		if stables, _ := store.GetYieldRates(models.FilterParams{Category: "STABLES"}); len(stables) != 1 || stables[0].Asset != "USDC" {
+			t.Errorf("rates in stables = %+v, want PT-USDC", stables)
+		}
+		if categories, _ := store.GetDistinctCategories(); len(categories) != 2 {
+			t.Errorf("GetDistinctCategories() = %v, want [points stables]", categories)
+		}
+
+		filtered, _ := store.GetYieldRates(models.FilterParams{Chain: "Arbitrum", MinAPY: 2})
+		if len(filtered) != 1 || filtered[0].Asset != "ETH" {
+			t.Errorf("filtered rates = %+v, want PT-ETH", filtered)
+		}
+		if _, err := store.GetYieldRate(12345); err == nil {
+			t.Error("GetYieldRate() of a missing rate should fail")
+		}
+		if assets, _ := store.GetDistinctAssets(); len(assets) != 2 || assets[0] != "ETH" {
+			t.Errorf("GetDistinctAssets() = %v, want [ETH USDC]", assets)
+		}
+		if chains, _ := store.GetDistinctChains(); len(chains) != 2 || chains[0] != "Arbitrum" {
+			t.Errorf("GetDistinctChains() = %v, want [Arbitrum Ethereum]", chains)
+		}
+
+		rate := rates[1]
+		rate.APY = 4
+		change, err := store.UpsertYieldRateChange(&rate)
+		if err != nil || change == nil || change.Direction != models.DirectionUp {
+			t.Errorf("UpsertYieldRateChange() = %+v, %v, want an upward change", change, err)
+		}
+	})
+
+	t.Run("manual rates", func(t *testing.T) {
+		store := open(t)
+
+		rate := &models.YieldRate{Asset: "USDC", Chain: "Off-chain", APY: 9, PoolName: "Desk"}
+		if err := store.CreateManualRate(rate); err != nil {
+			t.Fatalf("CreateManualRate() failed: %v", err)
+		}
+		result, err := store.ReplaceSourceRates("llama", []models.YieldRate{
+			{ProtocolID: rate.ProtocolID, Asset: "USDC", Chain: "Off-chain", APY: 1, PoolName: "Desk"},
+		})
+		if err != nil || len(result.Failed) != 1 || !errors.Is(result.Failed[0], ErrManualRate) {
+			t.Errorf("overwriting a manual rate = %+v, %v, want ErrManualRate", result, err)
+		}
+
+		rate.APY = 10
+		if err := store.UpdateManualRate(rate); err != nil {
+			t.Fatalf("UpdateManualRate() failed: %v", err)
+		}
+		if manual, _ := store.GetManualRates(); len(manual) != 1 || manual[0].APY != 10 {
+			t.Errorf("GetManualRates() = %+v, want the updated rate", manual)
+		}
+		if err := store.DeleteManualRate(rate.ID); err != nil {
+			t.Errorf("DeleteManualRate() failed: %v", err)
+		}
+	})
+
+	t.Run("fetch runs", func(t *testing.T) {
+		store := open(t)
+
+		run := &models.FetchRun{Source: "Pendle", Status: models.FetchRunRunning, StartedAt: time.Now()}
+		if err := store.CreateFetchRun(run); err != nil {
+			t.Fatalf("CreateFetchRun() failed: %v", err)
+		}
+		finished := run.StartedAt.Add(time.Second)
+		run.FinishedAt = &finished
+		run.Status = models.FetchRunSuccess
+		run.Inserted, run.Errors = 3, []string{"timeout"}
+		if err := store.FinishFetchRun(run); err != nil {
+			t.Fatalf("FinishFetchRun() failed: %v", err)
+		}
+		runs, err := store.GetFetchRuns("Pendle", 10)
+		if err != nil || len(runs) != 1 || runs[0].Inserted != 3 || len(runs[0].Errors) != 1 {
+			t.Errorf("GetFetchRuns() = %+v, %v, want the finished run", runs, err)
+		}
+	})
+
+	t.Run("profiles", func(t *testing.T) {
+		store := open(t)
+
+		profile, err := store.CreateProfile()
+		if err != nil {
+			t.Fatalf("CreateProfile() failed: %v", err)
+		}
+		filter := &models.SavedFilter{ProfileID: profile.ID, Name: "stables", Query: "asset=USDC"}
+		if err := store.SaveFilter(filter); err != nil {
+			t.Fatalf("SaveFilter() failed: %v", err)
+		}
+		shared, err := store.GetSavedFilterBySlug(filter.Slug)
+		if err != nil || shared.Query != "asset=USDC" {
+			t.Errorf("GetSavedFilterBySlug() = %+v, %v", shared, err)
+		}
+
+		rate := &models.YieldRate{Asset: "USDC", Chain: "Off-chain", APY: 9}
+		store.CreateManualRate(rate)
+		for i := 0; i < 2; i++ {
+			if err := store.AddToWatchlist(profile.ID, rate.ID); err != nil {
+				t.Fatalf("AddToWatchlist() failed: %v", err)
+			}
+		}
+		if ids, _ := store.GetWatchlist(profile.ID); len(ids) != 1 {
+			t.Errorf("GetWatchlist() = %v, want the rate once", ids)
+		}
+		if err := store.AddToWatchlist(profile.ID, 12345); err == nil {
+			t.Error("watching a rate that doesn't exist should fail")
+		}
+	})
+
+	t.Run("users", func(t *testing.T) {
+		store := open(t)
+
+		user := &models.User{Username: "Alice", Scopes: []string{models.ScopeRead}}
+		if err := store.CreateUser(user, "correct horse"); err != nil {
+			t.Fatalf("CreateUser() failed: %v", err)
+		}
+		if err := store.CreateUser(&models.User{Username: "alice", Scopes: []string{models.ScopeRead}}, "correct horse"); err == nil {
+			t.Error("usernames should be unique regardless of case")
+		}
+		if _, err := store.AuthenticateUser("ALICE", "correct horse"); err != nil {
+			t.Errorf("AuthenticateUser() failed: %v", err)
+		}
+
+		token, err := store.CreateSession(user.ID, time.Hour)
+		if err != nil {
+			t.Fatalf("CreateSession() failed: %v", err)
+		}
+		secret, err := store.CreateAPIKey(&models.APIKey{UserID: user.ID, Name: "ci", Scopes: []string{models.ScopeRead}})
+		if err != nil {
+			t.Fatalf("CreateAPIKey() failed: %v", err)
+		}
+		if _, key, err := store.AuthenticateAPIKey(secret); err != nil || key.Name != "ci" {
+			t.Errorf("AuthenticateAPIKey() = %+v, %v", key, err)
+		}
+
+		if err := store.DeleteUser(user.ID); err != nil {
+			t.Fatalf("DeleteUser() failed: %v", err)
+		}
+		if _, err := store.GetSessionUser(token); err == nil {
+			t.Error("sessions should go with their user")
+		}
+	})
+}
@@ -0,0 +1,450 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrInvalidCredentials is returned for an unknown username, a wrong
+	// password or an unknown API key
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrScopeNotAllowed is returned when an API key asks for more than its user has
+	ErrScopeNotAllowed = errors.New("scope exceeds the user's scopes")
+)
+
+// MinPasswordLength is the shortest password CreateUser and SetPassword accept
+const MinPasswordLength = 8
+
+// APIKeyPrefix starts every API key, so keys are easy to recognise in
+// headers and secret scanners
+const APIKeyPrefix = "drk_"
+
+// bcryptCost is a variable so tests can trade strength for speed
+var bcryptCost = bcrypt.DefaultCost
+
+// dummyHash is compared against when a username is unknown, so the
+// response time doesn't reveal which usernames exist. It is generated on
+// first use with the cost of real hashes
+var dummyHash struct {
+	sync.Mutex
+	cost int
+	hash []byte
+}
+
+// dummyPasswordHash returns the dummy hash at bcryptCost
+func dummyPasswordHash() []byte {
+	dummyHash.Lock()
+	defer dummyHash.Unlock()
+	if dummyHash.hash == nil || dummyHash.cost != bcryptCost {
+		dummyHash.hash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcryptCost)
+		dummyHash.cost = bcryptCost
+	}
+	return dummyHash.hash
+}
+
+// hashSecret hashes a high-entropy token for storage. Session tokens and API
+// keys are random, so a fast hash is enough
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomToken returns n random bytes encoded for use in cookies and headers
+func randomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashPassword validates and hashes a password with bcrypt
+func hashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CreateUser creates a user with the given password and sets its ID
+func (db *DB) CreateUser(user *models.User, password string) error {
+	user.Username = strings.TrimSpace(user.Username)
+	if user.Username == "" {
+		return fmt.Errorf("username is required")
+	}
+	if len(user.Scopes) == 0 {
+		return fmt.Errorf("at least one scope is required")
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return db.conn.QueryRow(
+		`INSERT INTO users (username, password_hash, scopes) VALUES (?, ?, ?) RETURNING id, created_at`,
+		user.Username, hash, strings.Join(user.Scopes, ","),
+	).Scan(&user.ID, &user.CreatedAt)
+}
+
+// userColumns are the columns scanned by scanUser
+const userColumns = `users.id, users.username, users.scopes, users.created_at`
+
+// scanUser scans a row of userColumns
+func scanUser(row interface{ Scan(...interface{}) error }) (*models.User, error) {
+	var user models.User
+	var scopes string
+	if err := row.Scan(&user.ID, &user.Username, &scopes, &user.CreatedAt); err != nil {
+		return nil, err
+	}
+	user.Scopes = strings.Split(scopes, ",")
+	return &user, nil
+}
+
+// GetUser returns the user with the given ID, or sql.ErrNoRows
+func (db *DB) GetUser(id int64) (*models.User, error) {
+	return scanUser(db.read.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, id))
+}
+
+// GetUserByUsername returns the user with the given username, ignoring case,
+// or sql.ErrNoRows if there is none
+func (db *DB) GetUserByUsername(username string) (*models.User, error) {
+	return scanUser(db.read.QueryRow(`SELECT `+userColumns+` FROM users WHERE lower(username) = lower(?)`, username))
+}
+
+// GetUsers returns every user by username
+func (db *DB) GetUsers() ([]models.User, error) {
+	rows, err := db.read.Query(`SELECT ` + userColumns + ` FROM users ORDER BY lower(username)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	return users, rows.Err()
+}
+
+// SetPassword replaces a user's password and logs out its sessions
+func (db *DB) SetPassword(userID int64, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.conn.Exec(`UPDATE users SET password_hash = ? WHERE id = ?`, hash, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	_, err = db.conn.Exec(`DELETE FROM sessions WHERE user_id = ?`, userID)
+	return err
+}
+
+// DeleteUser deletes a user with its sessions and API keys. Its profile is
+// kept as an anonymous one
+func (db *DB) DeleteUser(userID int64) error {
+	for _, query := range []string{
+		`DELETE FROM sessions WHERE user_id = ?`,
+		`DELETE FROM api_keys WHERE user_id = ?`,
+		`UPDATE profiles SET user_id = NULL WHERE user_id = ?`,
+	} {
+		if _, err := db.conn.Exec(query, userID); err != nil {
+			return err
+		}
+	}
+
+	result, err := db.conn.Exec(`DELETE FROM users WHERE id = ?`, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// AuthenticateUser checks a username and password, returning
+// ErrInvalidCredentials if either is wrong
+func (db *DB) AuthenticateUser(username, password string) (*models.User, error) {
+	var id int64
+	var hash string
+	err := db.conn.QueryRow(`SELECT id, password_hash FROM users WHERE lower(username) = lower(?)`, username).Scan(&id, &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return db.GetUser(id)
+}
+
+// CreateSession starts a session for a user and returns its token, which is
+// only stored hashed. Expired sessions are cleaned up on the way
+func (db *DB) CreateSession(userID int64, ttl time.Duration) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate session token: %w", err)
+	}
+
+	now := time.Now()
+	if _, err := db.conn.Exec(`DELETE FROM sessions WHERE expires_at <= ?`, now.Unix()); err != nil {
+		return "", err
+	}
+	_, err = db.conn.Exec(
+		`INSERT INTO sessions (token_hash, user_id, expires_at) VALUES (?, ?, ?)`,
+		hashSecret(token), userID, now.Add(ttl).Unix(),
+	)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// GetSessionUser returns the user of an unexpired session, or sql.ErrNoRows
+func (db *DB) GetSessionUser(token string) (*models.User, error) {
+	return scanUser(db.read.QueryRow(`
+		SELECT `+userColumns+`
+		FROM sessions
+		JOIN users ON users.id = sessions.user_id
+		WHERE sessions.token_hash = ? AND sessions.expires_at > ?
+	`, hashSecret(token), time.Now().Unix()))
+}
+
+// DeleteSession ends a session
+func (db *DB) DeleteSession(token string) error {
+	_, err := db.conn.Exec(`DELETE FROM sessions WHERE token_hash = ?`, hashSecret(token))
+	return err
+}
+
+// CreateAdminSession starts a session for a browser that logged in with
+// adminToken and returns its token. Only hashes are stored, and sessions end
+// when the admin token changes
+func (db *DB) CreateAdminSession(adminToken string, ttl time.Duration) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate session token: %w", err)
+	}
+
+	now := time.Now()
+	if _, err := db.conn.Exec(`DELETE FROM admin_sessions WHERE expires_at <= ?`, now.Unix()); err != nil {
+		return "", err
+	}
+	_, err = db.conn.Exec(
+		`INSERT INTO admin_sessions (token_hash, admin_token_hash, expires_at) VALUES (?, ?, ?)`,
+		hashSecret(token), hashSecret(adminToken), now.Add(ttl).Unix(),
+	)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// IsAdminSession reports whether token is an unexpired admin session started
+// with adminToken
+func (db *DB) IsAdminSession(token, adminToken string) (bool, error) {
+	var valid bool
+	err := db.read.QueryRow(
+		`SELECT COUNT(*) > 0 FROM admin_sessions WHERE token_hash = ? AND admin_token_hash = ? AND expires_at > ?`,
+		hashSecret(token), hashSecret(adminToken), time.Now().Unix(),
+	).Scan(&valid)
+	return valid, err
+}
+
+// DeleteAdminSession ends an admin session
+func (db *DB) DeleteAdminSession(token string) error {
+	_, err := db.conn.Exec(`DELETE FROM admin_sessions WHERE token_hash = ?`, hashSecret(token))
+	return err
+}
+
+// CreateAPIKey creates an API key for key.UserID and returns the key, which
+// can't be recovered later. The key's scopes can't exceed the user's
+func (db *DB) CreateAPIKey(key *models.APIKey) (string, error) {
+	key.Name = strings.TrimSpace(key.Name)
+	if key.Name == "" {
+		return "", fmt.Errorf("name is required")
+	}
+	if len(key.Scopes) == 0 {
+		return "", fmt.Errorf("at least one scope is required")
+	}
+
+	var scopes string
+	if err := db.conn.QueryRow(`SELECT scopes FROM users WHERE id = ?`, key.UserID).Scan(&scopes); err != nil {
+		return "", err
+	}
+	for _, scope := range key.Scopes {
+		if !models.HasScope(strings.Split(scopes, ","), scope) {
+			return "", fmt.Errorf("%w: %s", ErrScopeNotAllowed, scope)
+		}
+	}
+
+	token, err := randomToken(24)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	secret := APIKeyPrefix + token
+	key.Prefix = secret[:len(APIKeyPrefix)+6]
+
+	err = db.conn.QueryRow(`
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes)
+		VALUES (?, ?, ?, ?, ?)
+		RETURNING id, created_at
+	`, key.UserID, key.Name, key.Prefix, hashSecret(secret), strings.Join(key.Scopes, ",")).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// apiKeyColumns are the columns scanned by scanAPIKey
+const apiKeyColumns = `api_keys.id, api_keys.user_id, api_keys.name, api_keys.prefix, api_keys.scopes, api_keys.created_at, api_keys.last_used_at`
+
+// scanAPIKey scans a row of apiKeyColumns
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (*models.APIKey, error) {
+	var key models.APIKey
+	var scopes string
+	var lastUsed sql.NullTime
+	if err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &scopes, &key.CreatedAt, &lastUsed); err != nil {
+		return nil, err
+	}
+	key.Scopes = strings.Split(scopes, ",")
+	if lastUsed.Valid {
+		key.LastUsedAt = &lastUsed.Time
+	}
+	return &key, nil
+}
+
+// GetAPIKeys returns the API keys of a user, newest first
+func (db *DB) GetAPIKeys(userID int64) ([]models.APIKey, error) {
+	rows, err := db.read.Query(`SELECT `+apiKeyColumns+` FROM api_keys WHERE user_id = ? ORDER BY id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+// DeleteAPIKey revokes one of a user's API keys, returning sql.ErrNoRows if
+// the user has no key with that ID
+func (db *DB) DeleteAPIKey(userID, id int64) error {
+	result, err := db.conn.Exec(`DELETE FROM api_keys WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// AuthenticateAPIKey returns the key and its user for a presented API key,
+// or ErrInvalidCredentials, and records when the key was last used
+func (db *DB) AuthenticateAPIKey(secret string) (*models.User, *models.APIKey, error) {
+	if !strings.HasPrefix(secret, APIKeyPrefix) {
+		return nil, nil, ErrInvalidCredentials
+	}
+
+	hash := hashSecret(secret)
+	key, err := scanAPIKey(db.read.QueryRow(`SELECT `+apiKeyColumns+` FROM api_keys WHERE key_hash = ?`, hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user, err := db.GetUser(key.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Every API request authenticates, so the write is skipped unless the
+	// stored time is stale, keeping reads off the single writer connection
+	now := time.Now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyTouchInterval {
+		if _, err := db.conn.Exec(`UPDATE api_keys SET last_used_at = ? WHERE id = ?`, now, key.ID); err != nil {
+			return nil, nil, err
+		}
+		key.LastUsedAt = &now
+	}
+	return user, key, nil
+}
+
+// apiKeyTouchInterval is how stale the last use of an API key can get
+const apiKeyTouchInterval = time.Minute
+
+// GetUserProfile returns the profile of a user, or sql.ErrNoRows if the
+// user has none yet
+func (db *DB) GetUserProfile(userID int64) (*models.Profile, error) {
+	var profile models.Profile
+	err := db.read.QueryRow(
+		`SELECT id, token, created_at FROM profiles WHERE user_id = ? ORDER BY id LIMIT 1`,
+		userID,
+	).Scan(&profile.ID, &profile.Token, &profile.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	profile.UserID = &userID
+	return &profile, nil
+}
+
+// ProfileForUser returns the profile of a user who just logged in. A user
+// without a profile adopts the browser's anonymous profile, if it has one, so
+// saved filters and the watchlist carry over; otherwise a new one is created
+func (db *DB) ProfileForUser(userID int64, anonymous *models.Profile) (*models.Profile, error) {
+	profile, err := db.GetUserProfile(userID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return profile, err
+	}
+
+	if anonymous == nil || anonymous.UserID != nil {
+		created, err := db.CreateProfile()
+		if err != nil {
+			return nil, err
+		}
+		anonymous = created
+	}
+	if _, err := db.conn.Exec(`UPDATE profiles SET user_id = ? WHERE id = ?`, userID, anonymous.ID); err != nil {
+		return nil, err
+	}
+	anonymous.UserID = &userID
+	return anonymous, nil
+}
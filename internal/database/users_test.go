@@ -0,0 +1,236 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// createTestUser creates a user with password "correct horse"
+func createTestUser(t *testing.T, db *DB, username string, scopes ...string) *models.User {
+	t.Helper()
+
+	bcryptCost = bcrypt.MinCost
+	t.Cleanup(func() { bcryptCost = bcrypt.DefaultCost })
+
+	user := &models.User{Username: username, Scopes: scopes}
+	if err := db.CreateUser(user, "correct horse"); err != nil {
+		t.Fatalf("CreateUser() failed: %v", err)
+	}
+	return user
+}
+
+// TestUsers tests creating users and checking their passwords
+func TestUsers(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	user := createTestUser(t, db, "alice", models.ScopeRead, models.ScopeWrite)
+	if user.ID == 0 {
+		t.Fatal("User ID should be set after creation")
+	}
+
+	tests := []struct {
+		name     string
+		user     models.User
+		password string
+	}{
+		{"duplicate username", models.User{Username: "Alice", Scopes: []string{models.ScopeRead}}, "long enough"},
+		{"empty username", models.User{Username: " ", Scopes: []string{models.ScopeRead}}, "long enough"},
+		{"short password", models.User{Username: "bob", Scopes: []string{models.ScopeRead}}, "short"},
+		{"no scopes", models.User{Username: "bob"}, "long enough"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := db.CreateUser(&tt.user, tt.password); err == nil {
+				t.Error("CreateUser() should fail")
+			}
+		})
+	}
+
+	got, err := db.AuthenticateUser("ALICE", "correct horse")
+	if err != nil {
+		t.Fatalf("AuthenticateUser() failed: %v", err)
+	}
+	if got.ID != user.ID || strings.Join(got.Scopes, ",") != "read,write" {
+		t.Errorf("AuthenticateUser() = %+v, want alice with read,write", got)
+	}
+
+	for _, creds := range [][2]string{{"alice", "wrong password"}, {"nobody", "correct horse"}} {
+		if _, err := db.AuthenticateUser(creds[0], creds[1]); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("AuthenticateUser(%s, %s) error = %v, want ErrInvalidCredentials", creds[0], creds[1], err)
+		}
+	}
+
+	if err := db.SetPassword(user.ID, "battery staple"); err != nil {
+		t.Fatalf("SetPassword() failed: %v", err)
+	}
+	if _, err := db.AuthenticateUser("alice", "battery staple"); err != nil {
+		t.Errorf("AuthenticateUser() with the new password failed: %v", err)
+	}
+}
+
+// TestSessions tests session lifetime and logout
+func TestSessions(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	user := createTestUser(t, db, "alice", models.ScopeRead)
+
+	token, err := db.CreateSession(user.ID, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateSession() failed: %v", err)
+	}
+	got, err := db.GetSessionUser(token)
+	if err != nil || got.ID != user.ID {
+		t.Fatalf("GetSessionUser() = %+v (err %v), want alice", got, err)
+	}
+
+	expired, _ := db.CreateSession(user.ID, -time.Minute)
+	if _, err := db.GetSessionUser(expired); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetSessionUser() of an expired session error = %v, want sql.ErrNoRows", err)
+	}
+
+	if err := db.DeleteSession(token); err != nil {
+		t.Fatalf("DeleteSession() failed: %v", err)
+	}
+	if _, err := db.GetSessionUser(token); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetSessionUser() after logout error = %v, want sql.ErrNoRows", err)
+	}
+
+	// Changing the password ends every session
+	token, _ = db.CreateSession(user.ID, time.Hour)
+	db.SetPassword(user.ID, "battery staple")
+	if _, err := db.GetSessionUser(token); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetSessionUser() after a password change error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+// TestAPIKeys tests creating, using and revoking API keys
+func TestAPIKeys(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	user := createTestUser(t, db, "partner", models.ScopeRead)
+	other := createTestUser(t, db, "other", models.ScopeAdmin)
+
+	if _, err := db.CreateAPIKey(&models.APIKey{UserID: user.ID, Name: "too much", Scopes: []string{models.ScopeWrite}}); !errors.Is(err, ErrScopeNotAllowed) {
+		t.Errorf("CreateAPIKey() above the user's scopes error = %v, want ErrScopeNotAllowed", err)
+	}
+	if _, err := db.CreateAPIKey(&models.APIKey{UserID: user.ID, Scopes: []string{models.ScopeRead}}); err == nil {
+		t.Error("CreateAPIKey() should require a name")
+	}
+
+	key := &models.APIKey{UserID: user.ID, Name: "dashboard", Scopes: []string{models.ScopeRead}}
+	secret, err := db.CreateAPIKey(key)
+	if err != nil {
+		t.Fatalf("CreateAPIKey() failed: %v", err)
+	}
+	if !strings.HasPrefix(secret, APIKeyPrefix) || !strings.HasPrefix(secret, key.Prefix) || len(secret) < 32 {
+		t.Errorf("CreateAPIKey() = %q with prefix %q, want a long key starting with %s", secret, key.Prefix, APIKeyPrefix)
+	}
+
+	gotUser, gotKey, err := db.AuthenticateAPIKey(secret)
+	if err != nil {
+		t.Fatalf("AuthenticateAPIKey() failed: %v", err)
+	}
+	if gotUser.ID != user.ID || gotKey.ID != key.ID || gotKey.LastUsedAt == nil {
+		t.Errorf("AuthenticateAPIKey() = %+v, %+v, want the partner's key marked as used", gotUser, gotKey)
+	}
+	for _, bad := range []string{"", "not-a-key", secret + "x"} {
+		if _, _, err := db.AuthenticateAPIKey(bad); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("AuthenticateAPIKey(%q) error = %v, want ErrInvalidCredentials", bad, err)
+		}
+	}
+
+	keys, err := db.GetAPIKeys(user.ID)
+	if err != nil || len(keys) != 1 || keys[0].LastUsedAt == nil {
+		t.Fatalf("GetAPIKeys() = %+v (err %v), want the used key", keys, err)
+	}
+
+	// Uses within a minute don't write, older last uses are refreshed
+	_, again, err := db.AuthenticateAPIKey(secret)
+	if err != nil || !again.LastUsedAt.Equal(*gotKey.LastUsedAt) {
+		t.Errorf("last use after a second request = %v (err %v), want unchanged %v", again.LastUsedAt, err, gotKey.LastUsedAt)
+	}
+	stale := time.Now().Add(-2 * apiKeyTouchInterval)
+	db.conn.Exec(`UPDATE api_keys SET last_used_at = ? WHERE id = ?`, stale, key.ID)
+	if _, again, err = db.AuthenticateAPIKey(secret); err != nil || !again.LastUsedAt.After(stale.Add(apiKeyTouchInterval)) {
+		t.Errorf("last use after a stale one = %v (err %v), want now", again.LastUsedAt, err)
+	}
+
+	if err := db.DeleteAPIKey(other.ID, key.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("DeleteAPIKey() by another user error = %v, want sql.ErrNoRows", err)
+	}
+	if err := db.DeleteAPIKey(user.ID, key.ID); err != nil {
+		t.Fatalf("DeleteAPIKey() failed: %v", err)
+	}
+	if _, _, err := db.AuthenticateAPIKey(secret); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("AuthenticateAPIKey() after revoking error = %v, want ErrInvalidCredentials", err)
+	}
+}
+
+// TestProfileForUser tests that users adopt the browser's anonymous profile once
+func TestProfileForUser(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	alice := createTestUser(t, db, "alice", models.ScopeRead)
+	bob := createTestUser(t, db, "bob", models.ScopeRead)
+
+	if _, err := db.GetUserProfile(alice.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetUserProfile() before login error = %v, want sql.ErrNoRows", err)
+	}
+	anonymous, _ := db.CreateProfile()
+	profile, err := db.ProfileForUser(alice.ID, anonymous)
+	if err != nil {
+		t.Fatalf("ProfileForUser() failed: %v", err)
+	}
+	if profile.ID != anonymous.ID || profile.UserID == nil || *profile.UserID != alice.ID {
+		t.Errorf("ProfileForUser() = %+v, want the anonymous profile adopted by alice", profile)
+	}
+
+	// Bob can't adopt Alice's profile from a shared browser
+	bobProfile, err := db.ProfileForUser(bob.ID, profile)
+	if err != nil {
+		t.Fatalf("ProfileForUser() failed: %v", err)
+	}
+	if bobProfile.ID == profile.ID {
+		t.Error("ProfileForUser() should not hand one user's profile to another")
+	}
+
+	// Later logins return the same profile
+	again, _ := db.ProfileForUser(alice.ID, nil)
+	if again.ID != profile.ID {
+		t.Errorf("ProfileForUser() = %d, want alice's profile %d", again.ID, profile.ID)
+	}
+	if got, err := db.GetUserProfile(alice.ID); err != nil || got.ID != profile.ID {
+		t.Errorf("GetUserProfile() = %+v (err %v), want alice's profile %d", got, err, profile.ID)
+	}
+
+	// Deleting a user keeps its profile as an anonymous one
+	if err := db.DeleteUser(alice.ID); err != nil {
+		t.Fatalf("DeleteUser() failed: %v", err)
+	}
+	kept, err := db.GetProfileByToken(profile.Token)
+	if err != nil || kept.UserID != nil {
+		t.Errorf("profile after DeleteUser() = %+v (err %v), want it anonymous", kept, err)
+	}
+}
+
+// TestDummyPasswordHash tests that logins of unknown usernames compare
+// against a hash as slow as those of real passwords
+func TestDummyPasswordHash(t *testing.T) {
+	for _, cost := range []int{bcrypt.MinCost, bcrypt.DefaultCost} {
+		bcryptCost = cost
+		if got, err := bcrypt.Cost(dummyPasswordHash()); err != nil || got != cost {
+			t.Errorf("dummy hash cost = %d (err %v), want %d", got, err, cost)
+		}
+	}
+	bcryptCost = bcrypt.DefaultCost
+}
@@ -0,0 +1,66 @@
+// Package events fans rate updates out to live subscribers such as the
+// server-sent events stream of the dashboard
+package events
+
+import (
+	"sync"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// subscriberBuffer is how many updates a slow subscriber can fall behind
+// before updates are dropped for it
+const subscriberBuffer = 16
+
+// Broker delivers every published update to every current subscriber
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[chan models.RatesUpdate]struct{}
+}
+
+// NewBroker creates a broker without subscribers
+func NewBroker() *Broker {
+	return &Broker{subscribers: make(map[chan models.RatesUpdate]struct{})}
+}
+
+// Subscribe returns a channel receiving every update published from now on
+// and a function that unsubscribes and closes the channel
+func (b *Broker) Subscribe() (<-chan models.RatesUpdate, func()) {
+	ch := make(chan models.RatesUpdate, subscriberBuffer)
+
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+	return ch, unsubscribe
+}
+
+// Publish sends update to every subscriber without blocking; subscribers
+// whose buffer is full miss it
+func (b *Broker) Publish(update models.RatesUpdate) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- update:
+		default:
+		}
+	}
+}
+
+// Subscribers returns the number of current subscribers
+func (b *Broker) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers)
+}
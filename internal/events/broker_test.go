@@ -0,0 +1,53 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// TestBroker tests fan-out to several subscribers and unsubscribing
+func TestBroker(t *testing.T) {
+	broker := NewBroker()
+
+	first, unsubscribeFirst := broker.Subscribe()
+	second, unsubscribeSecond := broker.Subscribe()
+	defer unsubscribeSecond()
+
+	if broker.Subscribers() != 2 {
+		t.Fatalf("Subscribers() = %d, want 2", broker.Subscribers())
+	}
+
+	broker.Publish(models.RatesUpdate{Source: "Pendle", RunID: 1})
+
+	for i, ch := range []<-chan models.RatesUpdate{first, second} {
+		if update := <-ch; update.RunID != 1 {
+			t.Errorf("subscriber %d got run %d, want 1", i, update.RunID)
+		}
+	}
+
+	unsubscribeFirst()
+	unsubscribeFirst() // Unsubscribing twice is harmless
+
+	if _, ok := <-first; ok {
+		t.Error("channel should be closed after unsubscribing")
+	}
+	if broker.Subscribers() != 1 {
+		t.Errorf("Subscribers() = %d, want 1", broker.Subscribers())
+	}
+}
+
+// TestBroker_SlowSubscriber tests that a full subscriber doesn't block publishing
+func TestBroker_SlowSubscriber(t *testing.T) {
+	broker := NewBroker()
+	updates, unsubscribe := broker.Subscribe()
+	defer unsubscribe()
+
+	for i := 0; i < subscriberBuffer+5; i++ {
+		broker.Publish(models.RatesUpdate{RunID: int64(i)})
+	}
+
+	if len(updates) != subscriberBuffer {
+		t.Errorf("buffered %d updates, want %d", len(updates), subscriberBuffer)
+	}
+}
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/database"
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// sessionCookie holds the session token of a logged-in user
+const sessionCookie = "defirates_session"
+
+// sessionTTL is how long a login lasts
+const sessionTTL = 30 * 24 * time.Hour
+
+// safeNext returns next if it is a local path, or fallback
+func safeNext(next, fallback string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		return fallback
+	}
+	return next
+}
+
+// HandleLogin shows the login form and, on POST, starts a session for the
+// user. The browser's anonymous profile becomes the user's profile if the
+// user has none yet
+func (h *Handler) HandleLogin(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Next     string
+		Username string
+		Error    string
+	}{Next: safeNext(r.FormValue("next"), "/")}
+
+	if r.Method == http.MethodPost {
+		data.Username = r.PostFormValue("username")
+		user, err := h.db.AuthenticateUser(data.Username, r.PostFormValue("password"))
+		switch {
+		case errors.Is(err, database.ErrInvalidCredentials):
+			requestLogger(r).Warn("failed login", "username", data.Username)
+			w.WriteHeader(http.StatusUnauthorized)
+			data.Error = "Invalid username or password"
+		case err != nil:
+			requestLogger(r).Error("failed to check login", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			data.Error = "Login failed, please try again"
+		default:
+			if err := h.startSession(w, r, user); err != nil {
+				requestLogger(r).Error("failed to start session", "error", err)
+				http.Error(w, "Failed to log in", http.StatusInternalServerError)
+				return
+			}
+			http.Redirect(w, r, data.Next, http.StatusSeeOther)
+			return
+		}
+	}
+
+	if err := h.templates.ExecuteTemplate(w, "user_login.html", data); err != nil {
+		requestLogger(r).Error("failed to execute template", "error", err)
+		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+	}
+}
+
+// startSession sets the session and profile cookies for a user who logged in
+func (h *Handler) startSession(w http.ResponseWriter, r *http.Request, user *models.User) error {
+	token, err := h.db.CreateSession(user.ID, sessionTTL)
+	if err != nil {
+		return err
+	}
+	profile, err := h.db.ProfileForUser(user.ID, h.cookieProfile(r))
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(sessionTTL / time.Second),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	h.setProfileCookie(w, r, profile)
+	requestLogger(r).Info("user logged in", "user", user.Username)
+	return nil
+}
+
+// HandleLogout ends the session and forgets the user's profile in this browser
+func (h *Handler) HandleLogout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		if err := h.db.DeleteSession(cookie.Value); err != nil {
+			requestLogger(r).Error("failed to delete session", "error", err)
+		}
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "", Path: "/", MaxAge: -1})
+	http.SetCookie(w, &http.Cookie{Name: profileCookie, Value: "", Path: "/", MaxAge: -1})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// requestUser returns the user a request acts for, writing a 403 if it was
+// made with the admin token rather than a user account
+func (h *Handler) requestUser(w http.ResponseWriter, r *http.Request) (*principal, bool) {
+	p := h.principal(r)
+	if p == nil || p.user == nil {
+		writeJSONError(w, http.StatusForbidden, "API keys belong to user accounts; log in or use an API key")
+		return nil, false
+	}
+	return p, true
+}
+
+// HandleListAPIKeys lists the API keys of the request's user
+func (h *Handler) HandleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	p, ok := h.requestUser(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := h.db.GetAPIKeys(p.user.ID)
+	if err != nil {
+		requestLogger(r).Error("failed to fetch API keys", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to fetch API keys")
+		return
+	}
+	if keys == nil {
+		keys = []models.APIKey{}
+	}
+	writeJSON(w, http.StatusOK, keys)
+}
+
+// HandleCreateAPIKey creates an API key from a JSON {"name", "scopes"} body.
+// The key is only ever returned in this response
+func (h *Handler) HandleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	p, ok := h.requestUser(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	scopes, err := models.ParseScopes(strings.Join(body.Scopes, ","))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// A key can't be used to mint a more powerful one
+	for _, scope := range scopes {
+		if !models.HasScope(p.scopes, scope) {
+			writeJSONError(w, http.StatusForbidden, "can't grant the "+scope+" scope")
+			return
+		}
+	}
+
+	key := models.APIKey{UserID: p.user.ID, Name: body.Name, Scopes: scopes}
+	secret, err := h.db.CreateAPIKey(&key)
+	if err != nil {
+		if errors.Is(err, database.ErrScopeNotAllowed) {
+			writeJSONError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	requestLogger(r).Info("created API key", "user", p.user.Username, "key", key.Prefix, "scopes", strings.Join(scopes, ","))
+
+	writeJSON(w, http.StatusCreated, struct {
+		Key    string        `json:"key"`
+		APIKey models.APIKey `json:"api_key"`
+	}{secret, key})
+}
+
+// HandleDeleteAPIKey revokes the API key at /api/keys/{id}
+func (h *Handler) HandleDeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	p, ok := h.requestUser(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid key id")
+		return
+	}
+
+	if err := h.db.DeleteAPIKey(p.user.ID, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, "key not found")
+			return
+		}
+		requestLogger(r).Error("failed to delete API key", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to delete API key")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pretty-andrechal/defirates/internal/database"
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// newAccountMux routes the account, API and admin endpoints like cmd/server does
+func newAccountMux(handler *Handler) *http.ServeMux {
+	read := func(next http.HandlerFunc) http.HandlerFunc { return handler.RequireScope(models.ScopeRead, next) }
+	write := func(next http.HandlerFunc) http.HandlerFunc { return handler.RequireScope(models.ScopeWrite, next) }
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/{$}", handler.HandleIndex)
+	mux.HandleFunc("/login", handler.HandleLogin)
+	mux.HandleFunc("POST /logout", handler.HandleLogout)
+	mux.HandleFunc("GET /api/rates", read(handler.HandleAPIRates))
+	mux.HandleFunc("GET /api/keys", read(handler.HandleListAPIKeys))
+	mux.HandleFunc("POST /api/keys", read(handler.HandleCreateAPIKey))
+	mux.HandleFunc("DELETE /api/keys/{id}", read(handler.HandleDeleteAPIKey))
+	mux.HandleFunc("GET /admin/fetch-runs", handler.RequireAdmin(handler.HandleFetchRuns))
+	mux.HandleFunc("POST /admin/manual-rates", write(handler.HandleCreateManualRate))
+	return mux
+}
+
+// createUser creates a user with password "correct horse"
+func createUser(t *testing.T, db *database.DB, username string, scopes ...string) *models.User {
+	t.Helper()
+
+	user := &models.User{Username: username, Scopes: scopes}
+	if err := db.CreateUser(user, "correct horse"); err != nil {
+		t.Fatalf("CreateUser() failed: %v", err)
+	}
+	return user
+}
+
+// TestHandleLogin tests logging in and out with a user account
+func TestHandleLogin(t *testing.T) {
+	handler, db, cleanup := setupTestHandler(t)
+	defer cleanup()
+	mux := newAccountMux(handler)
+	createUser(t, db, "partner", models.ScopeRead)
+
+	login := func(password string) *httptest.ResponseRecorder {
+		form := url.Values{"username": {"partner"}, "password": {password}, "next": {"/api/rates"}}
+		return profileRequest(mux, "POST", "/login", form, nil)
+	}
+
+	if w := login("wrong password"); w.Code != http.StatusUnauthorized || !contains(w.Body.String(), "Invalid username or password") {
+		t.Errorf("login with a wrong password status = %d, want %d with an error", w.Code, http.StatusUnauthorized)
+	}
+
+	w := login("correct horse")
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/api/rates" {
+		t.Fatalf("login status = %d, Location = %s", w.Code, w.Header().Get("Location"))
+	}
+	session := responseCookie(w, sessionCookie)
+	if session == nil || !session.HttpOnly {
+		t.Fatal("login should set an HttpOnly session cookie")
+	}
+	if responseCookie(w, profileCookie) == nil {
+		t.Error("login should point the browser at the user's profile")
+	}
+
+	if w := profileRequest(mux, "GET", "/api/rates", nil, session); w.Code != http.StatusOK {
+		t.Errorf("GET /api/rates with a session status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := profileRequest(mux, "GET", "/", nil, session); !contains(w.Body.String(), "Signed in as") {
+		t.Error("index page should show the logged-in user")
+	}
+
+	w = profileRequest(mux, "POST", "/logout", nil, session)
+	if c := responseCookie(w, sessionCookie); c == nil || c.MaxAge >= 0 {
+		t.Error("logout should clear the session cookie")
+	}
+	req := httptest.NewRequest("GET", "/api/rates", nil)
+	req.Header.Set("Accept", "application/json")
+	req.AddCookie(session)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /api/rates after logout status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+// TestRequireScope tests that partners can read but not refresh or enter rates
+func TestRequireScope(t *testing.T) {
+	handler, db, cleanup := setupTestHandler(t)
+	defer cleanup()
+	mux := newAccountMux(handler)
+
+	partner := createUser(t, db, "partner", models.ScopeRead)
+	editor := createUser(t, db, "editor", models.ScopeWrite)
+	admin := createUser(t, db, "admin", models.ScopeAdmin)
+
+	keys := map[string]string{}
+	for name, user := range map[string]*models.User{"partner": partner, "editor": editor, "admin": admin} {
+		secret, err := db.CreateAPIKey(&models.APIKey{UserID: user.ID, Name: name, Scopes: user.Scopes})
+		if err != nil {
+			t.Fatalf("CreateAPIKey() failed: %v", err)
+		}
+		keys[name] = secret
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		key        string
+		wantStatus int
+	}{
+		{"anonymous API", "GET", "/api/rates", "", http.StatusUnauthorized},
+		{"invalid key", "GET", "/api/rates", database.APIKeyPrefix + "nope", http.StatusUnauthorized},
+		{"partner reads rates", "GET", "/api/rates", keys["partner"], http.StatusOK},
+		{"partner can't see fetch runs", "GET", "/admin/fetch-runs", keys["partner"], http.StatusForbidden},
+		{"partner can't enter rates", "POST", "/admin/manual-rates", keys["partner"], http.StatusForbidden},
+		{"editor enters rates", "POST", "/admin/manual-rates", keys["editor"], http.StatusCreated},
+		{"editor can't see fetch runs", "GET", "/admin/fetch-runs", keys["editor"], http.StatusForbidden},
+		{"admin sees fetch runs", "GET", "/admin/fetch-runs", keys["admin"], http.StatusOK},
+		{"admin reads rates", "GET", "/api/rates", keys["admin"], http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body *strings.Reader
+			if tt.method == "POST" {
+				body = strings.NewReader(`{"asset": "USDC", "chain": "Off-chain", "apy": 9.5}`)
+			} else {
+				body = strings.NewReader("")
+			}
+			req := httptest.NewRequest(tt.method, tt.path, body)
+			req.Header.Set("Accept", "application/json")
+			if tt.key != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.key)
+			}
+			w := httptest.NewRecorder()
+
+			mux.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+}
+
+// TestAPIKeyEndpoints tests managing API keys over the API
+func TestAPIKeyEndpoints(t *testing.T) {
+	handler, db, cleanup := setupTestHandler(t)
+	defer cleanup()
+	mux := newAccountMux(handler)
+
+	user := createUser(t, db, "editor", models.ScopeWrite)
+	session, err := db.CreateSession(user.ID, sessionTTL)
+	if err != nil {
+		t.Fatalf("CreateSession() failed: %v", err)
+	}
+
+	do := func(method, path, body, auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Accept", "application/json")
+		if strings.HasPrefix(auth, database.APIKeyPrefix) {
+			req.Header.Set("Authorization", "Bearer "+auth)
+		} else {
+			req.AddCookie(&http.Cookie{Name: sessionCookie, Value: auth})
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("POST", "/api/keys", `{"name": "ops", "scopes": ["admin"]}`, session); w.Code != http.StatusForbidden {
+		t.Errorf("creating a key above the user's scopes status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w := do("POST", "/api/keys", `{"name": "ops", "scopes": ["root"]}`, session); w.Code != http.StatusBadRequest {
+		t.Errorf("creating a key with an unknown scope status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	w := do("POST", "/api/keys", `{"name": "partner dashboard", "scopes": ["read"]}`, session)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	var created struct {
+		Key    string        `json:"key"`
+		APIKey models.APIKey `json:"api_key"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	// A read key can't mint a write key, even though its user could
+	if w := do("POST", "/api/keys", `{"name": "escalate", "scopes": ["write"]}`, created.Key); w.Code != http.StatusForbidden {
+		t.Errorf("read key creating a write key status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	w = do("GET", "/api/keys", "", created.Key)
+	if w.Code != http.StatusOK || !contains(w.Body.String(), "partner dashboard") || contains(w.Body.String(), created.Key) {
+		t.Errorf("list = %d %s, want the key listed without its secret", w.Code, w.Body.String())
+	}
+
+	path := "/api/keys/" + strconv.FormatInt(created.APIKey.ID, 10)
+	if w := do("DELETE", path, "", session); w.Code != http.StatusNoContent {
+		t.Errorf("delete status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if w := do("GET", "/api/keys", "", created.Key); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked key status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
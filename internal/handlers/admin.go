@@ -0,0 +1,347 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/api"
+	"github.com/pretty-andrechal/defirates/internal/database"
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// SetAdminToken lets requests presenting token act with the admin scope.
+// With an empty token only user accounts can reach the admin routes
+func (h *Handler) SetAdminToken(token string) {
+	h.adminToken = token
+}
+
+// Refresher runs an immediate fetch cycle, optionally for a single chain.
+// It is implemented by api.Fetcher
+type Refresher interface {
+	Refresh(chain string) ([]*models.FetchRun, error)
+}
+
+// SetRefresher enables POST /admin/refresh and the refresh button
+func (h *Handler) SetRefresher(refresher Refresher) {
+	h.refresher = refresher
+}
+
+// refreshTimeout bounds a manual refresh, which can outlast the server's write timeout
+const refreshTimeout = 5 * time.Minute
+
+// adminCookie holds the admin session of browsers that logged in at
+// /admin/login. The admin token itself never leaves the login form
+const adminCookie = "defirates_admin"
+
+// isAdmin reports whether the request carries the admin token, as a bearer
+// token or in the X-Admin-Token header, or an admin session cookie
+func (h *Handler) isAdmin(r *http.Request) bool {
+	if h.adminToken == "" {
+		return false
+	}
+
+	token := r.Header.Get("X-Admin-Token")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		token = bearer
+	}
+	if token != "" {
+		return subtle.ConstantTimeCompare([]byte(token), []byte(h.adminToken)) == 1
+	}
+
+	cookie, err := r.Cookie(adminCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	valid, err := h.db.IsAdminSession(cookie.Value, h.adminToken)
+	if err != nil {
+		requestLogger(r).Error("failed to check admin session", "error", err)
+		return false
+	}
+	return valid
+}
+
+// RequireAdmin wraps a handler so it's only reachable with the admin token or
+// by users and API keys with the admin scope. Browsers asking for HTML are
+// sent to the admin login page instead of getting a 401
+func (h *Handler) RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return h.requireScope(models.ScopeAdmin, "/admin/login", next)
+}
+
+// HandleAdminLogin shows the admin login form and, on POST, starts an admin
+// session so browsers can reach the admin pages
+func (h *Handler) HandleAdminLogin(w http.ResponseWriter, r *http.Request) {
+	next := safeNext(r.FormValue("next"), "/admin/fetch-runs")
+
+	data := struct {
+		Next  string
+		Error string
+	}{Next: next}
+
+	if r.Method == http.MethodPost {
+		token := r.PostFormValue("token")
+		if h.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(h.adminToken)) == 1 {
+			session, err := h.db.CreateAdminSession(h.adminToken, sessionTTL)
+			if err != nil {
+				requestLogger(r).Error("failed to create admin session", "error", err)
+				http.Error(w, "Failed to log in", http.StatusInternalServerError)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{
+				Name:     adminCookie,
+				Value:    session,
+				Path:     "/",
+				MaxAge:   int(sessionTTL / time.Second),
+				HttpOnly: true,
+				Secure:   r.TLS != nil,
+				SameSite: http.SameSiteStrictMode,
+			})
+			http.Redirect(w, r, next, http.StatusSeeOther)
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		data.Error = "Invalid admin token"
+	}
+
+	if err := h.templates.ExecuteTemplate(w, "login.html", data); err != nil {
+		requestLogger(r).Error("failed to execute template", "error", err)
+		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+	}
+}
+
+// HandleAdminLogout ends the admin session and clears its cookie
+func (h *Handler) HandleAdminLogout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(adminCookie); err == nil {
+		if err := h.db.DeleteAdminSession(cookie.Value); err != nil {
+			requestLogger(r).Error("failed to delete admin session", "error", err)
+		}
+	}
+	http.SetCookie(w, &http.Cookie{Name: adminCookie, Value: "", Path: "/", MaxAge: -1})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// HandleFetchRuns lists recent fetch runs as an HTML page, or as JSON with
+// ?format=json or an application/json Accept header
+func (h *Handler) HandleFetchRuns(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if val, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && val > 0 {
+		limit = min(val, 500)
+	}
+	source := r.URL.Query().Get("source")
+
+	runs, err := h.db.GetFetchRuns(source, limit)
+	if err != nil {
+		requestLogger(r).Error("failed to fetch fetch runs", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to fetch fetch runs")
+		return
+	}
+	if runs == nil {
+		runs = []models.FetchRun{}
+	}
+
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusOK, runs)
+		return
+	}
+
+	data := struct {
+		Runs   []models.FetchRun
+		Source string
+	}{
+		Runs:   runs,
+		Source: source,
+	}
+	if err := h.templates.ExecuteTemplate(w, "fetch_runs.html", data); err != nil {
+		requestLogger(r).Error("failed to execute template", "error", err)
+		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+	}
+}
+
+// HandleRefresh runs a fetch cycle right away, optionally limited to the
+// chain form value, and returns the fetch runs. HTMX requests get an HTML
+// summary and an HX-Trigger so the rates table reloads
+func (h *Handler) HandleRefresh(w http.ResponseWriter, r *http.Request) {
+	if h.refresher == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "refresh is not available")
+		return
+	}
+
+	chain := strings.TrimSpace(r.FormValue("chain"))
+
+	// Best effort: not every ResponseWriter supports deadlines
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(refreshTimeout))
+
+	logger := requestLogger(r).With("chain", chain)
+	logger.Info("manual refresh requested")
+
+	runs, err := h.refresher.Refresh(chain)
+
+	status := http.StatusOK
+	switch {
+	case errors.Is(err, api.ErrFetchInProgress):
+		status = http.StatusConflict
+	case errors.Is(err, api.ErrUnknownChain):
+		status = http.StatusBadRequest
+	case err != nil:
+		logger.Error("manual refresh failed", "error", err)
+		status = http.StatusInternalServerError
+	}
+
+	if r.Header.Get("HX-Request") == "true" {
+		data := struct {
+			Runs  []*models.FetchRun
+			Error string
+		}{Runs: runs}
+		if err != nil {
+			data.Error = err.Error()
+		} else {
+			w.Header().Set("HX-Trigger", "rates-refreshed")
+		}
+		// HTMX only swaps successful responses, so errors are reported in the body
+		if err := h.templates.ExecuteTemplate(w, "refresh_result.html", data); err != nil {
+			logger.Error("failed to execute template", "error", err)
+		}
+		return
+	}
+
+	if err != nil {
+		writeJSONError(w, status, err.Error())
+		return
+	}
+
+	writeJSON(w, status, struct {
+		Chain string             `json:"chain,omitempty"`
+		Runs  []*models.FetchRun `json:"runs"`
+	}{chain, runs})
+}
+
+// wantsJSON reports whether the client asked for a JSON response
+func wantsJSON(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "json" ||
+		strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// HandleListManualRates lists every manual rate
+func (h *Handler) HandleListManualRates(w http.ResponseWriter, r *http.Request) {
+	rates, err := h.db.GetManualRates()
+	if err != nil {
+		requestLogger(r).Error("failed to fetch manual rates", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to fetch manual rates")
+		return
+	}
+	if rates == nil {
+		rates = []models.YieldRate{}
+	}
+
+	writeJSON(w, http.StatusOK, rates)
+}
+
+// HandleCreateManualRate creates a manual rate from a JSON models.ManualEntry
+func (h *Handler) HandleCreateManualRate(w http.ResponseWriter, r *http.Request) {
+	rate, ok := decodeManualEntry(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.db.CreateManualRate(&rate); err != nil {
+		writeManualRateError(w, r, err)
+		return
+	}
+	h.cache.invalidate()
+
+	writeJSON(w, http.StatusCreated, rate)
+}
+
+// HandleUpdateManualRate replaces the manual rate at /admin/manual-rates/{id}
+func (h *Handler) HandleUpdateManualRate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid rate id")
+		return
+	}
+
+	rate, ok := decodeManualEntry(w, r)
+	if !ok {
+		return
+	}
+	rate.ID = id
+
+	if err := h.db.UpdateManualRate(&rate); err != nil {
+		writeManualRateError(w, r, err)
+		return
+	}
+	h.cache.invalidate()
+
+	writeJSON(w, http.StatusOK, rate)
+}
+
+// HandleDeleteManualRate deletes the manual rate at /admin/manual-rates/{id}
+func (h *Handler) HandleDeleteManualRate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid rate id")
+		return
+	}
+
+	if err := h.db.DeleteManualRate(id); err != nil {
+		writeManualRateError(w, r, err)
+		return
+	}
+	h.cache.invalidate()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeManualEntry reads a manual entry from the request body, writing a 400 on failure
+func decodeManualEntry(w http.ResponseWriter, r *http.Request) (models.YieldRate, bool) {
+	var entry models.ManualEntry
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&entry); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return models.YieldRate{}, false
+	}
+
+	rate, err := entry.YieldRate()
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return models.YieldRate{}, false
+	}
+	return rate, true
+}
+
+// writeManualRateError maps manual rate errors to HTTP status codes. Errors
+// other than those of the request are logged and hidden behind a 500
+func writeManualRateError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeJSONError(w, http.StatusNotFound, "rate not found")
+	case errors.Is(err, database.ErrNotManualRate), errors.Is(err, database.ErrManualRate), errors.Is(err, database.ErrDuplicatePool):
+		writeJSONError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, database.ErrInvalidManualRate):
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+	default:
+		requestLogger(r).Error("failed to save manual rate", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to save manual rate")
+	}
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to encode JSON response", "error", err)
+	}
+}
+
+// writeJSONError writes an {"error": message} JSON response
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,360 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/api"
+	"github.com/pretty-andrechal/defirates/internal/database"
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// newAdminMux routes the manual rate endpoints like cmd/server does
+func newAdminMux(handler *Handler) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/manual-rates", handler.RequireScope(models.ScopeWrite, handler.HandleListManualRates))
+	mux.HandleFunc("POST /admin/manual-rates", handler.RequireScope(models.ScopeWrite, handler.HandleCreateManualRate))
+	mux.HandleFunc("PUT /admin/manual-rates/{id}", handler.RequireScope(models.ScopeWrite, handler.HandleUpdateManualRate))
+	mux.HandleFunc("DELETE /admin/manual-rates/{id}", handler.RequireScope(models.ScopeWrite, handler.HandleDeleteManualRate))
+	return mux
+}
+
+// adminSession returns the cookie of a new admin session started with token
+func adminSession(t *testing.T, db *database.DB, token string) *http.Cookie {
+	t.Helper()
+	session, err := db.CreateAdminSession(token, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateAdminSession() failed: %v", err)
+	}
+	return &http.Cookie{Name: adminCookie, Value: session}
+}
+
+// TestRequireAdmin tests admin token enforcement
+func TestRequireAdmin(t *testing.T) {
+	handler, db, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	session := adminSession(t, db, "secret").String()
+	oldSession := adminSession(t, db, "rotated").String()
+
+	tests := []struct {
+		name       string
+		token      string
+		header     string
+		value      string
+		wantStatus int
+	}{
+		{"no admin token configured", "", "Authorization", "Bearer secret", http.StatusUnauthorized},
+		{"missing token", "secret", "", "", http.StatusUnauthorized},
+		{"wrong token", "secret", "Authorization", "Bearer nope", http.StatusUnauthorized},
+		{"bearer token", "secret", "Authorization", "Bearer secret", http.StatusOK},
+		{"header token", "secret", "X-Admin-Token", "secret", http.StatusOK},
+		{"session cookie", "secret", "Cookie", session, http.StatusOK},
+		{"raw token in cookie", "secret", "Cookie", adminCookie + "=secret", http.StatusUnauthorized},
+		{"session of another admin token", "secret", "Cookie", oldSession, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler.SetAdminToken(tt.token)
+
+			req := httptest.NewRequest("GET", "/admin/manual-rates", nil)
+			req.Header.Set("Accept", "application/json")
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			w := httptest.NewRecorder()
+
+			newAdminMux(handler).ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// TestManualRateEndpoints tests the create, update and delete round trip
+func TestManualRateEndpoints(t *testing.T) {
+	handler, db, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	handler.SetAdminToken("secret")
+	mux := newAdminMux(handler)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("POST", "/admin/manual-rates", `{"asset": "USDC", "chain": "Off-chain", "apy": 9.5, "pool_name": "OTC-USDC"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	var created models.YieldRate
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Failed to decode created rate: %v", err)
+	}
+
+	id := strconv.FormatInt(created.ID, 10)
+	w = do("PUT", "/admin/manual-rates/"+id, `{"asset": "USDC", "chain": "Off-chain", "apy": 11, "pool_name": "OTC-USDC"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("update status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	stored, err := db.GetYieldRate(created.ID)
+	if err != nil || stored.APY != 11 {
+		t.Fatalf("stored rate = %+v (err %v), want APY 11", stored, err)
+	}
+
+	if w := do("POST", "/admin/manual-rates", `{"asset": "USDC"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("create without chain status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	if w := do("DELETE", "/admin/manual-rates/"+id, ""); w.Code != http.StatusNoContent {
+		t.Errorf("delete status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+
+	if w := do("DELETE", "/admin/manual-rates/"+id, ""); w.Code != http.StatusNotFound {
+		t.Errorf("second delete status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+// TestWriteManualRateError tests that only errors of the request are
+// reported as client errors
+func TestWriteManualRateError(t *testing.T) {
+	tests := []struct {
+		err        error
+		wantStatus int
+	}{
+		{sql.ErrNoRows, http.StatusNotFound},
+		{database.ErrManualRate, http.StatusConflict},
+		{database.ErrNotManualRate, http.StatusConflict},
+		{database.ErrDuplicatePool, http.StatusConflict},
+		{fmt.Errorf("%w: chain is required", database.ErrInvalidManualRate), http.StatusBadRequest},
+		{errors.New("database is locked"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		writeManualRateError(w, httptest.NewRequest("POST", "/admin/manual-rates", nil), tt.err)
+		if w.Code != tt.wantStatus {
+			t.Errorf("writeManualRateError(%v) status = %d, want %d", tt.err, w.Code, tt.wantStatus)
+		}
+		if tt.wantStatus == http.StatusInternalServerError && strings.Contains(w.Body.String(), "locked") {
+			t.Errorf("500 body = %s, want the error hidden", w.Body.String())
+		}
+	}
+}
+
+// TestRequireAdmin_BrowserRedirect tests that browsers are sent to the login page
+func TestRequireAdmin_BrowserRedirect(t *testing.T) {
+	handler, _, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	handler.SetAdminToken("secret")
+
+	req := httptest.NewRequest("GET", "/admin/fetch-runs", nil)
+	req.Header.Set("Accept", "text/html")
+	w := httptest.NewRecorder()
+
+	handler.RequireAdmin(handler.HandleFetchRuns)(w, req)
+
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	if location := w.Header().Get("Location"); location != "/admin/login?next=%2Fadmin%2Ffetch-runs" {
+		t.Errorf("Location = %s, want the login page", location)
+	}
+}
+
+// TestHandleAdminLogin tests that a valid token starts an admin session,
+// which logging out ends
+func TestHandleAdminLogin(t *testing.T) {
+	handler, _, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	handler.SetAdminToken("secret")
+
+	login := func(token string) *httptest.ResponseRecorder {
+		form := url.Values{"token": {token}, "next": {"/admin/fetch-runs"}}
+		req := httptest.NewRequest("POST", "/admin/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handler.HandleAdminLogin(w, req)
+		return w
+	}
+
+	if w := login("wrong"); w.Code != http.StatusUnauthorized || !contains(w.Body.String(), "Invalid admin token") {
+		t.Errorf("login with wrong token status = %d, want %d with an error", w.Code, http.StatusUnauthorized)
+	}
+
+	w := login("secret")
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/admin/fetch-runs" {
+		t.Fatalf("login status = %d, Location = %s", w.Code, w.Header().Get("Location"))
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != adminCookie || !cookies[0].HttpOnly {
+		t.Fatalf("login cookies = %+v, want an HttpOnly %s cookie", cookies, adminCookie)
+	}
+	session := cookies[0]
+	if session.Value == "secret" || session.MaxAge <= 0 {
+		t.Errorf("login cookie = %+v, want an expiring session, not the admin token", session)
+	}
+
+	isAdmin := func() bool {
+		req := httptest.NewRequest("GET", "/admin/fetch-runs", nil)
+		req.AddCookie(session)
+		return handler.isAdmin(req)
+	}
+	if !isAdmin() {
+		t.Fatal("the login cookie should grant admin access")
+	}
+	req := httptest.NewRequest("POST", "/admin/logout", nil)
+	req.AddCookie(session)
+	handler.HandleAdminLogout(httptest.NewRecorder(), req)
+	if isAdmin() {
+		t.Error("the login cookie should stop working after logout")
+	}
+}
+
+// TestHandleFetchRuns tests the fetch run page and JSON endpoint
+func TestHandleFetchRuns(t *testing.T) {
+	handler, db, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	finishedAt := time.Now()
+	run := &models.FetchRun{
+		Source:          "Pendle",
+		Status:          models.FetchRunPartial,
+		StartedAt:       finishedAt.Add(-2 * time.Second),
+		ChainsAttempted: []string{"Ethereum", "Arbitrum"},
+	}
+	db.CreateFetchRun(run)
+	run.FinishedAt = &finishedAt
+	run.Received, run.Active, run.Expired, run.Upserted = 12, 10, 2, 10
+	run.Errors = []string{"chain 42161: API returned status 403"}
+	db.FinishFetchRun(run)
+
+	req := httptest.NewRequest("GET", "/admin/fetch-runs?format=json", nil)
+	w := httptest.NewRecorder()
+	handler.HandleFetchRuns(w, req)
+
+	var runs []models.FetchRun
+	if err := json.Unmarshal(w.Body.Bytes(), &runs); err != nil {
+		t.Fatalf("Failed to decode fetch runs: %v", err)
+	}
+	if len(runs) != 1 || runs[0].Expired != 2 || len(runs[0].Errors) != 1 {
+		t.Fatalf("fetch runs = %+v, want the recorded run", runs)
+	}
+
+	req = httptest.NewRequest("GET", "/admin/fetch-runs", nil)
+	w = httptest.NewRecorder()
+	handler.HandleFetchRuns(w, req)
+
+	body := w.Body.String()
+	for _, want := range []string{"Pendle", "status-partial", "Ethereum, Arbitrum", "API returned status 403"} {
+		if !contains(body, want) {
+			t.Errorf("fetch runs page should contain %q", want)
+		}
+	}
+}
+
+// fakeRefresher returns canned fetch runs or an error
+type fakeRefresher struct {
+	runs  []*models.FetchRun
+	err   error
+	chain string
+}
+
+func (f *fakeRefresher) Refresh(chain string) ([]*models.FetchRun, error) {
+	f.chain = chain
+	return f.runs, f.err
+}
+
+// TestHandleRefresh tests the refresh endpoint responses
+func TestHandleRefresh(t *testing.T) {
+	handler, _, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	runs := []*models.FetchRun{{ID: 3, Source: "Pendle", Status: models.FetchRunSuccess, Upserted: 12}}
+
+	tests := []struct {
+		name       string
+		refresher  *fakeRefresher
+		htmx       bool
+		wantStatus int
+		wantBody   string
+	}{
+		{"success", &fakeRefresher{runs: runs}, false, http.StatusOK, `"rows_upserted":12`},
+		{"in progress", &fakeRefresher{err: api.ErrFetchInProgress}, false, http.StatusConflict, "already running"},
+		{"unknown chain", &fakeRefresher{err: fmt.Errorf("%w Narnia", api.ErrUnknownChain)}, false, http.StatusBadRequest, "Narnia"},
+		{"htmx success", &fakeRefresher{runs: runs}, true, http.StatusOK, "12 rates"},
+		{"htmx in progress", &fakeRefresher{err: api.ErrFetchInProgress}, true, http.StatusOK, "Refresh failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler.SetRefresher(tt.refresher)
+
+			req := httptest.NewRequest("POST", "/admin/refresh", strings.NewReader("chain=Arbitrum"))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.htmx {
+				req.Header.Set("HX-Request", "true")
+			}
+			w := httptest.NewRecorder()
+
+			handler.HandleRefresh(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if !contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("body = %s, want it to contain %q", w.Body.String(), tt.wantBody)
+			}
+			if tt.refresher.chain != "Arbitrum" {
+				t.Errorf("refreshed chain = %q, want Arbitrum", tt.refresher.chain)
+			}
+			if tt.htmx && tt.refresher.err == nil && w.Header().Get("HX-Trigger") != "rates-refreshed" {
+				t.Error("HTMX refresh should trigger a table reload")
+			}
+		})
+	}
+}
+
+// TestHandleIndex_RefreshButton tests that only admins see the refresh button
+func TestHandleIndex_RefreshButton(t *testing.T) {
+	handler, db, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	handler.SetAdminToken("secret")
+	handler.SetRefresher(&fakeRefresher{})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	handler.HandleIndex(w, req)
+	if contains(w.Body.String(), "Refresh now") {
+		t.Error("anonymous visitors should not see the refresh button")
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(adminSession(t, db, "secret"))
+	w = httptest.NewRecorder()
+	handler.HandleIndex(w, req)
+	if !contains(w.Body.String(), "Refresh now") {
+		t.Error("admins should see the refresh button")
+	}
+}
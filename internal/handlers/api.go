@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// HandleAPIRates lists yield rates as JSON, taking the same query parameters
+// as the index page
+func (h *Handler) HandleAPIRates(w http.ResponseWriter, r *http.Request) {
+	lastModified := h.cache.lastModified()
+	rates, err := h.cache.yieldRates(h.parseFilterParams(r))
+	if err != nil {
+		requestLogger(r).Error("failed to fetch yield rates", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to fetch yield rates")
+		return
+	}
+	if rates == nil {
+		rates = []models.YieldRate{}
+	}
+
+	writeCacheableJSON(w, r, lastModified, rates)
+}
+
+// HandleAPIRate returns one yield rate as JSON, with its categories and
+// protocol metadata such as the token addresses of a Pendle market
+func (h *Handler) HandleAPIRate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid rate id")
+		return
+	}
+
+	rate, err := h.db.GetYieldRate(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, "yield rate not found")
+			return
+		}
+		requestLogger(r).Error("failed to fetch yield rate", "error", err, "rate_id", id)
+		writeJSONError(w, http.StatusInternalServerError, "failed to fetch yield rate")
+		return
+	}
+	writeJSON(w, http.StatusOK, rate)
+}
+
+// defaultHistoryRange is the range /api/rates/{id}/history covers without
+// a range or from parameter
+const defaultHistoryRange = 7 * 24 * time.Hour
+
+// HandleAPIRateHistory returns the APY and TVL history of a yield rate as
+// JSON. The range is given as range=30d (or a Go duration such as 12h)
+// ending now, or as from and to, RFC 3339 times or dates; long ranges come
+// back as hourly or daily points
+func (h *Handler) HandleAPIRateHistory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid rate id")
+		return
+	}
+	from, to, err := parseHistoryRange(r.URL.Query(), time.Now())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := h.db.GetYieldRate(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, "yield rate not found")
+			return
+		}
+		requestLogger(r).Error("failed to fetch yield rate", "error", err, "rate_id", id)
+		writeJSONError(w, http.StatusInternalServerError, "failed to fetch history")
+		return
+	}
+	history, err := h.db.GetYieldHistory(id, from, to)
+	if err != nil {
+		requestLogger(r).Error("failed to fetch history", "error", err, "rate_id", id)
+		writeJSONError(w, http.StatusInternalServerError, "failed to fetch history")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, history)
+}
+
+// parseHistoryRange reads the range of a history request
+func parseHistoryRange(query url.Values, now time.Time) (from, to time.Time, err error) {
+	to = now
+	if s := query.Get("to"); s != "" {
+		if to, err = parseHistoryTime(s); err != nil {
+			return from, to, fmt.Errorf("invalid to: %w", err)
+		}
+	}
+
+	switch {
+	case query.Get("from") != "":
+		if from, err = parseHistoryTime(query.Get("from")); err != nil {
+			return from, to, fmt.Errorf("invalid from: %w", err)
+		}
+	case query.Get("range") != "":
+		span, err := parseDays(query.Get("range"))
+		if err != nil || span <= 0 {
+			return from, to, fmt.Errorf("invalid range %q, want e.g. 30d or 12h", query.Get("range"))
+		}
+		from = to.Add(-span)
+	default:
+		from = to.Add(-defaultHistoryRange)
+	}
+
+	if !from.Before(to) {
+		return from, to, fmt.Errorf("from must be before to")
+	}
+	return from, to, nil
+}
+
+// parseHistoryTime parses an RFC 3339 time or a date
+func parseHistoryTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, s)
+}
+
+// parseDays is time.ParseDuration that also takes whole days, such as 30d
+func parseDays(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
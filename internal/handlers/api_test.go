@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// TestHandleAPIRateHistory tests the history endpoint and its range parameters
+func TestHandleAPIRateHistory(t *testing.T) {
+	handler, db, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	rate := &models.YieldRate{Asset: "USDC", Chain: "Off-chain", APY: 9, PoolName: "Desk"}
+	if err := db.CreateManualRate(rate); err != nil {
+		t.Fatalf("CreateManualRate() failed: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/rates/{id}/history", handler.HandleAPIRateHistory)
+
+	tests := []struct {
+		name           string
+		url            string
+		wantStatus     int
+		wantResolution string
+	}{
+		{"default week", "/api/rates/1/history", http.StatusOK, models.ResolutionHour},
+		{"last day", "/api/rates/1/history?range=1d", http.StatusOK, models.ResolutionRaw},
+		{"hours", "/api/rates/1/history?range=12h", http.StatusOK, models.ResolutionRaw},
+		{"dates", "/api/rates/1/history?from=2020-01-01&to=2020-06-01", http.StatusOK, models.ResolutionDay},
+		{"invalid range", "/api/rates/1/history?range=soon", http.StatusBadRequest, ""},
+		{"reversed", "/api/rates/1/history?from=2020-06-01&to=2020-01-01", http.StatusBadRequest, ""},
+		{"unknown rate", "/api/rates/99/history", http.StatusNotFound, ""},
+		{"invalid id", "/api/rates/abc/history", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest("GET", tt.url, nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var history models.YieldHistory
+			if err := json.NewDecoder(w.Body).Decode(&history); err != nil {
+				t.Fatalf("failed to decode history: %v", err)
+			}
+			if history.Resolution != tt.wantResolution {
+				t.Errorf("resolution = %s, want %s", history.Resolution, tt.wantResolution)
+			}
+			if tt.wantResolution == models.ResolutionRaw && len(history.Points) != 1 {
+				t.Errorf("points = %+v, want the manual entry's observation", history.Points)
+			}
+		})
+	}
+}
+
+// TestHandleAPIRate tests the rate endpoint and the category filter of the rates endpoint
+func TestHandleAPIRate(t *testing.T) {
+	handler, db, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	protocol := &models.Protocol{Name: "Pendle"}
+	db.CreateOrUpdateProtocol(protocol)
+	market := &models.PendleMarket{Address: "0xmarket", PT: "0xpt", YT: "0xyt", SY: "0xsy", UnderlyingAsset: "0xusde"}
+	db.UpsertYieldRate(&models.YieldRate{ProtocolID: protocol.ID, Asset: "USDe", Chain: "Ethereum", APY: 9, PoolName: "PT-USDe",
+		Categories: []string{"stables"}, Pendle: market})
+	db.UpsertYieldRate(&models.YieldRate{ProtocolID: protocol.ID, Asset: "weETH", Chain: "Ethereum", APY: 4, PoolName: "PT-weETH",
+		Categories: []string{"eth", "points"}})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/rates", handler.HandleAPIRates)
+	mux.HandleFunc("GET /api/rates/{id}", handler.HandleAPIRate)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/rates/1", nil))
+	var rate models.YieldRate
+	if err := json.NewDecoder(w.Body).Decode(&rate); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("GET /api/rates/1 = %d, %v", w.Code, err)
+	}
+	if rate.Pendle == nil || *rate.Pendle != *market || len(rate.Categories) != 1 {
+		t.Errorf("rate = %+v, want the Pendle metadata and category", rate)
+	}
+
+	for url, want := range map[string]int{"/api/rates/99": http.StatusNotFound, "/api/rates/abc": http.StatusBadRequest} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		if w.Code != want {
+			t.Errorf("GET %s = %d, want %d", url, w.Code, want)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/rates?category=Points", nil))
+	var rates []models.YieldRate
+	json.NewDecoder(w.Body).Decode(&rates)
+	if len(rates) != 1 || rates[0].Asset != "weETH" {
+		t.Errorf("rates in points = %+v, want weETH", rates)
+	}
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pretty-andrechal/defirates/internal/database"
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// principal is who a request acts for: a user, through a session or an API
+// key, or the holder of the admin token
+type principal struct {
+	user   *models.User   // nil for the admin token
+	apiKey *models.APIKey // set when authenticated with an API key
+	scopes []string
+}
+
+// principalKey is the context key of the request's principal
+type principalKey struct{}
+
+// principal returns who the request acts for, or nil for anonymous requests
+func (h *Handler) principal(r *http.Request) *principal {
+	if p, ok := r.Context().Value(principalKey{}).(*principal); ok {
+		return p
+	}
+	return h.authenticate(r)
+}
+
+// authenticate checks, in order, an API key, the admin token and the session
+// cookie. Invalid credentials are treated as none
+func (h *Handler) authenticate(r *http.Request) *principal {
+	token := r.Header.Get("X-API-Key")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		token = bearer
+	}
+	if strings.HasPrefix(token, database.APIKeyPrefix) {
+		user, key, err := h.db.AuthenticateAPIKey(token)
+		if err != nil {
+			if !errors.Is(err, database.ErrInvalidCredentials) {
+				requestLogger(r).Error("failed to check API key", "error", err)
+			}
+			return nil
+		}
+		// A key never grants more than its user currently has
+		var scopes []string
+		for _, scope := range key.Scopes {
+			if models.HasScope(user.Scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+		return &principal{user: user, apiKey: key, scopes: scopes}
+	}
+
+	if h.isAdmin(r) {
+		return &principal{scopes: []string{models.ScopeAdmin}}
+	}
+
+	if cookie, err := r.Cookie(sessionCookie); err == nil && cookie.Value != "" {
+		user, err := h.db.GetSessionUser(cookie.Value)
+		if err == nil {
+			return &principal{user: user, scopes: user.Scopes}
+		}
+	}
+	return nil
+}
+
+// RequireScope wraps a handler so it's only reachable by users and API keys
+// with the given scope. Browsers asking for HTML are sent to the login page
+func (h *Handler) RequireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return h.requireScope(scope, "/login", next)
+}
+
+// requireScope implements RequireScope and RequireAdmin, which send browsers
+// to different login pages
+func (h *Handler) requireScope(scope, loginPath string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := h.principal(r)
+		if p == nil {
+			if r.Method == http.MethodGet && !wantsJSON(r) {
+				http.Redirect(w, r, loginPath+"?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="defirates"`)
+			writeJSONError(w, http.StatusUnauthorized, "missing or invalid credentials")
+			return
+		}
+		if !models.HasScope(p.scopes, scope) {
+			writeJSONError(w, http.StatusForbidden, "requires the "+scope+" scope")
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	}
+}
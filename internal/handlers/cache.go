@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/database"
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+const (
+	// cacheMaxAge bounds how long query results are kept without a fetch
+	// run, as their 24h and 7d changes are relative to when they were read
+	cacheMaxAge = 5 * time.Minute
+	// cacheMaxEntries bounds how many filter combinations are kept
+	cacheMaxEntries = 256
+)
+
+// cachedRates are the results of one GetYieldRates query
+type cachedRates struct {
+	rates  []models.YieldRate
+	loaded time.Time
+}
+
+// queryCache keeps the results of the dashboard queries until the rates in
+// the database change. Every invalidation starts a new generation; results
+// read during an older generation are not stored, so a query racing a fetch
+// run can't bring stale rates back
+type queryCache struct {
+	db  database.Store
+	now func() time.Time
+
+	mu         sync.Mutex
+	generation uint64
+	modified   time.Time
+	rates      map[string]cachedRates
+	assets     []string
+	chains     []string
+	categories []string
+	listed     time.Time
+}
+
+// newQueryCache creates an empty cache in front of db
+func newQueryCache(db database.Store) *queryCache {
+	c := &queryCache{db: db, now: time.Now}
+	c.reset()
+	return c
+}
+
+// reset empties the cache and marks the data as modified now. Callers hold
+// c.mu, except newQueryCache
+func (c *queryCache) reset() {
+	c.generation++
+	// Last-Modified has whole seconds, so two changes within a second must
+	// still get different times for If-Modified-Since to notice
+	modified := c.now().UTC().Truncate(time.Second)
+	if !modified.After(c.modified) && !c.modified.IsZero() {
+		modified = c.modified.Add(time.Second)
+	}
+	c.modified = modified
+	c.rates = make(map[string]cachedRates)
+	c.assets, c.chains, c.categories = nil, nil, nil
+}
+
+// invalidate drops every cached result
+func (c *queryCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reset()
+}
+
+// lastModified returns when the cached data last changed, to the second as
+// the Last-Modified header has it
+func (c *queryCache) lastModified() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.modified
+}
+
+// fresh reports whether a result loaded at loaded can still be used
+func (c *queryCache) fresh(loaded time.Time) bool {
+	return c.now().Sub(loaded) < cacheMaxAge
+}
+
+// yieldRates returns the rates matching filters. The slice is a copy the
+// caller may reorder; the rates themselves are shared and must not be changed
+func (c *queryCache) yieldRates(filters models.FilterParams) ([]models.YieldRate, error) {
+	key := filterValues(filters).Encode()
+
+	c.mu.Lock()
+	entry, ok := c.rates[key]
+	generation := c.generation
+	c.mu.Unlock()
+	if ok && c.fresh(entry.loaded) {
+		return append([]models.YieldRate(nil), entry.rates...), nil
+	}
+
+	rates, err := c.db.GetYieldRates(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if c.generation == generation {
+		if len(c.rates) >= cacheMaxEntries {
+			clear(c.rates)
+		}
+		c.rates[key] = cachedRates{rates: rates, loaded: c.now()}
+	}
+	c.mu.Unlock()
+	return append([]models.YieldRate(nil), rates...), nil
+}
+
+// filterOptions returns the distinct assets, chains and categories for the
+// filter dropdowns
+func (c *queryCache) filterOptions() (assets, chains, categories []string, err error) {
+	c.mu.Lock()
+	if c.assets != nil && c.chains != nil && c.categories != nil && c.fresh(c.listed) {
+		assets, chains, categories = c.assets, c.chains, c.categories
+		c.mu.Unlock()
+		return assets, chains, categories, nil
+	}
+	generation := c.generation
+	c.mu.Unlock()
+
+	if assets, err = c.db.GetDistinctAssets(); err != nil {
+		return nil, nil, nil, err
+	}
+	if chains, err = c.db.GetDistinctChains(); err != nil {
+		return nil, nil, nil, err
+	}
+	if categories, err = c.db.GetDistinctCategories(); err != nil {
+		return nil, nil, nil, err
+	}
+	if assets == nil {
+		assets = []string{}
+	}
+	if chains == nil {
+		chains = []string{}
+	}
+	if categories == nil {
+		categories = []string{}
+	}
+
+	c.mu.Lock()
+	if c.generation == generation {
+		c.assets, c.chains, c.categories, c.listed = assets, chains, categories, c.now()
+	}
+	c.mu.Unlock()
+	return assets, chains, categories, nil
+}
+
+// Publish drops the cached query results after a fetch run stored rates. It
+// makes Handler an api.Publisher, to be told about fetch runs by Fetcher
+func (h *Handler) Publish(update models.RatesUpdate) {
+	h.cache.invalidate()
+}
+
+// serveCacheable writes a 200 response with an ETag derived from body and
+// lastModified, or a 304 when the request's If-None-Match or
+// If-Modified-Since shows the client already has it
+func serveCacheable(w http.ResponseWriter, r *http.Request, contentType string, lastModified time.Time, body []byte) {
+	sum := sha256.Sum256(body)
+	header := w.Header()
+	header.Set("Content-Type", contentType)
+	header.Set("ETag", `"`+base64.RawURLEncoding.EncodeToString(sum[:16])+`"`)
+	// Browsers revalidate every time, so new rates show up right away
+	header.Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, "", lastModified, bytes.NewReader(body))
+}
+
+// writeCacheableJSON is writeJSON for GET responses supporting conditional requests
+func writeCacheableJSON(w http.ResponseWriter, r *http.Request, lastModified time.Time, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		requestLogger(r).Error("failed to encode JSON response", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to encode response")
+		return
+	}
+	serveCacheable(w, r, "application/json", lastModified, append(body, '\n'))
+}
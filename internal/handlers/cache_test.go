@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// TestQueryCache tests that the dashboard serves cached rates until a fetch
+// run stores new ones
+func TestQueryCache(t *testing.T) {
+	handler, db, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	protocol := &models.Protocol{Name: "TestProtocol"}
+	db.CreateOrUpdateProtocol(protocol)
+	db.UpsertYieldRate(&models.YieldRate{ProtocolID: protocol.ID, Asset: "ETH", Chain: "Ethereum", APY: 5, PoolName: "Cached-Pool"})
+
+	get := func(target string) string {
+		w := httptest.NewRecorder()
+		handler.HandleIndex(w, httptest.NewRequest("GET", target, nil))
+		return w.Body.String()
+	}
+
+	// Equivalent filters share a cache entry
+	get("/?asset=ETH")
+	db.UpsertYieldRate(&models.YieldRate{ProtocolID: protocol.ID, Asset: "ETH", Chain: "Arbitrum", APY: 7, PoolName: "New-Pool"})
+	if body := get("/?sort_by=apy&asset=ETH&sort_order=desc"); contains(body, "New-Pool") || !contains(body, "Cached-Pool") {
+		t.Error("rates stored outside a fetch run should not show before the cache is invalidated")
+	}
+	if body := get("/?asset=ETH"); contains(body, "Arbitrum") {
+		t.Error("the chain dropdown should come from the cache too")
+	}
+
+	handler.Publish(models.RatesUpdate{Source: "test"})
+	if body := get("/?asset=ETH"); !contains(body, "New-Pool") || !contains(body, "Arbitrum") {
+		t.Error("rates should be reloaded after a fetch run")
+	}
+
+	// Cached results expire so the 24h and 7d changes stay current
+	now := time.Now()
+	handler.cache.now = func() time.Time { return now }
+	get("/")
+	db.UpsertYieldRate(&models.YieldRate{ProtocolID: protocol.ID, Asset: "USDC", Chain: "Ethereum", APY: 9, PoolName: "Late-Pool"})
+	now = now.Add(cacheMaxAge)
+	if body := get("/"); !contains(body, "Late-Pool") {
+		t.Error("cached rates should expire after cacheMaxAge")
+	}
+}
+
+// TestConditionalRequests tests ETag and Last-Modified on the dashboard and API
+func TestConditionalRequests(t *testing.T) {
+	handler, db, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	protocol := &models.Protocol{Name: "TestProtocol"}
+	db.CreateOrUpdateProtocol(protocol)
+	db.UpsertYieldRate(&models.YieldRate{ProtocolID: protocol.ID, Asset: "ETH", Chain: "Ethereum", APY: 5, PoolName: "Pool-1"})
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+		htmx    bool
+	}{
+		{"index page", handler.HandleIndex, "/", false},
+		{"table partial", handler.HandleIndex, "/?asset=ETH", true},
+		{"JSON API", handler.HandleAPIRates, "/api/rates", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			do := func(header, value string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest("GET", tt.target, nil)
+				if tt.htmx {
+					req.Header.Set("HX-Request", "true")
+				}
+				if header != "" {
+					req.Header.Set(header, value)
+				}
+				w := httptest.NewRecorder()
+				tt.handler(w, req)
+				return w
+			}
+
+			w := do("", "")
+			etag, lastModified := w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+			if w.Code != http.StatusOK || etag == "" || lastModified == "" {
+				t.Fatalf("status = %d, ETag = %q, Last-Modified = %q, want 200 with both", w.Code, etag, lastModified)
+			}
+
+			if w := do("If-None-Match", etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+				t.Errorf("If-None-Match with the current ETag status = %d, want %d", w.Code, http.StatusNotModified)
+			}
+			if w := do("If-Modified-Since", lastModified); w.Code != http.StatusNotModified {
+				t.Errorf("If-Modified-Since with Last-Modified status = %d, want %d", w.Code, http.StatusNotModified)
+			}
+			if w := do("If-None-Match", `"stale"`); w.Code != http.StatusOK {
+				t.Errorf("If-None-Match with another ETag status = %d, want %d", w.Code, http.StatusOK)
+			}
+
+			// New rates change the ETag
+			db.UpsertYieldRate(&models.YieldRate{ProtocolID: protocol.ID, Asset: "ETH", Chain: "Base", APY: 6, PoolName: "Pool-" + tt.name})
+			handler.Publish(models.RatesUpdate{Source: "test"})
+			if w := do("If-None-Match", etag); w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+				t.Errorf("after a fetch run status = %d with ETag %s, want 200 with a new ETag", w.Code, w.Header().Get("ETag"))
+			}
+		})
+	}
+}
+
+// TestConditionalRequests_Profile tests that pages of a profile are only
+// validated by their ETag, which follows the watchlist
+func TestConditionalRequests_Profile(t *testing.T) {
+	handler, db, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	protocol := &models.Protocol{Name: "TestProtocol"}
+	db.CreateOrUpdateProtocol(protocol)
+	rate := &models.YieldRate{ProtocolID: protocol.ID, Asset: "ETH", Chain: "Ethereum", APY: 5, PoolName: "Pool-1"}
+	db.UpsertYieldRate(rate)
+	profile, err := db.CreateProfile()
+	if err != nil {
+		t.Fatalf("CreateProfile() failed: %v", err)
+	}
+
+	get := func(header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(&http.Cookie{Name: profileCookie, Value: profile.Token})
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		handler.HandleIndex(w, req)
+		return w
+	}
+
+	w := get("", "")
+	if w.Header().Get("Last-Modified") != "" {
+		t.Errorf("Last-Modified = %q, want none for a profile", w.Header().Get("Last-Modified"))
+	}
+	if vary := strings.Join(w.Header().Values("Vary"), ","); !strings.Contains(vary, "Cookie") {
+		t.Errorf("Vary = %q, want Cookie", vary)
+	}
+
+	etag := w.Header().Get("ETag")
+	if err := db.AddToWatchlist(profile.ID, rate.ID); err != nil {
+		t.Fatalf("AddToWatchlist() failed: %v", err)
+	}
+	if w := get("If-None-Match", etag); w.Code != http.StatusOK {
+		t.Errorf("after watching a rate status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := get("If-Modified-Since", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)); w.Code != http.StatusOK {
+		t.Errorf("If-Modified-Since status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+// TestManualRateInvalidatesCache tests that manual entries show up right away
+func TestManualRateInvalidatesCache(t *testing.T) {
+	handler, _, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	index := func() string {
+		w := httptest.NewRecorder()
+		handler.HandleIndex(w, httptest.NewRequest("GET", "/", nil))
+		return w.Body.String()
+	}
+
+	index()
+	req := httptest.NewRequest("POST", "/admin/manual-rates", strings.NewReader(`{"asset": "USDC", "chain": "Off-chain", "apy": 9.5, "pool_name": "Desk"}`))
+	w := httptest.NewRecorder()
+	handler.HandleCreateManualRate(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create status = %d: %s", w.Code, w.Body.String())
+	}
+	if !contains(index(), "Off-chain") {
+		t.Error("a new manual rate should invalidate the cache")
+	}
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// DefaultMaxTVLShare is the share of a pool's TVL above which the
+// calculator warns that a deposit would move the pool's rate
+const DefaultMaxTVLShare = 0.05
+
+// maxHorizonDays bounds the horizon of the calculator
+const maxHorizonDays = 10 * 365
+
+// calculatorRows is how many pools the calculator widget shows
+const calculatorRows = 10
+
+// SetMaxTVLShare sets the share of a pool's TVL above which the calculator
+// warns about a deposit
+func (h *Handler) SetMaxTVLShare(share float64) {
+	h.maxTVLShare = share
+}
+
+// calculation is a calculator request: an amount and a horizon in days, 0
+// meaning to maturity
+type calculation struct {
+	amount float64
+	days   int
+}
+
+// parseCalculation reads the amount and horizon of a calculator request:
+// amount, and days or to_maturity
+func parseCalculation(values url.Values) (calculation, error) {
+	var calc calculation
+	amount, err := strconv.ParseFloat(values.Get("amount"), 64)
+	if err != nil || amount <= 0 {
+		return calc, errors.New("amount must be a positive number")
+	}
+	calc.amount = amount
+
+	if toMaturity, _ := strconv.ParseBool(values.Get("to_maturity")); toMaturity || values.Get("to_maturity") == "on" {
+		return calc, nil
+	}
+	days, err := strconv.Atoi(values.Get("days"))
+	if err != nil || days <= 0 || days > maxHorizonDays {
+		return calc, errors.New("days must be a whole number from 1 to 3650, or to_maturity set")
+	}
+	calc.days = days
+	return calc, nil
+}
+
+// projections projects calc for the rates matching filters, highest return
+// first. Projecting to maturity only takes rates with a maturity
+func (h *Handler) projections(filters models.FilterParams, calc calculation) ([]models.Projection, error) {
+	if calc.days == 0 {
+		filters.Term = models.TermFixed
+	}
+	rates, err := h.cache.yieldRates(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	projections := []models.Projection{}
+	for _, rate := range rates {
+		if p, ok := models.ProjectReturn(rate, calc.amount, calc.days, h.maxTVLShare, now); ok {
+			projections = append(projections, p)
+		}
+	}
+	sort.SliceStable(projections, func(i, j int) bool {
+		return projections[i].Return > projections[j].Return
+	})
+	return projections, nil
+}
+
+// HandleAPICalculator projects the absolute return of depositing amount for
+// days, or to_maturity, into each rate matching the filters of the index
+// page, as JSON
+func (h *Handler) HandleAPICalculator(w http.ResponseWriter, r *http.Request) {
+	calc, err := parseCalculation(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	projections, err := h.projections(h.parseFilterParams(r), calc)
+	if err != nil {
+		requestLogger(r).Error("failed to project returns", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to project returns")
+		return
+	}
+	writeJSON(w, http.StatusOK, projections)
+}
+
+// HandleCalculator renders the results of the calculator widget: the pools
+// with the highest projected return for the current filters
+func (h *Handler) HandleCalculator(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Projections []models.Projection
+		Total       int
+		MaxTVLShare float64
+		Error       string
+	}{
+		MaxTVLShare: h.maxTVLShare,
+	}
+
+	// Errors are shown in the widget, as HTMX doesn't swap error responses
+	if calc, err := parseCalculation(r.URL.Query()); err != nil {
+		data.Error = err.Error()
+	} else if projections, err := h.projections(h.parseFilterParams(r), calc); err != nil {
+		requestLogger(r).Error("failed to project returns", "error", err)
+		data.Error = "Failed to project returns"
+	} else {
+		data.Total = len(projections)
+		data.Projections = projections[:min(len(projections), calculatorRows)]
+	}
+
+	if err := h.templates.ExecuteTemplate(w, "calculator.html", data); err != nil {
+		requestLogger(r).Error("failed to execute template", "error", err)
+		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// TestHandleCalculator tests the calculator endpoint and widget
+func TestHandleCalculator(t *testing.T) {
+	handler, db, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	protocol := &models.Protocol{Name: "Pendle"}
+	db.CreateOrUpdateProtocol(protocol)
+	maturity := time.Now().AddDate(0, 0, 90)
+	db.UpsertYieldRate(&models.YieldRate{ProtocolID: protocol.ID, Asset: "sUSDe", Chain: "Ethereum", APY: 20, TVL: 5e6,
+		PoolName: "PT-sUSDe", MaturityDate: &maturity})
+	db.UpsertYieldRate(&models.YieldRate{ProtocolID: protocol.ID, Asset: "USDC", Chain: "Ethereum", APY: 5, TVL: 1e5,
+		PoolName: "USDC"})
+
+	tests := []struct {
+		name        string
+		url         string
+		wantStatus  int
+		wantPools   []string
+		wantExceeds []bool
+	}{
+		{"horizon", "/api/calculator?amount=10000&days=30", http.StatusOK, []string{"PT-sUSDe", "USDC"}, []bool{false, true}},
+		{"to maturity", "/api/calculator?amount=10000&to_maturity=true", http.StatusOK, []string{"PT-sUSDe"}, []bool{false}},
+		{"filtered", "/api/calculator?amount=10000&days=30&asset=USDC", http.StatusOK, []string{"USDC"}, []bool{true}},
+		{"missing amount", "/api/calculator?days=30", http.StatusBadRequest, nil, nil},
+		{"negative amount", "/api/calculator?amount=-5&days=30", http.StatusBadRequest, nil, nil},
+		{"missing horizon", "/api/calculator?amount=10000", http.StatusBadRequest, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.HandleAPICalculator(w, httptest.NewRequest("GET", tt.url, nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var projections []models.Projection
+			if err := json.NewDecoder(w.Body).Decode(&projections); err != nil {
+				t.Fatalf("failed to decode projections: %v", err)
+			}
+			if len(projections) != len(tt.wantPools) {
+				t.Fatalf("projections = %+v, want %v", projections, tt.wantPools)
+			}
+			for i, p := range projections {
+				if p.PoolName != tt.wantPools[i] || p.ExceedsTVL != tt.wantExceeds[i] {
+					t.Errorf("projection %d = %s (exceeds TVL %v), want %s (%v)", i, p.PoolName, p.ExceedsTVL, tt.wantPools[i], tt.wantExceeds[i])
+				}
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	handler.HandleCalculator(w, httptest.NewRequest("GET", "/calculator?amount=10000&to_maturity=on", nil))
+	if body := w.Body.String(); !strings.Contains(body, "PT-sUSDe") || !strings.Contains(body, "to maturity") {
+		t.Errorf("widget = %s, want the PT projected to maturity", body)
+	}
+
+	w = httptest.NewRecorder()
+	handler.HandleCalculator(w, httptest.NewRequest("GET", "/calculator?amount=abc&days=30", nil))
+	if body := w.Body.String(); w.Code != http.StatusOK || !strings.Contains(body, "amount must be a positive number") {
+		t.Errorf("widget = %d %s, want the error", w.Code, body)
+	}
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// curveFilters returns the filters of a curves request: those of the index
+// page, limited to rates with a maturity
+func (h *Handler) curveFilters(r *http.Request) models.FilterParams {
+	filters := h.parseFilterParams(r)
+	filters.Term = models.TermFixed
+	filters.SortBy = "maturity"
+	filters.SortOrder = "asc"
+	return filters
+}
+
+// yieldCurves returns the yield curves of the rates matching filters
+func (h *Handler) yieldCurves(filters models.FilterParams) ([]models.YieldCurve, error) {
+	rates, err := h.cache.yieldRates(filters)
+	if err != nil {
+		return nil, err
+	}
+	return models.BuildYieldCurves(rates, time.Now()), nil
+}
+
+// HandleAPICurves returns the yield curves the curves page plots as JSON,
+// taking the filters of the index page
+func (h *Handler) HandleAPICurves(w http.ResponseWriter, r *http.Request) {
+	lastModified := h.cache.lastModified()
+	curves, err := h.yieldCurves(h.curveFilters(r))
+	if err != nil {
+		requestLogger(r).Error("failed to fetch yield curves", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to fetch yield curves")
+		return
+	}
+
+	writeCacheableJSON(w, r, lastModified, curves)
+}
+
+// curveView is a yield curve as the curves page renders it
+type curveView struct {
+	models.YieldCurve
+	Chart  template.HTML
+	Legend []legendEntry
+}
+
+type legendEntry struct {
+	Chain string
+	Color string
+}
+
+// HandleCurves serves the term structure page: a chart of APY against days
+// to maturity per asset, one line per chain
+func (h *Handler) HandleCurves(w http.ResponseWriter, r *http.Request) {
+	filters := h.curveFilters(r)
+	curves, err := h.yieldCurves(filters)
+	if err != nil {
+		requestLogger(r).Error("failed to fetch yield curves", "error", err)
+		http.Error(w, "Failed to fetch yield curves", http.StatusInternalServerError)
+		return
+	}
+
+	assets, chains, _, err := h.cache.filterOptions()
+	if err != nil {
+		requestLogger(r).Error("failed to fetch filter options", "error", err)
+		assets, chains = []string{}, []string{}
+	}
+
+	// Chains keep their colour from chart to chart
+	colors := map[string]string{}
+	for i, chain := range chains {
+		colors[chain] = curveColors[i%len(curveColors)]
+	}
+	views := make([]curveView, 0, len(curves))
+	for _, curve := range curves {
+		view := curveView{YieldCurve: curve, Chart: curveSVG(curve, colors)}
+		for _, series := range curve.Series {
+			view.Legend = append(view.Legend, legendEntry{Chain: series.Chain, Color: seriesColor(colors, series.Chain)})
+		}
+		views = append(views, view)
+	}
+
+	query := filterValues(filters)
+	query.Del("term")
+	query.Del("sort_by")
+	query.Del("sort_order")
+	p := h.principal(r)
+	data := struct {
+		Curves  []curveView
+		Assets  []string
+		Chains  []string
+		Filters models.FilterParams
+		Query   string
+		CanRead bool // The JSON API takes the read scope, unlike the page
+	}{
+		Curves:  views,
+		Assets:  assets,
+		Chains:  chains,
+		Filters: filters,
+		Query:   query.Encode(),
+		CanRead: p != nil && models.HasScope(p.scopes, models.ScopeRead),
+	}
+	if err := h.templates.ExecuteTemplate(w, "curves.html", data); err != nil {
+		requestLogger(r).Error("failed to execute template", "error", err)
+		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+	}
+}
+
+// curveColors are the line colours of the chains on the curves page
+var curveColors = []string{"#4f46e5", "#10b981", "#f59e0b", "#ef4444", "#0ea5e9", "#a855f7", "#64748b", "#ec4899"}
+
+// seriesColor returns the colour of chain, grey for chains without one
+func seriesColor(colors map[string]string, chain string) string {
+	if color, ok := colors[chain]; ok {
+		return color
+	}
+	return "#94a3b8"
+}
+
+// Dimensions of the curve charts
+const (
+	chartWidth  = 640
+	chartHeight = 280
+	chartLeft   = 56
+	chartRight  = 16
+	chartTop    = 16
+	chartBottom = 40
+)
+
+// curveSVG renders curve as an SVG line chart of APY against days to
+// maturity. Each point has a tooltip with its pool, maturity and APY
+func curveSVG(curve models.YieldCurve, colors map[string]string) template.HTML {
+	maxDays, minAPY, maxAPY := 0, math.Inf(1), math.Inf(-1)
+	for _, series := range curve.Series {
+		for _, p := range series.Points {
+			maxDays = max(maxDays, p.DaysToMaturity)
+			minAPY = math.Min(minAPY, p.APY)
+			maxAPY = math.Max(maxAPY, p.APY)
+		}
+	}
+
+	dayStep := math.Max(1, niceStep(float64(maxDays)/4))
+	xMax := math.Ceil(float64(maxDays)/dayStep) * dayStep
+	apyStep := niceStep((maxAPY - minAPY) / 4)
+	yMin := math.Max(0, math.Floor(minAPY/apyStep)*apyStep-apyStep)
+	yMax := math.Ceil(maxAPY/apyStep)*apyStep + apyStep
+
+	plotWidth := float64(chartWidth - chartLeft - chartRight)
+	plotHeight := float64(chartHeight - chartTop - chartBottom)
+	x := func(days float64) float64 {
+		return chartLeft + days/xMax*plotWidth
+	}
+	y := func(apy float64) float64 {
+		return chartTop + (yMax-apy)/(yMax-yMin)*plotHeight
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg class="curve-chart" viewBox="0 0 %d %d" role="img" aria-label="%s yield curve">`,
+		chartWidth, chartHeight, template.HTMLEscapeString(curve.Asset))
+
+	// Grid and axes
+	for apy := yMin; apy <= yMax+apyStep/2; apy += apyStep {
+		fmt.Fprintf(&b, `<line class="curve-grid" x1="%d" y1="%.1f" x2="%d" y2="%.1f"/>`,
+			chartLeft, y(apy), chartWidth-chartRight, y(apy))
+		fmt.Fprintf(&b, `<text class="curve-tick" x="%d" y="%.1f" text-anchor="end" dominant-baseline="middle">%s%%</text>`,
+			chartLeft-6, y(apy), formatTick(apy))
+	}
+	for days := 0.0; days <= xMax; days += dayStep {
+		fmt.Fprintf(&b, `<text class="curve-tick" x="%.1f" y="%d" text-anchor="middle">%.0fd</text>`,
+			x(days), chartHeight-chartBottom+16, days)
+	}
+	fmt.Fprintf(&b, `<line class="curve-axis" x1="%d" y1="%d" x2="%d" y2="%d"/>`,
+		chartLeft, chartHeight-chartBottom, chartWidth-chartRight, chartHeight-chartBottom)
+	fmt.Fprintf(&b, `<text class="curve-label" x="%.1f" y="%d" text-anchor="middle">Days to maturity</text>`,
+		chartLeft+plotWidth/2, chartHeight-4)
+
+	// A line and markers per chain
+	for _, series := range curve.Series {
+		color := seriesColor(colors, series.Chain)
+		if len(series.Points) > 1 {
+			points := make([]string, len(series.Points))
+			for i, p := range series.Points {
+				points[i] = fmt.Sprintf("%.1f,%.1f", x(float64(p.DaysToMaturity)), y(p.APY))
+			}
+			fmt.Fprintf(&b, `<polyline class="curve-line" fill="none" stroke="%s" points="%s"/>`,
+				color, strings.Join(points, " "))
+		}
+		for _, p := range series.Points {
+			fmt.Fprintf(&b, `<circle class="curve-point" cx="%.1f" cy="%.1f" r="4" fill="%s"><title>%s on %s: %.2f%% APY, matures %s (%dd)</title></circle>`,
+				x(float64(p.DaysToMaturity)), y(p.APY), color,
+				template.HTMLEscapeString(p.PoolName), template.HTMLEscapeString(series.Chain),
+				p.APY, p.MaturityDate.Format("Jan 02, 2006"), p.DaysToMaturity)
+		}
+	}
+
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// niceStep rounds a raw axis step up to 1, 2 or 5 times a power of ten
+func niceStep(raw float64) float64 {
+	if raw <= 0 {
+		return 1
+	}
+	magnitude := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, m := range []float64{1, 2, 5} {
+		if raw <= m*magnitude {
+			return m * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+// formatTick formats an APY axis value without needless decimals
+func formatTick(apy float64) string {
+	if apy == math.Trunc(apy) {
+		return fmt.Sprintf("%.0f", apy)
+	}
+	return strings.TrimRight(fmt.Sprintf("%.2f", apy), "0")
+}
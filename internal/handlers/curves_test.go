@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// TestHandleCurves tests the yield curves page and its JSON
+func TestHandleCurves(t *testing.T) {
+	handler, db, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	protocol := &models.Protocol{Name: "Pendle"}
+	db.CreateOrUpdateProtocol(protocol)
+	for i, rate := range []models.YieldRate{
+		{Asset: "sUSDe", Chain: "Ethereum", APY: 10, PoolName: "sUSDe-short"},
+		{Asset: "sUSDe", Chain: "Ethereum", APY: 12, PoolName: "sUSDe-long"},
+		{Asset: "sUSDe", Chain: "Arbitrum", APY: 11, PoolName: "sUSDe-arb"},
+	} {
+		maturity := time.Now().AddDate(0, 0, 30*(i+1))
+		rate.ProtocolID, rate.MaturityDate = protocol.ID, &maturity
+		db.UpsertYieldRate(&rate)
+	}
+	db.UpsertYieldRate(&models.YieldRate{ProtocolID: protocol.ID, Asset: "USDC", Chain: "Ethereum", APY: 5, PoolName: "USDC"})
+
+	tests := []struct {
+		name       string
+		url        string
+		wantChains []string
+	}{
+		{"all chains", "/api/curves", []string{"Arbitrum", "Ethereum"}},
+		{"one chain", "/api/curves?chain=Ethereum", []string{"Ethereum"}},
+		{"variable term ignored", "/api/curves?term=variable", []string{"Arbitrum", "Ethereum"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.HandleAPICurves(w, httptest.NewRequest("GET", tt.url, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", w.Code, w.Body.String())
+			}
+			var curves []models.YieldCurve
+			if err := json.NewDecoder(w.Body).Decode(&curves); err != nil {
+				t.Fatalf("failed to decode curves: %v", err)
+			}
+			if len(curves) != 1 || curves[0].Asset != "sUSDe" {
+				t.Fatalf("curves = %+v, want sUSDe only", curves)
+			}
+			var chains []string
+			for _, series := range curves[0].Series {
+				chains = append(chains, series.Chain)
+			}
+			if strings.Join(chains, ",") != strings.Join(tt.wantChains, ",") {
+				t.Errorf("chains = %v, want %v", chains, tt.wantChains)
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	handler.HandleCurves(w, httptest.NewRequest("GET", "/curves", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /curves = %d: %s", w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	for _, want := range []string{"<svg", "<polyline", "sUSDe-long on Ethereum"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page does not contain %q", want)
+		}
+	}
+
+	// Only visitors who may read the JSON API get a link to it
+	if strings.Contains(body, `href="/api/curves`) {
+		t.Error("anonymous visitors should not get a link to /api/curves")
+	}
+	handler.SetAdminToken("secret")
+	req := httptest.NewRequest("GET", "/curves", nil)
+	req.AddCookie(adminSession(t, db, "secret"))
+	w = httptest.NewRecorder()
+	handler.HandleCurves(w, req)
+	if !strings.Contains(w.Body.String(), `href="/api/curves`) {
+		t.Error("admins should get a link to /api/curves")
+	}
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// Subscriber hands out live rate updates. It is implemented by events.Broker
+type Subscriber interface {
+	Subscribe() (<-chan models.RatesUpdate, func())
+}
+
+// SetEvents enables the /events stream and live table updates
+func (h *Handler) SetEvents(events Subscriber) {
+	h.events = events
+}
+
+// sseHeartbeat is how often an idle event stream sends a comment, which
+// keeps proxies from closing it and detects disconnected clients
+const sseHeartbeat = 30 * time.Second
+
+// HandleEvents streams a "rates-updated" server-sent event carrying a JSON
+// models.RatesUpdate whenever a fetch run stores rates
+func (h *Handler) HandleEvents(w http.ResponseWriter, r *http.Request) {
+	if h.events == nil {
+		http.Error(w, "Live updates are disabled", http.StatusNotFound)
+		return
+	}
+
+	// The stream outlives the server write timeout
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	updates, unsubscribe := h.events.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 5000\n\n")
+	if err := rc.Flush(); err != nil {
+		requestLogger(r).Error("event stream can't be flushed", "error", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(update)
+			if err != nil {
+				requestLogger(r).Error("failed to encode rates update", "error", err)
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: rates-updated\ndata: %s\n\n", update.RunID, data)
+
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
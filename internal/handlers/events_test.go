@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/events"
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// TestHandleEvents tests that rate updates are streamed to every client and
+// that disconnected clients are unsubscribed
+func TestHandleEvents(t *testing.T) {
+	handler, _, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	broker := events.NewBroker()
+	handler.SetEvents(broker)
+
+	server := httptest.NewServer(LogRequests(http.HandlerFunc(handler.HandleEvents)))
+	defer server.Close()
+
+	// Two tabs connect
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var streams []*bufio.Reader
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Fatalf("Content-Type = %s, want text/event-stream", ct)
+		}
+
+		stream := bufio.NewReader(resp.Body)
+		if line, _ := stream.ReadString('\n'); !strings.HasPrefix(line, "retry:") {
+			t.Fatalf("first line = %q, want the retry hint", line)
+		}
+		stream.ReadString('\n')
+		streams = append(streams, stream)
+	}
+
+	waitFor(t, func() bool { return broker.Subscribers() == 2 })
+
+	broker.Publish(models.RatesUpdate{
+		Source: "Pendle",
+		RunID:  9,
+		Changes: []models.RateChange{
+			{ID: 4, PoolName: "PT-ETH", Chain: "Ethereum", OldAPY: 10, APY: 12, Direction: models.DirectionUp},
+		},
+	})
+
+	for i, stream := range streams {
+		var lines []string
+		for len(lines) < 3 {
+			line, err := stream.ReadString('\n')
+			if err != nil {
+				t.Fatalf("stream %d: %v", i, err)
+			}
+			lines = append(lines, strings.TrimSpace(line))
+		}
+
+		if lines[0] != "id: 9" || lines[1] != "event: rates-updated" {
+			t.Errorf("stream %d event header = %v", i, lines[:2])
+		}
+
+		var update models.RatesUpdate
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[2], "data: ")), &update); err != nil {
+			t.Fatalf("stream %d data is not JSON: %v", i, err)
+		}
+		if len(update.Changes) != 1 || update.Changes[0].Direction != models.DirectionUp {
+			t.Errorf("stream %d update = %+v, want the ETH move", i, update)
+		}
+	}
+
+	// Closing the tabs unsubscribes them
+	cancel()
+	waitFor(t, func() bool { return broker.Subscribers() == 0 })
+}
+
+// waitFor polls cond for up to a second
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within 1s")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestHandleEvents_Disabled tests the stream without a broker
+func TestHandleEvents_Disabled(t *testing.T) {
+	handler, _, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	w := httptest.NewRecorder()
+	handler.HandleEvents(w, httptest.NewRequest("GET", "/events", nil))
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
@@ -1,12 +1,14 @@
 package handlers
 
 import (
+	"bytes"
 	"embed"
 	"html/template"
-	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pretty-andrechal/defirates/internal/database"
 	"github.com/pretty-andrechal/defirates/internal/models"
@@ -17,12 +19,19 @@ var templatesFS embed.FS
 
 // Handler manages HTTP requests
 type Handler struct {
-	db        *database.DB
-	templates *template.Template
+	db         database.Store
+	templates  *template.Template
+	adminToken string
+	refresher  Refresher
+	events     Subscriber
+	trustProxy bool
+	cache      *queryCache
+	// Share of a pool's TVL above which the calculator warns about a deposit
+	maxTVLShare float64
 }
 
 // New creates a new handler
-func New(db *database.DB) (*Handler, error) {
+func New(db database.Store) (*Handler, error) {
 	// Create template functions
 	funcMap := template.FuncMap{
 		"divf": func(a, b float64) float64 {
@@ -31,6 +40,67 @@ func New(db *database.DB) (*Handler, error) {
 			}
 			return a / b
 		},
+		"join": strings.Join,
+		"deref": func(f *float64) float64 {
+			if f == nil {
+				return 0
+			}
+			return *f
+		},
+		// deltaClass colours a change: up, down or flat
+		"deltaClass": func(delta float64) string {
+			switch {
+			case delta >= 0.005:
+				return "delta-up"
+			case delta <= -0.005:
+				return "delta-down"
+			default:
+				return "delta-flat"
+			}
+		},
+		// pctChange turns the change of a value into a percentage of its old value
+		"pctChange": func(delta, current float64) float64 {
+			if current-delta == 0 {
+				return 0
+			}
+			return delta / (current - delta) * 100
+		},
+		// percent turns a fraction into a percentage
+		"percent": func(f float64) float64 {
+			return f * 100
+		},
+		// apyAtSize estimates the APY of a Pendle rate after the price impact of
+		// a trade of size USD
+		"apyAtSize": func(rate models.YieldRate, size float64) float64 {
+			apy, _ := rate.APYAtSize(tradeSize(size))
+			return apy
+		},
+		// usd formats a trade size compactly, e.g. $100K
+		"usd": func(size float64) string {
+			size = tradeSize(size)
+			switch {
+			case size >= 1e6:
+				return "$" + strconv.FormatFloat(size/1e6, 'f', -1, 64) + "M"
+			case size >= 1e3:
+				return "$" + strconv.FormatFloat(size/1e3, 'f', -1, 64) + "K"
+			default:
+				return "$" + strconv.FormatFloat(size, 'f', -1, 64)
+			}
+		},
+		// daysLeft describes the time to maturity of a rate, e.g. "45 days left"
+		"daysLeft": func(rate models.YieldRate) string {
+			days, ok := rate.DaysToMaturity(time.Now())
+			switch {
+			case !ok:
+				return ""
+			case days <= 0:
+				return "matured"
+			case days == 1:
+				return "1 day left"
+			default:
+				return strconv.Itoa(days) + " days left"
+			}
+		},
 	}
 
 	// Parse templates with functions
@@ -40,39 +110,81 @@ func New(db *database.DB) (*Handler, error) {
 	}
 
 	return &Handler{
-		db:        db,
-		templates: tmpl,
+		db:          db,
+		templates:   tmpl,
+		cache:       newQueryCache(db),
+		maxTVLShare: DefaultMaxTVLShare,
 	}, nil
 }
 
 // parseFilterParams extracts filter parameters from the request
 func (h *Handler) parseFilterParams(r *http.Request) models.FilterParams {
+	return filterParamsFromValues(r.URL.Query())
+}
+
+// filterParamsFromValues reads filter parameters from query or form values
+func filterParamsFromValues(values url.Values) models.FilterParams {
 	filters := models.FilterParams{
-		SortBy:    r.URL.Query().Get("sort_by"),
-		SortOrder: r.URL.Query().Get("sort_order"),
-		Asset:     r.URL.Query().Get("asset"),
-		Chain:     r.URL.Query().Get("chain"),
-		ProtocolName: r.URL.Query().Get("protocol"),
+		SortBy:       values.Get("sort_by"),
+		SortOrder:    values.Get("sort_order"),
+		Asset:        values.Get("asset"),
+		Chain:        values.Get("chain"),
+		ProtocolName: values.Get("protocol"),
+		Category:     values.Get("category"),
 	}
 
-	if minAPY := r.URL.Query().Get("min_apy"); minAPY != "" {
+	if minAPY := values.Get("min_apy"); minAPY != "" {
 		if val, err := strconv.ParseFloat(minAPY, 64); err == nil {
 			filters.MinAPY = val
 		}
 	}
 
-	if maxAPY := r.URL.Query().Get("max_apy"); maxAPY != "" {
+	if maxAPY := values.Get("max_apy"); maxAPY != "" {
 		if val, err := strconv.ParseFloat(maxAPY, 64); err == nil {
 			filters.MaxAPY = val
 		}
 	}
 
-	if minTVL := r.URL.Query().Get("min_tvl"); minTVL != "" {
+	if minTVL := values.Get("min_tvl"); minTVL != "" {
 		if val, err := strconv.ParseFloat(minTVL, 64); err == nil {
 			filters.MinTVL = val
 		}
 	}
 
+	if minDays := values.Get("min_days_to_maturity"); minDays != "" {
+		if val, err := strconv.Atoi(minDays); err == nil {
+			filters.MinDaysToMaturity = val
+		}
+	}
+
+	if maxDays := values.Get("max_days_to_maturity"); maxDays != "" {
+		if val, err := strconv.Atoi(maxDays); err == nil {
+			filters.MaxDaysToMaturity = val
+		}
+	}
+
+	if after := values.Get("maturity_after"); after != "" {
+		if val, err := time.Parse(time.DateOnly, after); err == nil {
+			filters.MaturityAfter = val
+		}
+	}
+
+	if before := values.Get("maturity_before"); before != "" {
+		if val, err := time.Parse(time.DateOnly, before); err == nil {
+			filters.MaturityBefore = val
+		}
+	}
+
+	if term := values.Get("term"); term == models.TermFixed || term == models.TermVariable {
+		filters.Term = term
+	}
+
+	if size := values.Get("trade_size"); size != "" {
+		if val, err := strconv.ParseFloat(size, 64); err == nil && val > 0 {
+			filters.TradeSize = val
+		}
+	}
+
 	// Set defaults
 	if filters.SortBy == "" {
 		filters.SortBy = "apy"
@@ -80,60 +192,149 @@ func (h *Handler) parseFilterParams(r *http.Request) models.FilterParams {
 	if filters.SortOrder == "" {
 		filters.SortOrder = "desc"
 	}
+	if filters.TradeSize == 0 {
+		filters.TradeSize = models.DefaultTradeSize
+	}
 
 	return filters
 }
 
+// filterValues is the inverse of filterParamsFromValues, leaving out empty
+// filters and default sorting
+func filterValues(filters models.FilterParams) url.Values {
+	values := url.Values{}
+	set := func(key, value, empty string) {
+		if value != empty {
+			values.Set(key, value)
+		}
+	}
+	set("asset", filters.Asset, "")
+	set("chain", filters.Chain, "")
+	set("protocol", filters.ProtocolName, "")
+	set("category", filters.Category, "")
+	if filters.MinAPY != 0 {
+		values.Set("min_apy", strconv.FormatFloat(filters.MinAPY, 'f', -1, 64))
+	}
+	if filters.MaxAPY != 0 {
+		values.Set("max_apy", strconv.FormatFloat(filters.MaxAPY, 'f', -1, 64))
+	}
+	if filters.MinTVL != 0 {
+		values.Set("min_tvl", strconv.FormatFloat(filters.MinTVL, 'f', -1, 64))
+	}
+	if filters.MinDaysToMaturity != 0 {
+		values.Set("min_days_to_maturity", strconv.Itoa(filters.MinDaysToMaturity))
+	}
+	if filters.MaxDaysToMaturity != 0 {
+		values.Set("max_days_to_maturity", strconv.Itoa(filters.MaxDaysToMaturity))
+	}
+	if !filters.MaturityAfter.IsZero() {
+		values.Set("maturity_after", filters.MaturityAfter.Format(time.DateOnly))
+	}
+	if !filters.MaturityBefore.IsZero() {
+		values.Set("maturity_before", filters.MaturityBefore.Format(time.DateOnly))
+	}
+	set("term", filters.Term, "")
+	if filters.TradeSize != 0 && filters.TradeSize != models.DefaultTradeSize {
+		values.Set("trade_size", strconv.FormatFloat(filters.TradeSize, 'f', -1, 64))
+	}
+	set("sort_by", filters.SortBy, "apy")
+	set("sort_order", filters.SortOrder, "desc")
+	return values
+}
+
 // HandleIndex serves the main page
 func (h *Handler) HandleIndex(w http.ResponseWriter, r *http.Request) {
 	filters := h.parseFilterParams(r)
+	lastModified := h.cache.lastModified()
 
-	rates, err := h.db.GetYieldRates(filters)
+	rates, err := h.cache.yieldRates(filters)
 	if err != nil {
-		log.Printf("Error fetching yield rates: %v", err)
+		requestLogger(r).Error("failed to fetch yield rates", "error", err)
 		http.Error(w, "Failed to fetch yield rates", http.StatusInternalServerError)
 		return
 	}
 
-	assets, err := h.db.GetDistinctAssets()
+	assets, chains, categories, err := h.cache.filterOptions()
 	if err != nil {
-		log.Printf("Error fetching assets: %v", err)
-		assets = []string{}
+		requestLogger(r).Error("failed to fetch filter options", "error", err)
+		assets, chains, categories = []string{}, []string{}, []string{}
 	}
 
-	chains, err := h.db.GetDistinctChains()
-	if err != nil {
-		log.Printf("Error fetching chains: %v", err)
-		chains = []string{}
+	var user *models.User
+	p := h.principal(r)
+	if p != nil {
+		user = p.user
+	}
+
+	var savedFilters []models.SavedFilter
+	watched := map[int64]bool{}
+	profile := h.currentProfile(r)
+	if profile != nil {
+		if savedFilters, err = h.db.GetSavedFilters(profile.ID); err != nil {
+			requestLogger(r).Error("failed to fetch saved filters", "error", err)
+		}
+		ids, err := h.db.GetWatchlist(profile.ID)
+		if err != nil {
+			requestLogger(r).Error("failed to fetch watchlist", "error", err)
+		}
+		for _, id := range ids {
+			watched[id] = true
+		}
+		rates = pinWatched(rates, watched)
 	}
 
 	data := struct {
-		YieldRates []models.YieldRate
-		Assets     []string
-		Chains     []string
-		Filters    models.FilterParams
+		YieldRates   []models.YieldRate
+		Assets       []string
+		Chains       []string
+		Categories   []string
+		Filters      models.FilterParams
+		SavedFilters []models.SavedFilter
+		Watched      map[int64]bool
+		User         *models.User
+		CanRefresh   bool
+		LiveUpdate   bool
 	}{
-		YieldRates: rates,
-		Assets:     assets,
-		Chains:     chains,
-		Filters:    filters,
+		YieldRates:   rates,
+		Assets:       assets,
+		Chains:       chains,
+		Categories:   categories,
+		Filters:      filters,
+		SavedFilters: savedFilters,
+		Watched:      watched,
+		User:         user,
+		CanRefresh:   h.refresher != nil && p != nil && models.HasScope(p.scopes, models.ScopeAdmin),
+		LiveUpdate:   h.events != nil,
 	}
 
-	// Check if this is an HTMX request
+	// HTMX requests get only the table partial, others the full page
+	name := "index.html"
 	if r.Header.Get("HX-Request") == "true" {
-		// Return only the table partial
-		if err := h.templates.ExecuteTemplate(w, "table.html", data); err != nil {
-			log.Printf("Error executing template: %v", err)
-			http.Error(w, "Failed to render template", http.StatusInternalServerError)
-		}
-		return
+		name = "table.html"
 	}
 
-	// Return full page
-	if err := h.templates.ExecuteTemplate(w, "index.html", data); err != nil {
-		log.Printf("Error executing template: %v", err)
+	var body bytes.Buffer
+	if err := h.templates.ExecuteTemplate(&body, name, data); err != nil {
+		requestLogger(r).Error("failed to execute template", "error", err)
 		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+		return
+	}
+	// Pages of users and profiles change with their watchlist and saved
+	// filters, which lastModified doesn't follow, so only their ETag counts
+	if p != nil || profile != nil {
+		lastModified = time.Time{}
+	}
+	w.Header().Add("Vary", "HX-Request")
+	w.Header().Add("Vary", "Cookie")
+	serveCacheable(w, r, "text/html; charset=utf-8", lastModified, body.Bytes())
+}
+
+// tradeSize returns size, or the default trade size if it is not set
+func tradeSize(size float64) float64 {
+	if size <= 0 {
+		return models.DefaultTradeSize
 	}
+	return size
 }
 
 // HandleStatic serves static files
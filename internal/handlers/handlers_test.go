@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -351,6 +352,77 @@ func TestHandleIndex_APYColorCoding(t *testing.T) {
 	}
 }
 
+// TestHandleIndex_RewardBreakdown tests that base and reward APY are shown separately
+func TestHandleIndex_RewardBreakdown(t *testing.T) {
+	handler, db, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	protocol := &models.Protocol{Name: "Curve"}
+	db.CreateOrUpdateProtocol(protocol)
+
+	rate := &models.YieldRate{
+		ProtocolID: protocol.ID,
+		Asset:      "DAI/USDC/USDT",
+		Chain:      "Ethereum",
+		APY:        2.75,
+		BaseAPY:    0.25,
+		RewardAPY:  2.5,
+		Rewards:    []models.RewardAPY{{Token: "CRV", APY: 2.5}},
+		TVL:        1000000,
+		PoolName:   "3pool",
+	}
+	db.UpsertYieldRate(rate)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+
+	handler.HandleIndex(w, req)
+
+	body := w.Body.String()
+	for _, want := range []string{"0.25% base", "+ 2.50% rewards", "CRV 2.50%"} {
+		if !contains(body, want) {
+			t.Errorf("Response should contain reward breakdown %q", want)
+		}
+	}
+}
+
+// TestTableTemplate_RateChanges tests the APY and TVL change indicators
+func TestTableTemplate_RateChanges(t *testing.T) {
+	handler, _, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	apyUp, apyDown, tvlUp := 1.25, -0.5, 500000.0
+	data := map[string]interface{}{
+		"YieldRates": []models.YieldRate{{
+			ID:           7,
+			ProtocolName: "Pendle",
+			Asset:        "ETH",
+			Chain:        "Ethereum",
+			APY:          10,
+			TVL:          1500000,
+			PoolName:     "PT-ETH",
+			APYChange24h: &apyUp,
+			APYChange7d:  &apyDown,
+			TVLChange24h: &tvlUp,
+		}},
+	}
+
+	var buf strings.Builder
+	if err := handler.templates.ExecuteTemplate(&buf, "table.html", data); err != nil {
+		t.Fatalf("Failed to render table: %v", err)
+	}
+
+	body := buf.String()
+	for _, want := range []string{`id="rate-7"`, "delta-up", "1.25 24h", "delta-down", "-0.50 7d", "50.0% 24h"} {
+		if !contains(body, want) {
+			t.Errorf("table should contain %q", want)
+		}
+	}
+	if contains(body, "% 7d") {
+		t.Error("table should not show a 7d TVL change without history")
+	}
+}
+
 // Helper function to check if string contains substring
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || len(s) > len(substr) && containsRec(s, substr))
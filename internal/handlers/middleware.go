@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/logging"
+)
+
+// requestIDHeader carries the request ID in and out of the server
+const requestIDHeader = "X-Request-ID"
+
+// statusRecorder captures the status code and size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// Flush lets streaming handlers flush through the recorder
+func (rec *statusRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// LogRequests tags every request with a request ID, stores a logger carrying
+// it in the request context and logs the route, status and latency once the
+// request is served
+func LogRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		requestID := r.Header.Get(requestIDHeader)
+		if requestID == "" || len(requestID) > 64 {
+			requestID = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+
+		logger := slog.With("request_id", requestID, "method", r.Method, "path", r.URL.Path)
+		r = r.WithContext(logging.WithLogger(r.Context(), logger))
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		// The mux fills in r.Pattern while routing
+		logger.Info("request",
+			"route", r.Pattern,
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"latency", time.Since(start),
+		)
+	})
+}
+
+// requestLogger returns the logger of the request, tagged with its route
+func requestLogger(r *http.Request) *slog.Logger {
+	return logging.FromContext(r.Context()).With("route", r.Pattern)
+}
+
+// newRequestID returns a random 16 character hex ID
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestLogRequests tests that request records carry the request ID, route,
+// status and latency, and that handler logs share the request ID
+func TestLogRequests(t *testing.T) {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	defer slog.SetDefault(previous)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /rates/{id}", func(w http.ResponseWriter, r *http.Request) {
+		requestLogger(r).Info("looking up rate")
+		w.WriteHeader(http.StatusNotFound)
+	})
+	server := LogRequests(mux)
+
+	req := httptest.NewRequest("GET", "/rates/42", nil)
+	req.Header.Set(requestIDHeader, "abc123")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	if got := w.Header().Get(requestIDHeader); got != "abc123" {
+		t.Errorf("%s = %q, want the incoming ID", requestIDHeader, got)
+	}
+
+	var records []map[string]interface{}
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var record map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("invalid log record %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d log records, want 2", len(records))
+	}
+
+	handlerRecord, requestRecord := records[0], records[1]
+	if handlerRecord["request_id"] != "abc123" || handlerRecord["route"] != "GET /rates/{id}" {
+		t.Errorf("handler record = %v, want the request ID and route", handlerRecord)
+	}
+
+	tests := []struct {
+		key  string
+		want interface{}
+	}{
+		{"msg", "request"},
+		{"request_id", "abc123"},
+		{"method", "GET"},
+		{"path", "/rates/42"},
+		{"route", "GET /rates/{id}"},
+		{"status", float64(http.StatusNotFound)},
+	}
+	for _, tt := range tests {
+		if requestRecord[tt.key] != tt.want {
+			t.Errorf("request record %s = %v, want %v", tt.key, requestRecord[tt.key], tt.want)
+		}
+	}
+	if _, ok := requestRecord["latency"]; !ok {
+		t.Error("request record should include the latency")
+	}
+}
+
+// TestLogRequests_GeneratesID tests that requests without an ID get one
+func TestLogRequests_GeneratesID(t *testing.T) {
+	server := LogRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	if got := w.Header().Get(requestIDHeader); len(got) != 16 {
+		t.Errorf("%s = %q, want a generated 16 character ID", requestIDHeader, got)
+	}
+}
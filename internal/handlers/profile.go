@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// profileCookie holds the token of the browser's profile
+const profileCookie = "defirates_profile"
+
+// profileCookieMaxAge keeps saved filters and the watchlist for a year of inactivity
+const profileCookieMaxAge = 365 * 24 * 60 * 60
+
+// cookieProfile returns the profile of the request's cookie, or nil if the
+// browser has none yet
+func (h *Handler) cookieProfile(r *http.Request) *models.Profile {
+	cookie, err := r.Cookie(profileCookie)
+	if err != nil || cookie.Value == "" {
+		return nil
+	}
+
+	profile, err := h.db.GetProfileByToken(cookie.Value)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			requestLogger(r).Error("failed to fetch profile", "error", err)
+		}
+		return nil
+	}
+	return profile
+}
+
+// currentProfile returns the profile the request acts for: the user's
+// profile for logged-in users and API keys, otherwise the anonymous profile
+// of the browser's cookie. It returns nil if there is none yet, and never
+// writes, as every page view asks for it
+func (h *Handler) currentProfile(r *http.Request) *models.Profile {
+	if p := h.principal(r); p != nil && p.user != nil {
+		userProfile, err := h.db.GetUserProfile(p.user.ID)
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				requestLogger(r).Error("failed to fetch user profile", "error", err)
+			}
+			return nil
+		}
+		return userProfile
+	}
+
+	profile := h.cookieProfile(r)
+
+	// The profile of a user who logged out stays with the account
+	if profile != nil && profile.UserID != nil {
+		return nil
+	}
+	return profile
+}
+
+// ensureProfile returns the request's profile, creating one on first use:
+// the user's, adopting the browser's anonymous profile, or an anonymous one
+// with its cookie. Profiles are only created when something is saved
+func (h *Handler) ensureProfile(w http.ResponseWriter, r *http.Request) (*models.Profile, error) {
+	if profile := h.currentProfile(r); profile != nil {
+		return profile, nil
+	}
+	if p := h.principal(r); p != nil && p.user != nil {
+		return h.db.ProfileForUser(p.user.ID, h.cookieProfile(r))
+	}
+
+	profile, err := h.db.CreateProfile()
+	if err != nil {
+		return nil, err
+	}
+	h.setProfileCookie(w, r, profile)
+	requestLogger(r).Info("created profile", "profile_id", profile.ID)
+	return profile, nil
+}
+
+// setProfileCookie points the browser at a profile
+func (h *Handler) setProfileCookie(w http.ResponseWriter, r *http.Request, profile *models.Profile) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     profileCookie,
+		Value:    profile.Token,
+		Path:     "/",
+		MaxAge:   profileCookieMaxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// pinWatched moves watched rates to the top, keeping the order within both groups
+func pinWatched(rates []models.YieldRate, watched map[int64]bool) []models.YieldRate {
+	if len(watched) == 0 {
+		return rates
+	}
+	sort.SliceStable(rates, func(i, j int) bool {
+		return watched[rates[i].ID] && !watched[rates[j].ID]
+	})
+	return rates
+}
+
+// HandleListFilters lists the saved filters of the request's profile as JSON
+func (h *Handler) HandleListFilters(w http.ResponseWriter, r *http.Request) {
+	filters := []models.SavedFilter{}
+	if profile := h.currentProfile(r); profile != nil {
+		saved, err := h.db.GetSavedFilters(profile.ID)
+		if err != nil {
+			requestLogger(r).Error("failed to fetch saved filters", "error", err)
+			writeJSONError(w, http.StatusInternalServerError, "failed to fetch saved filters")
+			return
+		}
+		filters = append(filters, saved...)
+	}
+
+	writeJSON(w, http.StatusOK, filters)
+}
+
+// HandleSaveFilter saves the filter form values posted with it under the name
+// form value. HTMX requests get the updated list of saved filters
+func (h *Handler) HandleSaveFilter(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid form")
+		return
+	}
+
+	name := strings.TrimSpace(r.PostForm.Get("name"))
+	if name == "" {
+		writeJSONError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+
+	profile, err := h.ensureProfile(w, r)
+	if err != nil {
+		requestLogger(r).Error("failed to create profile", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to save filter")
+		return
+	}
+
+	filter := models.SavedFilter{
+		ProfileID: profile.ID,
+		Name:      name,
+		Query:     filterValues(filterParamsFromValues(r.PostForm)).Encode(),
+	}
+	if err := h.db.SaveFilter(&filter); err != nil {
+		requestLogger(r).Error("failed to save filter", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to save filter")
+		return
+	}
+
+	if r.Header.Get("HX-Request") == "true" {
+		h.renderSavedFilters(w, r, profile.ID)
+		return
+	}
+	writeJSON(w, http.StatusCreated, filter)
+}
+
+// HandleDeleteFilter deletes the saved filter at /filters/{id} if it belongs
+// to the request's profile
+func (h *Handler) HandleDeleteFilter(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid filter id")
+		return
+	}
+
+	profile := h.currentProfile(r)
+	if profile == nil {
+		writeJSONError(w, http.StatusNotFound, "filter not found")
+		return
+	}
+
+	if err := h.db.DeleteSavedFilter(profile.ID, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, "filter not found")
+			return
+		}
+		requestLogger(r).Error("failed to delete filter", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to delete filter")
+		return
+	}
+
+	if r.Header.Get("HX-Request") == "true" {
+		h.renderSavedFilters(w, r, profile.ID)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// renderSavedFilters writes the saved filters bar of a profile
+func (h *Handler) renderSavedFilters(w http.ResponseWriter, r *http.Request, profileID int64) {
+	filters, err := h.db.GetSavedFilters(profileID)
+	if err != nil {
+		requestLogger(r).Error("failed to fetch saved filters", "error", err)
+	}
+
+	data := struct{ SavedFilters []models.SavedFilter }{filters}
+	if err := h.templates.ExecuteTemplate(w, "saved_filters.html", data); err != nil {
+		requestLogger(r).Error("failed to execute template", "error", err)
+		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+	}
+}
+
+// HandleSharedFilter redirects the short link /f/{slug} to the index page
+// with the saved filter applied
+func (h *Handler) HandleSharedFilter(w http.ResponseWriter, r *http.Request) {
+	filter, err := h.db.GetSavedFilterBySlug(r.PathValue("slug"))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.NotFound(w, r)
+			return
+		}
+		requestLogger(r).Error("failed to fetch saved filter", "error", err)
+		http.Error(w, "Failed to fetch saved filter", http.StatusInternalServerError)
+		return
+	}
+
+	target := "/"
+	if filter.Query != "" {
+		target += "?" + filter.Query
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// HandleWatch adds the rate at /watchlist/{id} to the request's watchlist
+func (h *Handler) HandleWatch(w http.ResponseWriter, r *http.Request) {
+	h.updateWatchlist(w, r, true)
+}
+
+// HandleUnwatch removes the rate at /watchlist/{id} from the request's watchlist
+func (h *Handler) HandleUnwatch(w http.ResponseWriter, r *http.Request) {
+	h.updateWatchlist(w, r, false)
+}
+
+// updateWatchlist implements HandleWatch and HandleUnwatch. HTMX requests
+// get an HX-Trigger so the rates table reloads with the new pins
+func (h *Handler) updateWatchlist(w http.ResponseWriter, r *http.Request, watch bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid rate id")
+		return
+	}
+
+	if _, err := h.db.GetYieldRate(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, "rate not found")
+			return
+		}
+		requestLogger(r).Error("failed to fetch yield rate", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to update watchlist")
+		return
+	}
+
+	if watch {
+		var profile *models.Profile
+		if profile, err = h.ensureProfile(w, r); err == nil {
+			err = h.db.AddToWatchlist(profile.ID, id)
+		}
+	} else if profile := h.currentProfile(r); profile != nil {
+		err = h.db.RemoveFromWatchlist(profile.ID, id)
+	}
+	if err != nil {
+		requestLogger(r).Error("failed to update watchlist", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to update watchlist")
+		return
+	}
+
+	if r.Header.Get("HX-Request") == "true" {
+		w.Header().Set("HX-Trigger", "watchlist-changed")
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
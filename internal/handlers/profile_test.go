@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+)
+
+// newProfileMux routes the saved filter and watchlist endpoints like cmd/server does
+func newProfileMux(handler *Handler) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/{$}", handler.HandleIndex)
+	mux.HandleFunc("GET /filters", handler.HandleListFilters)
+	mux.HandleFunc("POST /filters", handler.HandleSaveFilter)
+	mux.HandleFunc("DELETE /filters/{id}", handler.HandleDeleteFilter)
+	mux.HandleFunc("GET /f/{slug}", handler.HandleSharedFilter)
+	mux.HandleFunc("POST /watchlist/{id}", handler.HandleWatch)
+	mux.HandleFunc("DELETE /watchlist/{id}", handler.HandleUnwatch)
+	return mux
+}
+
+// profileRequest sends a request with the given profile cookie, if any
+func profileRequest(mux http.Handler, method, target string, form url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+	var body *strings.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	} else {
+		body = strings.NewReader("")
+	}
+
+	req := httptest.NewRequest(method, target, body)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	return w
+}
+
+// responseCookie returns the named cookie set by a response
+func responseCookie(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+// TestSavedFilters tests saving a filter, sharing its short link and deleting it
+func TestSavedFilters(t *testing.T) {
+	handler, db, cleanup := setupTestHandler(t)
+	defer cleanup()
+	mux := newProfileMux(handler)
+
+	if w := profileRequest(mux, "POST", "/filters", url.Values{"asset": {"USDC"}}, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("saving without a name: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	form := url.Values{
+		"name":       {"Stable yields"},
+		"asset":      {"USDC"},
+		"min_apy":    {"5"},
+		"sort_by":    {"apy"},
+		"sort_order": {"desc"},
+	}
+	w := profileRequest(mux, "POST", "/filters", form, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /filters status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	cookie := responseCookie(w, profileCookie)
+	if cookie == nil || !cookie.HttpOnly {
+		t.Fatal("POST /filters should set an HttpOnly profile cookie")
+	}
+
+	profile, err := db.GetProfileByToken(cookie.Value)
+	if err != nil {
+		t.Fatalf("GetProfileByToken() failed: %v", err)
+	}
+	filters, _ := db.GetSavedFilters(profile.ID)
+	if len(filters) != 1 || filters[0].Query != "asset=USDC&min_apy=5" {
+		t.Fatalf("saved filters = %+v, want one with the non-default values", filters)
+	}
+	filter := filters[0]
+
+	// The short link works without the cookie
+	w = profileRequest(mux, "GET", filter.URL(), nil, nil)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/?asset=USDC&min_apy=5" {
+		t.Errorf("GET %s = %d %q, want a redirect to the filtered index", filter.URL(), w.Code, w.Header().Get("Location"))
+	}
+	if w := profileRequest(mux, "GET", "/f/unknown", nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("GET /f/unknown status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	// The index page lists the profile's filters
+	w = profileRequest(mux, "GET", "/", nil, cookie)
+	if !contains(w.Body.String(), "Stable yields") || !contains(w.Body.String(), filter.URL()) {
+		t.Error("index page should list the saved filter with its short link")
+	}
+	if w := profileRequest(mux, "GET", "/", nil, nil); contains(w.Body.String(), "Stable yields") {
+		t.Error("index page should not show another profile's saved filters")
+	}
+
+	if w := profileRequest(mux, "GET", "/filters", nil, cookie); !contains(w.Body.String(), filter.Slug) {
+		t.Errorf("GET /filters = %s, want the saved filter", w.Body.String())
+	}
+
+	// Only the owner can delete the filter
+	target := "/filters/" + strconv.FormatInt(filter.ID, 10)
+	if w := profileRequest(mux, "DELETE", target, nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("DELETE without the profile cookie status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := profileRequest(mux, "DELETE", target, nil, cookie); w.Code != http.StatusNoContent {
+		t.Errorf("DELETE status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if w := profileRequest(mux, "GET", filter.URL(), nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("GET %s after delete status = %d, want %d", filter.URL(), w.Code, http.StatusNotFound)
+	}
+}
+
+// TestSaveFilter_HTMX tests that HTMX requests get the saved filters bar
+func TestSaveFilter_HTMX(t *testing.T) {
+	handler, _, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	req := httptest.NewRequest("POST", "/filters", strings.NewReader("name=Arbitrum&chain=Arbitrum"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("HX-Request", "true")
+	w := httptest.NewRecorder()
+
+	newProfileMux(handler).ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	body := w.Body.String()
+	if !contains(body, "saved-filters-list") || !contains(body, "Arbitrum") || contains(body, "<html") {
+		t.Errorf("body = %s, want only the saved filters bar", body)
+	}
+}
+
+// TestWatchlist tests pinning watched rates to the top of the table
+func TestWatchlist(t *testing.T) {
+	handler, db, cleanup := setupTestHandler(t)
+	defer cleanup()
+	mux := newProfileMux(handler)
+
+	protocol := &models.Protocol{Name: "TestProtocol"}
+	db.CreateOrUpdateProtocol(protocol)
+
+	var ids []int64
+	for i, pool := range []string{"High-Pool", "Low-Pool"} {
+		rate := &models.YieldRate{
+			ProtocolID: protocol.ID,
+			Asset:      "USDC",
+			Chain:      "Ethereum",
+			APY:        20 - float64(i)*10,
+			TVL:        1000000,
+			PoolName:   pool,
+		}
+		db.UpsertYieldRate(rate)
+		ids = append(ids, rate.ID)
+	}
+
+	if w := profileRequest(mux, "POST", "/watchlist/999", nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("watching an unknown rate: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	w := profileRequest(mux, "POST", "/watchlist/"+strconv.FormatInt(ids[1], 10), nil, nil)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("POST /watchlist status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	cookie := responseCookie(w, profileCookie)
+	if cookie == nil {
+		t.Fatal("POST /watchlist should set the profile cookie")
+	}
+
+	// The watched low APY pool comes first despite sorting by APY
+	body := profileRequest(mux, "GET", "/?sort_by=apy&sort_order=desc", nil, cookie).Body.String()
+	if strings.Index(body, "Low-Pool") > strings.Index(body, "High-Pool") {
+		t.Error("watched rate should be pinned above higher APY rates")
+	}
+	if !contains(body, `class="watched"`) {
+		t.Error("watched rate should be highlighted")
+	}
+
+	// Without the cookie the table keeps its normal order
+	body = profileRequest(mux, "GET", "/", nil, nil).Body.String()
+	if strings.Index(body, "Low-Pool") < strings.Index(body, "High-Pool") {
+		t.Error("rates should keep their order for a browser without a watchlist")
+	}
+
+	req := httptest.NewRequest("DELETE", "/watchlist/"+strconv.FormatInt(ids[1], 10), nil)
+	req.AddCookie(cookie)
+	req.Header.Set("HX-Request", "true")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent || w.Header().Get("HX-Trigger") != "watchlist-changed" {
+		t.Errorf("DELETE /watchlist = %d with HX-Trigger %q, want 204 and watchlist-changed", w.Code, w.Header().Get("HX-Trigger"))
+	}
+
+	body = profileRequest(mux, "GET", "/", nil, cookie).Body.String()
+	if strings.Index(body, "Low-Pool") < strings.Index(body, "High-Pool") {
+		t.Error("unwatched rate should no longer be pinned")
+	}
+}
+
+// TestUserProfile_CreatedOnSave tests that page views of a user without a
+// profile don't create one, and that saving something does
+func TestUserProfile_CreatedOnSave(t *testing.T) {
+	handler, db, cleanup := setupTestHandler(t)
+	defer cleanup()
+	mux := newProfileMux(handler)
+
+	user := createUser(t, db, "reader", models.ScopeRead)
+	token, err := db.CreateSession(user.ID, sessionTTL)
+	if err != nil {
+		t.Fatalf("CreateSession() failed: %v", err)
+	}
+	session := &http.Cookie{Name: sessionCookie, Value: token}
+
+	for _, target := range []string{"/", "/filters"} {
+		if w := profileRequest(mux, "GET", target, nil, session); w.Code != http.StatusOK {
+			t.Fatalf("GET %s status = %d, want %d", target, w.Code, http.StatusOK)
+		}
+	}
+	if profile, err := db.GetUserProfile(user.ID); err == nil {
+		t.Fatalf("page views created profile %+v", profile)
+	}
+
+	form := url.Values{"name": {"Stables"}, "asset": {"USDC"}}
+	if w := profileRequest(mux, "POST", "/filters", form, session); w.Code != http.StatusCreated {
+		t.Fatalf("POST /filters status = %d: %s", w.Code, w.Body.String())
+	}
+	profile, err := db.GetUserProfile(user.ID)
+	if err != nil {
+		t.Fatalf("GetUserProfile() after saving a filter failed: %v", err)
+	}
+	if filters, _ := db.GetSavedFilters(profile.ID); len(filters) != 1 {
+		t.Errorf("saved filters = %+v, want Stables", filters)
+	}
+}
+
+// TestFilterValues tests that saved queries round-trip through the filter parser
+func TestFilterValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"defaults dropped", "sort_by=apy&sort_order=desc", ""},
+		{"empty values dropped", "asset=&chain=&min_apy=", ""},
+		{"filters kept", "chain=Arbitrum&min_tvl=100000&max_apy=50.5", "chain=Arbitrum&max_apy=50.5&min_tvl=100000"},
+		{"custom sort kept", "sort_by=tvl&sort_order=asc", "sort_by=tvl&sort_order=asc"},
+		{"unknown values dropped", "asset=ETH&name=Mine", "asset=ETH"},
+		{"categories kept", "category=stables", "category=stables"},
+		{"maturity kept", "term=fixed&min_days_to_maturity=30&maturity_before=2027-01-31", "maturity_before=2027-01-31&min_days_to_maturity=30&term=fixed"},
+		{"invalid maturity dropped", "term=soon&max_days_to_maturity=x&maturity_after=tomorrow", ""},
+		{"trade size kept", "sort_by=apy_at_size&trade_size=250000", "sort_by=apy_at_size&trade_size=250000"},
+		{"default trade size dropped", "trade_size=100000", ""},
+		{"invalid trade size dropped", "trade_size=-5", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, _ := url.ParseQuery(tt.query)
+			if got := filterValues(filterParamsFromValues(values)).Encode(); got != tt.want {
+				t.Errorf("filterValues(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
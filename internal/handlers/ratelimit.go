@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/ratelimit"
+)
+
+// SetTrustProxy makes rate limiting key anonymous clients by the last
+// X-Forwarded-For address, for servers behind a single reverse proxy
+func (h *Handler) SetTrustProxy(trust bool) {
+	h.trustProxy = trust
+}
+
+// clientIP returns the address requests from this client come from
+func (h *Handler) clientIP(r *http.Request) string {
+	if h.trustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			hops := strings.Split(forwarded, ",")
+			if ip := strings.TrimSpace(hops[len(hops)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// RateLimit wraps a handler so each client, identified by its API key or
+// else its IP address, stays within the limiter's rate. Every response gets
+// X-RateLimit-* headers; refused requests get a 429 with Retry-After
+func (h *Handler) RateLimit(limiter *ratelimit.Limiter, next http.HandlerFunc) http.HandlerFunc {
+	if limiter.Limit().Unlimited() {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Authenticating here also saves RequireScope from doing it again
+		p := h.principal(r)
+		r = r.WithContext(context.WithValue(r.Context(), principalKey{}, p))
+
+		key := "ip:" + h.clientIP(r)
+		if p != nil && p.apiKey != nil {
+			key = "key:" + strconv.FormatInt(p.apiKey.ID, 10)
+		}
+
+		result := limiter.Allow(key)
+		header := w.Header()
+		header.Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+		header.Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		header.Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+		if !result.Allowed {
+			requestLogger(r).Warn("rate limited", "client", key, "retry_after", result.RetryAfter)
+			header.Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			writeJSONError(w, http.StatusTooManyRequests, "rate limit exceeded; retry in "+strconv.Itoa(ceilSeconds(result.RetryAfter))+"s")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// ceilSeconds rounds a wait up to whole seconds, as HTTP headers count them
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pretty-andrechal/defirates/internal/models"
+	"github.com/pretty-andrechal/defirates/internal/ratelimit"
+)
+
+// fakeClock is a manually advanced clock for rate limiters
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+// TestRateLimit tests that clients are limited separately by API key or IP
+func TestRateLimit(t *testing.T) {
+	handler, db, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	user := createUser(t, db, "partner", models.ScopeRead)
+	key, err := db.CreateAPIKey(&models.APIKey{UserID: user.ID, Name: "dashboard", Scopes: user.Scopes})
+	if err != nil {
+		t.Fatalf("CreateAPIKey() failed: %v", err)
+	}
+
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	limiter := ratelimit.New(ratelimit.Limit{Requests: 60, Per: time.Minute, Burst: 2}, clock.Now)
+	limited := handler.RateLimit(limiter, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	do := func(remoteAddr, forwardedFor, apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/rates", nil)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		if apiKey != "" {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		w := httptest.NewRecorder()
+		limited(w, req)
+		return w
+	}
+
+	w := do("192.0.2.1:1234", "", "")
+	if w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Limit") != "2" || w.Header().Get("X-RateLimit-Remaining") != "1" {
+		t.Errorf("first request = %d with headers %v, want 200 with limit 2 and 1 remaining", w.Code, w.Header())
+	}
+	do("192.0.2.1:5678", "", "")
+
+	w = do("192.0.2.1:1234", "", "")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("request over the burst = %d with Retry-After %q, want 429 with 1", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w.Header().Get("X-RateLimit-Remaining") != "0" || w.Header().Get("X-RateLimit-Reset") != "2" {
+		t.Errorf("refused headers = %v, want 0 remaining and a reset in 2s", w.Header())
+	}
+
+	// An API key has its own bucket, wherever it is used from
+	for i := 0; i < 2; i++ {
+		if w := do("192.0.2.1:1234", "", key); w.Code != http.StatusOK {
+			t.Fatalf("API key request %d status = %d, want %d", i+1, w.Code, http.StatusOK)
+		}
+	}
+	if w := do("198.51.100.7:1234", "", key); w.Code != http.StatusTooManyRequests {
+		t.Errorf("API key from another IP status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+
+	// X-Forwarded-For is ignored unless the proxy is trusted
+	if w := do("192.0.2.1:1234", "203.0.113.9", ""); w.Code != http.StatusTooManyRequests {
+		t.Errorf("spoofed X-Forwarded-For status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	handler.SetTrustProxy(true)
+	if w := do("192.0.2.1:1234", "10.0.0.1, 203.0.113.9", ""); w.Code != http.StatusOK {
+		t.Errorf("forwarded client behind a trusted proxy status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	clock.now = clock.now.Add(time.Second)
+	handler.SetTrustProxy(false)
+	if w := do("192.0.2.1:1234", "", ""); w.Code != http.StatusOK {
+		t.Errorf("request after the retry delay status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+// TestRateLimit_Unlimited tests that an unlimited limiter leaves handlers alone
+func TestRateLimit_Unlimited(t *testing.T) {
+	handler, _, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	limited := handler.RateLimit(ratelimit.New(ratelimit.Limit{}, nil), func(w http.ResponseWriter, r *http.Request) {})
+	w := httptest.NewRecorder()
+	limited(w, httptest.NewRequest("GET", "/", nil))
+	if w.Header().Get("X-RateLimit-Limit") != "" {
+		t.Error("unlimited routes should not send rate limit headers")
+	}
+}
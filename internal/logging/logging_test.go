@@ -0,0 +1,80 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// TestNew tests text and JSON output and level filtering
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "json", slog.LevelInfo)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	logger.Debug("hidden")
+	logger.Info("fetch finished", "source", "Pendle", "run_id", 7)
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("output is not a single JSON record: %v\n%s", err, buf.String())
+	}
+	if record["msg"] != "fetch finished" || record["source"] != "Pendle" || record["run_id"] != 7.0 {
+		t.Errorf("record = %v, want the info message with its attributes", record)
+	}
+
+	buf.Reset()
+	logger, _ = New(&buf, "text", slog.LevelDebug)
+	logger.Debug("shown", "chain", "Ethereum")
+	if !strings.Contains(buf.String(), "level=DEBUG") || !strings.Contains(buf.String(), "chain=Ethereum") {
+		t.Errorf("text output = %q, want a debug record", buf.String())
+	}
+
+	if _, err := New(&buf, "xml", slog.LevelInfo); err == nil {
+		t.Error("New() should reject unknown formats")
+	}
+}
+
+// TestParseLevel tests level name parsing
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    slog.Level
+		wantErr bool
+	}{
+		{"debug", slog.LevelDebug, false},
+		{"INFO", slog.LevelInfo, false},
+		{"warn", slog.LevelWarn, false},
+		{"error", slog.LevelError, false},
+		{"verbose", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLevel(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLevel(%s) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseLevel(%s) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestFromContext tests that the context logger falls back to the default
+func TestFromContext(t *testing.T) {
+	if FromContext(context.Background()) != slog.Default() {
+		t.Error("FromContext() without a logger should return the default logger")
+	}
+
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	if FromContext(WithLogger(context.Background(), logger)) != logger {
+		t.Error("FromContext() should return the stored logger")
+	}
+}
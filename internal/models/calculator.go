@@ -0,0 +1,71 @@
+package models
+
+import (
+	"math"
+	"time"
+)
+
+// Projection is the projected return of depositing an amount into a rate
+// over a horizon, assuming the APY holds and compounds. Deposits into Pendle
+// markets earn the APY after the price impact of buying in
+type Projection struct {
+	RateID       int64      `json:"rate_id"`
+	ProtocolName string     `json:"protocol_name"`
+	Asset        string     `json:"asset"`
+	Chain        string     `json:"chain"`
+	PoolName     string     `json:"pool_name"`
+	APY          float64    `json:"apy"`
+	EffectiveAPY float64    `json:"effective_apy"` // APY after price impact, see YieldRate.APYAtSize
+	TVL          float64    `json:"tvl"`
+	MaturityDate *time.Time `json:"maturity_date,omitempty"`
+	Amount       float64    `json:"amount"`
+	Days         int        `json:"days"`
+	// ToMaturity is set when the period ends at the rate's maturity, either
+	// as asked or because it matures before the horizon
+	ToMaturity     bool    `json:"to_maturity"`
+	Return         float64 `json:"return"`          // Absolute return over the period
+	EffectiveYield float64 `json:"effective_yield"` // Return as a percentage of the amount
+	TVLShare       float64 `json:"tvl_share"`       // Amount as a fraction of the TVL
+	ExceedsTVL     bool    `json:"exceeds_tvl"`     // TVLShare is above the warning share
+}
+
+// ProjectReturn projects depositing amount into rate for days, or until
+// maturity when days is 0, warning when the amount is more than maxTVLShare
+// of the pool's TVL. Rates of fixed term are projected to their maturity at
+// most. It returns false for rates without days left in the period: matured
+// ones, and variable ones projected to maturity
+func ProjectReturn(rate YieldRate, amount float64, days int, maxTVLShare float64, now time.Time) (Projection, bool) {
+	toMaturity := days == 0
+	if left, ok := rate.DaysToMaturity(now); ok && (toMaturity || left <= days) {
+		days, toMaturity = left, true
+	} else if toMaturity {
+		return Projection{}, false
+	}
+	if days <= 0 {
+		return Projection{}, false
+	}
+
+	apy, _ := rate.APYAtSize(amount)
+	growth := math.Pow(1+apy/100, float64(days)/365) - 1
+	p := Projection{
+		RateID:         rate.ID,
+		ProtocolName:   rate.ProtocolName,
+		Asset:          rate.Asset,
+		Chain:          rate.Chain,
+		PoolName:       rate.PoolName,
+		APY:            rate.APY,
+		EffectiveAPY:   apy,
+		TVL:            rate.TVL,
+		MaturityDate:   rate.MaturityDate,
+		Amount:         amount,
+		Days:           days,
+		ToMaturity:     toMaturity,
+		Return:         amount * growth,
+		EffectiveYield: growth * 100,
+	}
+	if rate.TVL > 0 {
+		p.TVLShare = amount / rate.TVL
+	}
+	p.ExceedsTVL = rate.TVL <= 0 || p.TVLShare > maxTVLShare
+	return p, true
+}
@@ -0,0 +1,121 @@
+package models
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// YieldCurve is the term structure of an asset: the fixed APYs of its
+// markets against their days to maturity, one series per chain. Assets
+// wrapping the same underlying token share a curve, named after all of them
+type YieldCurve struct {
+	Asset  string        `json:"asset"`
+	Assets []string      `json:"assets"`
+	Series []CurveSeries `json:"series"`
+}
+
+// CurveSeries is the part of a yield curve on one chain, in order of
+// maturity
+type CurveSeries struct {
+	Chain  string       `json:"chain"`
+	Points []CurvePoint `json:"points"`
+}
+
+// CurvePoint is one market on a yield curve
+type CurvePoint struct {
+	RateID         int64     `json:"rate_id"`
+	ProtocolName   string    `json:"protocol_name"`
+	PoolName       string    `json:"pool_name"`
+	MaturityDate   time.Time `json:"maturity_date"`
+	DaysToMaturity int       `json:"days_to_maturity"`
+	APY            float64   `json:"apy"`
+	TVL            float64   `json:"tvl"`
+}
+
+// BuildYieldCurves groups the rates with a maturity still ahead at now into
+// a yield curve per asset, sorted by asset, with the series of each sorted
+// by chain. Pendle markets of different assets wrapping the same underlying
+// token, like the PTs of eETH and weETH, go on one curve
+func BuildYieldCurves(rates []YieldRate, now time.Time) []YieldCurve {
+	var live []YieldRate
+	groups := curveGroups{}
+	for _, rate := range rates {
+		days, ok := rate.DaysToMaturity(now)
+		if !ok || days <= 0 {
+			continue
+		}
+		live = append(live, rate)
+		if rate.Pendle != nil && rate.Pendle.UnderlyingAsset != "" {
+			groups.join("asset:"+rate.Asset, "underlying:"+strings.ToLower(rate.Pendle.UnderlyingAsset))
+		}
+	}
+
+	byGroup := map[string]map[string][]CurvePoint{}
+	assets := map[string]map[string]bool{}
+	for _, rate := range live {
+		days, _ := rate.DaysToMaturity(now)
+		group := groups.find("asset:" + rate.Asset)
+		if byGroup[group] == nil {
+			byGroup[group] = map[string][]CurvePoint{}
+			assets[group] = map[string]bool{}
+		}
+		assets[group][rate.Asset] = true
+		byGroup[group][rate.Chain] = append(byGroup[group][rate.Chain], CurvePoint{
+			RateID:         rate.ID,
+			ProtocolName:   rate.ProtocolName,
+			PoolName:       rate.PoolName,
+			MaturityDate:   *rate.MaturityDate,
+			DaysToMaturity: days,
+			APY:            rate.APY,
+			TVL:            rate.TVL,
+		})
+	}
+
+	curves := make([]YieldCurve, 0, len(byGroup))
+	for group, chains := range byGroup {
+		curve := YieldCurve{}
+		for asset := range assets[group] {
+			curve.Assets = append(curve.Assets, asset)
+		}
+		sort.Strings(curve.Assets)
+		curve.Asset = strings.Join(curve.Assets, " / ")
+		for chain, points := range chains {
+			sort.SliceStable(points, func(i, j int) bool {
+				return points[i].MaturityDate.Before(points[j].MaturityDate)
+			})
+			curve.Series = append(curve.Series, CurveSeries{Chain: chain, Points: points})
+		}
+		sort.Slice(curve.Series, func(i, j int) bool {
+			return curve.Series[i].Chain < curve.Series[j].Chain
+		})
+		curves = append(curves, curve)
+	}
+	sort.Slice(curves, func(i, j int) bool {
+		return curves[i].Asset < curves[j].Asset
+	})
+	return curves
+}
+
+// curveGroups is a union-find of the assets and underlying tokens of rates,
+// so assets linked through a shared underlying token end up in one group
+type curveGroups map[string]string
+
+// find returns the group of key
+func (g curveGroups) find(key string) string {
+	for {
+		parent, ok := g[key]
+		if !ok || parent == key {
+			return key
+		}
+		g[key] = g[parent]
+		key = parent
+	}
+}
+
+// join puts a and b in one group
+func (g curveGroups) join(a, b string) {
+	rootA, rootB := g.find(a), g.find(b)
+	g[rootA] = rootA
+	g[rootB] = rootA
+}
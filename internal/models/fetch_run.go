@@ -0,0 +1,47 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Fetch run statuses
+const (
+	FetchRunRunning = "running"
+	FetchRunSuccess = "success"
+	FetchRunPartial = "partial" // Rates were stored but some chains or rows failed
+	FetchRunFailed  = "failed"
+)
+
+// FetchRun is the audit record of one source's fetch cycle
+type FetchRun struct {
+	ID              int64      `json:"id"`
+	Source          string     `json:"source"`
+	Status          string     `json:"status"`
+	StartedAt       time.Time  `json:"started_at"`
+	FinishedAt      *time.Time `json:"finished_at,omitempty"`
+	ChainsAttempted []string   `json:"chains_attempted"`
+	Received        int        `json:"markets_received"`    // Markets or pools returned by the source
+	Active          int        `json:"markets_active"`      // Markets kept and converted to rates
+	Expired         int        `json:"markets_expired"`     // Markets dropped because they matured
+	Unparseable     int        `json:"markets_unparseable"` // Markets dropped because they couldn't be parsed
+	Upserted        int        `json:"rows_upserted"`       // Rows written: inserted, updated or unchanged
+	Inserted        int        `json:"rows_inserted"`       // Pools stored for the first time
+	Updated         int        `json:"rows_updated"`        // Pools whose stored values changed
+	Unchanged       int        `json:"rows_unchanged"`      // Pools stored again with the same values
+	Failed          int        `json:"rows_failed"`
+	Errors          []string   `json:"errors"`
+}
+
+// AddError records a non-fatal error on the run
+func (r *FetchRun) AddError(format string, args ...interface{}) {
+	r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+}
+
+// Duration returns how long the run took, or has been running so far
+func (r *FetchRun) Duration() time.Duration {
+	if r.FinishedAt == nil {
+		return time.Since(r.StartedAt)
+	}
+	return r.FinishedAt.Sub(r.StartedAt)
+}
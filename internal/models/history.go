@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// History resolutions: raw observations, or hourly and daily aggregates
+const (
+	ResolutionRaw  = "raw"
+	ResolutionHour = "hour"
+	ResolutionDay  = "day"
+)
+
+// HistoryPoint is the APY and TVL of a pool over one period. Raw points are
+// single observations, with the same open, high, low and close APY
+type HistoryPoint struct {
+	At       time.Time `json:"at"` // Observation time, or start of the period
+	OpenAPY  float64   `json:"open_apy"`
+	HighAPY  float64   `json:"high_apy"`
+	LowAPY   float64   `json:"low_apy"`
+	CloseAPY float64   `json:"close_apy"`
+	TVL      float64   `json:"tvl"`     // Average TVL over the period
+	Samples  int       `json:"samples"` // Observations the point aggregates
+}
+
+// Merge folds next, a later point of the same period, into p
+func (p *HistoryPoint) Merge(next HistoryPoint) {
+	p.HighAPY = max(p.HighAPY, next.HighAPY)
+	p.LowAPY = min(p.LowAPY, next.LowAPY)
+	p.CloseAPY = next.CloseAPY
+	if samples := p.Samples + next.Samples; samples > 0 {
+		p.TVL = (p.TVL*float64(p.Samples) + next.TVL*float64(next.Samples)) / float64(samples)
+	}
+	p.Samples += next.Samples
+}
+
+// YieldHistory is the history of one pool over a time range
+type YieldHistory struct {
+	YieldRateID int64          `json:"yield_rate_id"`
+	Resolution  string         `json:"resolution"`
+	From        time.Time      `json:"from"`
+	To          time.Time      `json:"to"`
+	Points      []HistoryPoint `json:"points"`
+}
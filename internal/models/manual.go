@@ -0,0 +1,83 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Sources for rates that are maintained by hand rather than fetched from an API
+const (
+	SourceManual     = "Manual"      // Created through the admin API or CLI
+	SourceManualFile = "Manual file" // Re-read from the manual entries file every fetch cycle
+)
+
+// ManualProtocol groups off-chain and private deals that have no API
+var ManualProtocol = Protocol{
+	Name:        "Manual",
+	Description: "Off-chain and private deals entered by hand",
+}
+
+// IsManualSource reports whether rates from source are maintained by hand
+func IsManualSource(source string) bool {
+	return source == SourceManual || source == SourceManualFile
+}
+
+// ManualEntry is the input format for manual rates, shared by the admin API,
+// the CLI and the manual entries file
+type ManualEntry struct {
+	Asset        string  `json:"asset"`
+	Chain        string  `json:"chain"`
+	APY          float64 `json:"apy"`
+	BaseAPY      float64 `json:"base_apy"`
+	RewardAPY    float64 `json:"reward_apy"`
+	TVL          float64 `json:"tvl"`
+	MaturityDate string  `json:"maturity_date"` // "2006-01-02" or RFC3339, empty for open-ended
+	PoolName     string  `json:"pool_name"`     // Defaults to Asset
+	ExternalURL  string  `json:"external_url"`
+}
+
+// YieldRate converts the entry to a YieldRate under the Manual protocol
+func (e ManualEntry) YieldRate() (YieldRate, error) {
+	if e.Asset == "" || e.Chain == "" {
+		return YieldRate{}, fmt.Errorf("asset and chain are required")
+	}
+
+	maturityDate, err := ParseMaturityDate(e.MaturityDate)
+	if err != nil {
+		return YieldRate{}, err
+	}
+
+	poolName := e.PoolName
+	if poolName == "" {
+		poolName = e.Asset
+	}
+
+	return YieldRate{
+		ProtocolName: ManualProtocol.Name,
+		Asset:        e.Asset,
+		Chain:        e.Chain,
+		APY:          e.APY,
+		BaseAPY:      e.BaseAPY,
+		RewardAPY:    e.RewardAPY,
+		TVL:          e.TVL,
+		MaturityDate: maturityDate,
+		PoolName:     poolName,
+		ExternalURL:  e.ExternalURL,
+	}, nil
+}
+
+// ParseMaturityDate parses a "2006-01-02" or RFC3339 date into UTC; an empty
+// string means no maturity
+func ParseMaturityDate(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if date, err := time.Parse("2006-01-02", value); err == nil {
+		return &date, nil
+	}
+	if date, err := time.Parse(time.RFC3339, value); err == nil {
+		date = date.UTC()
+		return &date, nil
+	}
+	return nil, fmt.Errorf("invalid maturity date %q (want YYYY-MM-DD)", value)
+}
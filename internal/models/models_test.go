@@ -2,6 +2,7 @@ package models
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
 	"time"
 )
@@ -185,3 +186,212 @@ func TestFilterParams_WithValues(t *testing.T) {
 		})
 	}
 }
+
+// TestParseScopes tests parsing comma-separated scopes
+func TestParseScopes(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"read", "read", false},
+		{" Read, write ,read", "read,write", false},
+		{"admin,", "admin", false},
+		{"", "", true},
+		{"read,superuser", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseScopes(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseScopes(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if strings.Join(got, ",") != tt.want {
+				t.Errorf("ParseScopes(%q) = %v, want %s", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestHasScope tests that more privileged scopes include the lesser ones
+func TestHasScope(t *testing.T) {
+	tests := []struct {
+		granted []string
+		want    string
+		allowed bool
+	}{
+		{[]string{ScopeRead}, ScopeRead, true},
+		{[]string{ScopeRead}, ScopeWrite, false},
+		{[]string{ScopeWrite}, ScopeRead, true},
+		{[]string{ScopeWrite}, ScopeAdmin, false},
+		{[]string{ScopeRead, ScopeAdmin}, ScopeWrite, true},
+		{nil, ScopeRead, false},
+		{[]string{ScopeAdmin}, "superuser", false},
+	}
+
+	for _, tt := range tests {
+		if got := HasScope(tt.granted, tt.want); got != tt.allowed {
+			t.Errorf("HasScope(%v, %s) = %v, want %v", tt.granted, tt.want, got, tt.allowed)
+		}
+	}
+}
+
+// TestHistoryPoint_Merge tests folding a later point into a period
+func TestHistoryPoint_Merge(t *testing.T) {
+	point := HistoryPoint{OpenAPY: 5, HighAPY: 6, LowAPY: 4, CloseAPY: 5, TVL: 100, Samples: 3}
+	point.Merge(HistoryPoint{OpenAPY: 7, HighAPY: 8, LowAPY: 3, CloseAPY: 7.5, TVL: 200, Samples: 1})
+
+	want := HistoryPoint{OpenAPY: 5, HighAPY: 8, LowAPY: 3, CloseAPY: 7.5, TVL: 125, Samples: 4}
+	if point != want {
+		t.Errorf("Merge() = %+v, want %+v", point, want)
+	}
+}
+
+// TestBuildYieldCurves tests grouping rates into curves per asset with a
+// series per chain in order of maturity, leaving out variable and matured rates
+func TestBuildYieldCurves(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(days int) *time.Time {
+		maturity := now.AddDate(0, 0, days)
+		return &maturity
+	}
+	rates := []YieldRate{
+		{ID: 1, Asset: "sUSDe", Chain: "Ethereum", APY: 12, MaturityDate: at(90)},
+		{ID: 2, Asset: "sUSDe", Chain: "Ethereum", APY: 10, MaturityDate: at(30)},
+		{ID: 3, Asset: "sUSDe", Chain: "Arbitrum", APY: 11, MaturityDate: at(60)},
+		{ID: 4, Asset: "sUSDe", Chain: "Ethereum", APY: 20, MaturityDate: at(-1)},
+		{ID: 5, Asset: "USDC", Chain: "Ethereum", APY: 5},
+		{ID: 6, Asset: "eETH", Chain: "Ethereum", APY: 3, MaturityDate: at(180)},
+	}
+
+	curves := BuildYieldCurves(rates, now)
+	if len(curves) != 2 || curves[0].Asset != "eETH" || curves[1].Asset != "sUSDe" {
+		t.Fatalf("curves = %+v, want eETH and sUSDe", curves)
+	}
+	series := curves[1].Series
+	if len(series) != 2 || series[0].Chain != "Arbitrum" || series[1].Chain != "Ethereum" {
+		t.Fatalf("series = %+v, want Arbitrum and Ethereum", series)
+	}
+	points := series[1].Points
+	if len(points) != 2 || points[0].RateID != 2 || points[1].RateID != 1 {
+		t.Fatalf("Ethereum points = %+v, want rates 2 and 1", points)
+	}
+	if points[0].DaysToMaturity != 30 || points[1].DaysToMaturity != 90 {
+		t.Errorf("days to maturity = %d, %d, want 30, 90", points[0].DaysToMaturity, points[1].DaysToMaturity)
+	}
+}
+
+// TestBuildYieldCurves_SharedUnderlying tests that Pendle markets of assets
+// wrapping the same underlying token share a curve
+func TestBuildYieldCurves_SharedUnderlying(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(days int) *time.Time {
+		maturity := now.AddDate(0, 0, days)
+		return &maturity
+	}
+	rates := []YieldRate{
+		{ID: 1, Asset: "weETH", Chain: "Ethereum", APY: 4, MaturityDate: at(90), Pendle: &PendleMarket{UnderlyingAsset: "0xEETH"}},
+		{ID: 2, Asset: "eETH", Chain: "Ethereum", APY: 3, MaturityDate: at(30), Pendle: &PendleMarket{UnderlyingAsset: "0xeeth"}},
+		{ID: 3, Asset: "weETH", Chain: "Arbitrum", APY: 5, MaturityDate: at(60), Pendle: &PendleMarket{UnderlyingAsset: "0xarbeeth"}},
+		{ID: 4, Asset: "sUSDe", Chain: "Ethereum", APY: 10, MaturityDate: at(60), Pendle: &PendleMarket{UnderlyingAsset: "0xusde"}},
+		{ID: 5, Asset: "USDC", Chain: "Ethereum", APY: 6, MaturityDate: at(60)},
+	}
+
+	curves := BuildYieldCurves(rates, now)
+	if len(curves) != 3 {
+		t.Fatalf("curves = %+v, want 3", curves)
+	}
+	curve := curves[1]
+	if curve.Asset != "eETH / weETH" || len(curve.Assets) != 2 {
+		t.Fatalf("curve = %+v, want eETH and weETH together", curve)
+	}
+	if len(curve.Series) != 2 || curve.Series[0].Chain != "Arbitrum" || len(curve.Series[1].Points) != 2 {
+		t.Fatalf("series = %+v, want one Arbitrum and two Ethereum points", curve.Series)
+	}
+	if points := curve.Series[1].Points; points[0].RateID != 2 || points[1].RateID != 1 {
+		t.Errorf("Ethereum points = %+v, want rates 2 and 1", points)
+	}
+	if curves[0].Asset != "USDC" || curves[2].Asset != "sUSDe" {
+		t.Errorf("other curves = %s, %s, want USDC, sUSDe", curves[0].Asset, curves[2].Asset)
+	}
+}
+
+// TestProjectReturn tests projecting a deposit over a horizon or to maturity
+func TestProjectReturn(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(days int) *time.Time {
+		maturity := now.AddDate(0, 0, days)
+		return &maturity
+	}
+
+	tests := []struct {
+		name           string
+		rate           YieldRate
+		days           int
+		wantOK         bool
+		wantDays       int
+		wantToMaturity bool
+		wantReturn     float64
+		wantExceeds    bool
+	}{
+		{"variable for a year", YieldRate{APY: 10, TVL: 1e6}, 365, true, 365, false, 1000, false},
+		{"variable to maturity", YieldRate{APY: 10, TVL: 1e6}, 0, false, 0, false, 0, false},
+		{"fixed to maturity", YieldRate{APY: 21, TVL: 1e6, MaturityDate: at(730)}, 0, true, 730, true, 4641, false},
+		{"fixed maturing first", YieldRate{APY: 10, TVL: 1e6, MaturityDate: at(365)}, 730, true, 365, true, 1000, false},
+		{"fixed within horizon", YieldRate{APY: 10, TVL: 1e6, MaturityDate: at(730)}, 365, true, 365, false, 1000, false},
+		{"matured", YieldRate{APY: 10, TVL: 1e6, MaturityDate: at(-1)}, 0, false, 0, false, 0, false},
+		{"large share", YieldRate{APY: 10, TVL: 1e5}, 365, true, 365, false, 1000, true},
+		{"no TVL", YieldRate{APY: 10}, 365, true, 365, false, 1000, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, ok := ProjectReturn(tt.rate, 10000, tt.days, 0.05, now)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if p.Days != tt.wantDays || p.ToMaturity != tt.wantToMaturity {
+				t.Errorf("period = %d days, to maturity %v, want %d, %v", p.Days, p.ToMaturity, tt.wantDays, tt.wantToMaturity)
+			}
+			if diff := p.Return - tt.wantReturn; diff > 0.5 || diff < -0.5 {
+				t.Errorf("return = %.2f, want %.2f", p.Return, tt.wantReturn)
+			}
+			if diff := p.EffectiveYield - p.Return/100; diff > 1e-9 || diff < -1e-9 {
+				t.Errorf("effective yield = %.4f%%, want the return over the amount", p.EffectiveYield)
+			}
+			if p.ExceedsTVL != tt.wantExceeds {
+				t.Errorf("exceeds TVL = %v, want %v", p.ExceedsTVL, tt.wantExceeds)
+			}
+		})
+	}
+}
+
+// TestYieldRate_APYAtSize tests the APY estimate after price impact and fees
+func TestYieldRate_APYAtSize(t *testing.T) {
+	pendle := &PendleMarket{FeeRate: 0.002}
+	tests := []struct {
+		name   string
+		rate   YieldRate
+		size   float64
+		want   float64
+		wantOK bool
+	}{
+		{"deep pool", YieldRate{APY: 10, TVL: 9.9e6, Pendle: pendle}, 100000, 9.7, true},
+		{"thin pool", YieldRate{APY: 30, TVL: 100000, Pendle: pendle}, 100000, 14.8, true},
+		{"not Pendle", YieldRate{APY: 10, TVL: 100000}, 100000, 10, false},
+		{"no size", YieldRate{APY: 10, TVL: 100000, Pendle: pendle}, 0, 10, false},
+		{"no liquidity", YieldRate{APY: 10, TVL: 0, Pendle: pendle}, 100000, 10, false},
+		{"negative TVL", YieldRate{APY: 10, TVL: -1, Pendle: pendle}, 100000, 10, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.rate.APYAtSize(tt.size)
+			if ok != tt.wantOK || got < tt.want-1e-9 || got > tt.want+1e-9 {
+				t.Errorf("APYAtSize(%v) = %v, %v, want %v, %v", tt.size, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// Profile owns saved filters and a watchlist. Profiles start out anonymous,
+// identified by the token in a browser cookie; UserID links a profile to a
+// user account once it has one
+type Profile struct {
+	ID        int64     `json:"id"`
+	Token     string    `json:"-"`
+	UserID    *int64    `json:"user_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SavedFilter is a named set of filter form values. Anyone with its slug can
+// open it at /f/{slug}; only its profile can change or delete it
+type SavedFilter struct {
+	ID        int64     `json:"id"`
+	ProfileID int64     `json:"-"`
+	Name      string    `json:"name"`
+	Slug      string    `json:"slug"`
+	Query     string    `json:"query"` // Encoded query string, e.g. "asset=USDC&min_apy=5"
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// URL returns the short link that opens the saved filter
+func (f SavedFilter) URL() string {
+	return "/f/" + f.Slug
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// APY move directions of a RateChange
+const (
+	DirectionUp   = "up"
+	DirectionDown = "down"
+	DirectionNew  = "new"
+)
+
+// RateChange describes how a fetch moved the APY of one pool
+type RateChange struct {
+	ID        int64   `json:"id"`
+	PoolName  string  `json:"pool_name"`
+	Chain     string  `json:"chain"`
+	OldAPY    float64 `json:"old_apy"`
+	APY       float64 `json:"apy"`
+	Direction string  `json:"direction"`
+}
+
+// RatesUpdate is published after a fetch run stored rates, listing the pools
+// whose APY changed. Changes is empty, not nil, when none did
+type RatesUpdate struct {
+	Source  string       `json:"source"`
+	RunID   int64        `json:"run_id"`
+	At      time.Time    `json:"at"`
+	Changes []RateChange `json:"changes"`
+}
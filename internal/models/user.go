@@ -0,0 +1,75 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Scopes granted to users and API keys. Each scope includes the ones before
+// it: write can also read, and admin can do everything
+const (
+	ScopeRead  = "read"  // Dashboard, rates API, saved filters and watchlist
+	ScopeWrite = "write" // Manual entries
+	ScopeAdmin = "admin" // Refreshes, fetch runs and everything else
+)
+
+// AllScopes lists the scopes from least to most privileged
+var AllScopes = []string{ScopeRead, ScopeWrite, ScopeAdmin}
+
+// ParseScopes parses a comma-separated list of scopes
+func ParseScopes(s string) ([]string, error) {
+	var scopes []string
+	for _, scope := range strings.Split(s, ",") {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if scope == "" {
+			continue
+		}
+		if !slices.Contains(AllScopes, scope) {
+			return nil, fmt.Errorf("unknown scope %q (want %s)", scope, strings.Join(AllScopes, ", "))
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("at least one scope is required")
+	}
+	return scopes, nil
+}
+
+// HasScope reports whether granted includes want, directly or through a more
+// privileged scope
+func HasScope(granted []string, want string) bool {
+	level := slices.Index(AllScopes, want)
+	if level < 0 {
+		return false
+	}
+	for _, scope := range granted {
+		if slices.Index(AllScopes, scope) >= level {
+			return true
+		}
+	}
+	return false
+}
+
+// User is a local account that can log in to the dashboard and own API keys
+type User struct {
+	ID        int64     `json:"id"`
+	Username  string    `json:"username"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// APIKey authenticates programmatic access on behalf of a user. Only a hash
+// of the key is stored; the key itself is shown once when it is created
+type APIKey struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // First characters of the key, to tell keys apart
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"math"
+	"time"
+)
 
 // Protocol represents a DeFi protocol
 type Protocol struct {
@@ -13,20 +16,56 @@ type Protocol struct {
 
 // YieldRate represents a yield opportunity from a protocol
 type YieldRate struct {
-	ID           int64     `json:"id"`
-	ProtocolID   int64     `json:"protocol_id"`
-	ProtocolName string    `json:"protocol_name"`
-	Asset        string    `json:"asset"`        // e.g., "ETH", "USDC"
-	Chain        string    `json:"chain"`        // e.g., "Ethereum", "Arbitrum"
-	APY          float64   `json:"apy"`          // Annual Percentage Yield
-	TVL          float64   `json:"tvl"`          // Total Value Locked
-	MaturityDate *time.Time `json:"maturity_date,omitempty"` // For fixed-term yields like Pendle
-	PoolName     string    `json:"pool_name"`    // Specific pool identifier
-	ExternalURL  string    `json:"external_url"` // Link to the actual pool
-	UpdatedAt    time.Time `json:"updated_at"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID           int64         `json:"id"`
+	ProtocolID   int64         `json:"protocol_id"`
+	ProtocolName string        `json:"protocol_name"`
+	Asset        string        `json:"asset"`                   // e.g., "ETH", "USDC"
+	Chain        string        `json:"chain"`                   // e.g., "Ethereum", "Arbitrum"
+	APY          float64       `json:"apy"`                     // Annual Percentage Yield
+	BaseAPY      float64       `json:"base_apy"`                // Organic part of APY (trading fees, interest)
+	RewardAPY    float64       `json:"reward_apy"`              // Incentive part of APY (token emissions)
+	Rewards      []RewardAPY   `json:"rewards,omitempty"`       // Per-token breakdown of RewardAPY
+	TVL          float64       `json:"tvl"`                     // Total Value Locked
+	MaturityDate *time.Time    `json:"maturity_date,omitempty"` // For fixed-term yields like Pendle
+	PoolName     string        `json:"pool_name"`               // Specific pool identifier
+	ExternalURL  string        `json:"external_url"`            // Link to the actual pool
+	Source       string        `json:"source"`                  // Name of the source that wrote the rate
+	Categories   []string      `json:"categories,omitempty"`    // e.g., "stables", "eth", "points"
+	Pendle       *PendleMarket `json:"pendle,omitempty"`        // Market metadata of Pendle rates
+	UpdatedAt    time.Time     `json:"updated_at"`
+	CreatedAt    time.Time     `json:"created_at"`
+
+	// Changes against the pool's last observation at least 24h or 7d old;
+	// nil when the pool has no history that old
+	APYChange24h *float64 `json:"apy_change_24h"`
+	APYChange7d  *float64 `json:"apy_change_7d"`
+	TVLChange24h *float64 `json:"tvl_change_24h"`
+	TVLChange7d  *float64 `json:"tvl_change_7d"`
+}
+
+// RewardAPY is the incentive yield paid out in a single reward token
+type RewardAPY struct {
+	Token string  `json:"token"` // e.g., "CRV", "CVX"
+	APY   float64 `json:"apy"`
 }
 
+// PendleMarket is the metadata Pendle publishes about a market: the
+// addresses of its tokens on the rate's chain and its swap fee
+type PendleMarket struct {
+	Address         string  `json:"address"`
+	PT              string  `json:"pt"`               // Principal token
+	YT              string  `json:"yt"`               // Yield token
+	SY              string  `json:"sy"`               // Standardized yield token
+	UnderlyingAsset string  `json:"underlying_asset"` // Token the SY wraps
+	FeeRate         float64 `json:"fee_rate"`         // Swap fee charged on the implied APY, e.g. 0.001 for 0.1%
+}
+
+// Terms of the FilterParams.Term filter
+const (
+	TermFixed    = "fixed"    // Rates with a maturity date, like Pendle PTs
+	TermVariable = "variable" // Rates without one
+)
+
 // FilterParams for querying yield rates
 type FilterParams struct {
 	MinAPY       float64
@@ -35,6 +74,39 @@ type FilterParams struct {
 	Asset        string
 	Chain        string
 	ProtocolName string
-	SortBy       string // "apy", "tvl", "updated_at"
-	SortOrder    string // "asc", "desc"
+	Category     string
+	// Maturity horizon; any of these leaves out rates without a maturity
+	MinDaysToMaturity int
+	MaxDaysToMaturity int
+	MaturityAfter     time.Time // Maturing after this day
+	MaturityBefore    time.Time // Maturing before this day
+	Term              string    // TermFixed, TermVariable or "" for both
+	SortBy            string    // "apy", "tvl", "updated_at", "apy_change_24h", "maturity", "apy_at_size"
+	SortOrder         string    // "asc", "desc"
+	TradeSize         float64   // USD trade size of the "apy_at_size" sort, DefaultTradeSize if 0
+}
+
+// DefaultTradeSize is the trade size in USD the APY after price impact is
+// estimated for unless another is given
+const DefaultTradeSize = 100000
+
+// DaysToMaturity returns the days left until the rate matures at now,
+// counting a part of a day as a day, and false for rates without a maturity.
+// Matured rates have zero or fewer days left
+func (r *YieldRate) DaysToMaturity(now time.Time) (int, bool) {
+	if r.MaturityDate == nil {
+		return 0, false
+	}
+	return int(math.Ceil(r.MaturityDate.Sub(now).Hours() / 24)), true
+}
+
+// APYAtSize estimates the APY of buying into a Pendle market with a trade
+// of size USD. The trade moves the implied APY by its share of the pool's
+// liquidity, and the swap fee is charged on what is left. Other rates and
+// pools without liquidity have no estimate; their APY is returned with false
+func (r *YieldRate) APYAtSize(size float64) (float64, bool) {
+	if r.Pendle == nil || size <= 0 || r.TVL <= 0 {
+		return r.APY, false
+	}
+	return r.APY*r.TVL/(r.TVL+size) - r.Pendle.FeeRate*100, true
 }
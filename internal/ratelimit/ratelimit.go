@@ -0,0 +1,200 @@
+// Package ratelimit implements in-memory token bucket rate limiting per client
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit allows Requests per Per on average, with bursts of up to Burst
+// requests. A zero Limit allows everything
+type Limit struct {
+	Requests int
+	Per      time.Duration
+	Burst    int
+}
+
+// Unlimited reports whether the limit allows everything
+func (l Limit) Unlimited() bool {
+	return l.Requests <= 0 || l.Per <= 0
+}
+
+// String formats the limit the way ParseLimits reads it
+func (l Limit) String() string {
+	if l.Unlimited() {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s:%d", l.Requests, l.Per, l.Burst)
+}
+
+// interval is how long it takes to earn back one request
+func (l Limit) interval() time.Duration {
+	return l.Per / time.Duration(l.Requests)
+}
+
+// ParseLimit parses "REQUESTS/PERIOD[:BURST]", e.g. "60/1m:20", or "off".
+// The period may leave out a leading 1 ("60/m"); the burst defaults to the
+// number of requests
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "off" {
+		return Limit{}, nil
+	}
+
+	rate, burst, hasBurst := strings.Cut(s, ":")
+	requests, period, ok := strings.Cut(rate, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid rate limit %q (want REQUESTS/PERIOD[:BURST], e.g. 60/1m:20)", s)
+	}
+
+	var limit Limit
+	var err error
+	if limit.Requests, err = strconv.Atoi(requests); err != nil || limit.Requests <= 0 {
+		return Limit{}, fmt.Errorf("invalid request count in rate limit %q", s)
+	}
+	if period != "" && (period[0] < '0' || period[0] > '9') {
+		period = "1" + period
+	}
+	if limit.Per, err = time.ParseDuration(period); err != nil || limit.Per <= 0 {
+		return Limit{}, fmt.Errorf("invalid period in rate limit %q", s)
+	}
+	limit.Burst = limit.Requests
+	if hasBurst {
+		if limit.Burst, err = strconv.Atoi(burst); err != nil || limit.Burst <= 0 {
+			return Limit{}, fmt.Errorf("invalid burst in rate limit %q", s)
+		}
+	}
+	return limit, nil
+}
+
+// ParseLimits parses comma-separated per-group limits, e.g.
+// "api=120/1m:30,login=off"
+func ParseLimits(s string) (map[string]Limit, error) {
+	limits := make(map[string]Limit)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		group, value, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(group) == "" {
+			return nil, fmt.Errorf("invalid rate limit %q (want GROUP=REQUESTS/PERIOD[:BURST])", entry)
+		}
+		limit, err := ParseLimit(value)
+		if err != nil {
+			return nil, fmt.Errorf("group %s: %w", strings.TrimSpace(group), err)
+		}
+		limits[strings.ToLower(strings.TrimSpace(group))] = limit
+	}
+	return limits, nil
+}
+
+// Result describes the outcome of a request against a client's bucket
+type Result struct {
+	Allowed    bool
+	Limit      int           // Burst size, the most requests a client can make at once
+	Remaining  int           // Requests the client can still make right away
+	RetryAfter time.Duration // Wait before the next request is allowed; zero when allowed
+	Reset      time.Duration // Wait until the bucket is full again
+}
+
+// bucket holds the tokens of one client. Tokens are only updated when the
+// client makes a request
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// sweepInterval is how often idle buckets are dropped
+const sweepInterval = time.Minute
+
+// Limiter keeps a token bucket per client key. It is safe for concurrent use
+type Limiter struct {
+	limit Limit
+	now   func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// New creates a limiter. now is the clock, time.Now when nil
+func New(limit Limit, now func() time.Time) *Limiter {
+	if now == nil {
+		now = time.Now
+	}
+	return &Limiter{
+		limit:   limit,
+		now:     now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Limit returns the limit the limiter enforces
+func (l *Limiter) Limit() Limit {
+	return l.limit
+}
+
+// Allow takes a token from the bucket of key if it has one
+func (l *Limiter) Allow(key string) Result {
+	if l.limit.Unlimited() {
+		return Result{Allowed: true}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	burst := float64(l.limit.Burst)
+	perToken := l.limit.interval()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+float64(elapsed)/float64(perToken))
+	}
+	b.last = now
+
+	result := Result{Limit: l.limit.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - b.tokens) * float64(perToken))
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = time.Duration((burst - b.tokens) * float64(perToken))
+	return result
+}
+
+// sweep drops the buckets that have refilled completely, which behave the
+// same as new ones. Callers hold l.mu
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	full := time.Duration(l.limit.Burst) * l.limit.interval()
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Clients returns how many clients have buckets, for tests and metrics
+func (l *Limiter) Clients() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
@@ -0,0 +1,144 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually advanced clock for New
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+// newTestLimiter creates a limiter driven by a fake clock
+func newTestLimiter(limit Limit) (*Limiter, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	return New(limit, clock.Now), clock
+}
+
+// TestLimiter_Burst tests that a burst is allowed and then refilled over time
+func TestLimiter_Burst(t *testing.T) {
+	limiter, clock := newTestLimiter(Limit{Requests: 60, Per: time.Minute, Burst: 3})
+
+	for i := 0; i < 3; i++ {
+		result := limiter.Allow("client")
+		if !result.Allowed || result.Remaining != 2-i || result.Limit != 3 {
+			t.Fatalf("request %d = %+v, want allowed with %d remaining", i+1, result, 2-i)
+		}
+	}
+
+	result := limiter.Allow("client")
+	if result.Allowed {
+		t.Fatal("request over the burst should be refused")
+	}
+	if result.RetryAfter != time.Second || result.Reset != 3*time.Second {
+		t.Errorf("refused result = %+v, want RetryAfter 1s and Reset 3s", result)
+	}
+
+	// Other clients have their own bucket
+	if !limiter.Allow("other").Allowed {
+		t.Error("another client should not be limited")
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	if result := limiter.Allow("client"); result.Allowed || result.RetryAfter != 500*time.Millisecond {
+		t.Errorf("after 0.5s = %+v, want refused with RetryAfter 0.5s", result)
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	if !limiter.Allow("client").Allowed {
+		t.Error("a token should be back after 1s")
+	}
+
+	// Idle time refills up to the burst, not beyond
+	clock.Advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		if !limiter.Allow("client").Allowed {
+			t.Fatalf("request %d after an hour should be allowed", i+1)
+		}
+	}
+	if limiter.Allow("client").Allowed {
+		t.Error("idle time should not raise the burst")
+	}
+}
+
+// TestLimiter_Sweep tests that idle clients are forgotten
+func TestLimiter_Sweep(t *testing.T) {
+	limiter, clock := newTestLimiter(Limit{Requests: 10, Per: time.Second, Burst: 5})
+
+	limiter.Allow("a")
+	limiter.Allow("b")
+	if limiter.Clients() != 2 {
+		t.Fatalf("Clients() = %d, want 2", limiter.Clients())
+	}
+
+	clock.Advance(2 * sweepInterval)
+	limiter.Allow("c")
+	if limiter.Clients() != 1 {
+		t.Errorf("Clients() after a sweep = %d, want 1", limiter.Clients())
+	}
+}
+
+// TestLimiter_Unlimited tests that a zero limit allows everything
+func TestLimiter_Unlimited(t *testing.T) {
+	limiter, _ := newTestLimiter(Limit{})
+	for i := 0; i < 1000; i++ {
+		if !limiter.Allow("client").Allowed {
+			t.Fatal("an unlimited limiter should allow every request")
+		}
+	}
+	if limiter.Clients() != 0 {
+		t.Error("an unlimited limiter should not keep buckets")
+	}
+}
+
+// TestParseLimit tests the rate limit syntax
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Limit
+		wantErr bool
+	}{
+		{"60/1m:20", Limit{60, time.Minute, 20}, false},
+		{"60/m", Limit{60, time.Minute, 60}, false},
+		{"5/10s:1", Limit{5, 10 * time.Second, 1}, false},
+		{"off", Limit{}, false},
+		{"60", Limit{}, true},
+		{"0/m", Limit{}, true},
+		{"60/fortnight", Limit{}, true},
+		{"60/m:0", Limit{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseLimit(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLimit(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseLimit(%q) = %+v, want %+v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestParseLimits tests per-group limits
+func TestParseLimits(t *testing.T) {
+	limits, err := ParseLimits("API=120/1m:30, login=off")
+	if err != nil {
+		t.Fatalf("ParseLimits() error = %v", err)
+	}
+	if limits["api"] != (Limit{120, time.Minute, 30}) || !limits["login"].Unlimited() || len(limits) != 2 {
+		t.Errorf("ParseLimits() = %+v", limits)
+	}
+
+	for _, bad := range []string{"api", "=60/m", "api=sixty/m"} {
+		if _, err := ParseLimits(bad); err == nil {
+			t.Errorf("ParseLimits(%q) should fail", bad)
+		}
+	}
+}